@@ -0,0 +1,106 @@
+package main
+
+import (
+	"gorm.io/gorm"
+
+	"dt-backend/controller/auth"
+)
+
+// registerAccountHooks lets the auth package export and delete what diary.db keeps about a user
+func registerAccountHooks() {
+	auth.RegisterAccountExporter("diary", exportDiaryData)
+	auth.RegisterAccountDeleter(deleteDiaryData)
+}
+
+// exportDiaryData collects the user's rows from diary.db. Attachments are listed
+// without their file contents.
+func exportDiaryData(username string) (interface{}, error) {
+	var (
+		entries     []DiaryEntry
+		preferences []UserPreference
+		comments    []Comment
+		attachments []Attachment
+		digests     []Digest
+		notes       []Notification
+		dismissals  []AlertDismissal
+		scores      []ScoreSnapshot
+		summaries   []SummarySnapshot
+		schedules   []DigestSchedule
+		goals       []WritingGoal
+	)
+	steps := []struct {
+		query *gorm.DB
+		dest  interface{}
+	}{
+		{DB.Preload("Reflections", orderedReflections).Order("created_at"), &entries},
+		{DB.Order("created_at"), &preferences},
+		{DB.Order("created_at"), &comments},
+		{DB.Order("created_at"), &attachments},
+		{DB.Order("period_start"), &digests},
+		{DB.Order("created_at"), &notes},
+		{DB, &dismissals},
+		{DB.Order("date"), &scores},
+		{DB.Order("created_at"), &summaries},
+		{DB, &schedules},
+		{DB, &goals},
+	}
+	for _, step := range steps {
+		if err := step.query.Where("username = ?", username).Find(step.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{
+		"entries":          entries,
+		"preferences":      preferences,
+		"commentsWritten":  comments,
+		"attachments":      attachments,
+		"digests":          digests,
+		"notifications":    notes,
+		"alertDismissals":  dismissals,
+		"scoreSnapshots":   scores,
+		"summarySnapshots": summaries,
+		"digestSchedule":   schedules,
+		"writingGoal":      goals,
+	}, nil
+}
+
+// deleteDiaryData removes the user's diary.db rows in one transaction. Comments
+// the user left on other people's entries stay in those threads but become anonymous.
+// Attachment files are deleted once the rows are gone.
+func deleteDiaryData(username string) error {
+	var attachments []Attachment
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		tx.Where("username = ?", username).Find(&attachments)
+
+		entries := tx.Model(&DiaryEntry{}).Select("id").Where("username = ?", username)
+		if err := tx.Where("diary_entry_id IN (?)", entries).Delete(&ReflectionHistory{}).Error; err != nil {
+			return err
+		}
+		// Everyone's comments on the user's own entries go with the entries
+		if err := tx.Where("diary_id IN (?)", entries).Delete(&Comment{}).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&Comment{}).Where("username = ?", username).
+			Updates(map[string]interface{}{"username": "Anonymous", "is_anonymous": true}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&EntryEmbedding{}, &Attachment{}, &DiaryEntry{}, &UserPreference{},
+			&ScoreSnapshot{}, &SummarySnapshot{}, &DigestSchedule{}, &Digest{}, &Notification{},
+			&AlertDismissal{}, &WritingGoal{}} {
+			if err := tx.Where("username = ?", username).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteAttachmentFiles(attachments)
+	summaryCache.Invalidate(username)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"dt-backend/controller/auth"
+)
+
+// blockingEmbedder holds every embedding call until release is closed
+type blockingEmbedder struct {
+	*localEmbedder
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.localEmbedder.Embed(ctx, text)
+}
+
+func TestDeleteAccountLeavesNoDiaryData(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+
+	r := testRouter("alice")
+	r.POST("/entries", CreateEntry)
+	r.DELETE("/account", auth.DeleteAccount)
+
+	// A row in every table
+	now := time.Now()
+	old := DiaryEntry{Username: "alice", Title: "งาน", Content: "เหนื่อยกับงาน", Status: "over_it", CreatedAt: now.AddDate(0, -1, 0), UnlockAt: now}
+	DB.Create(&old)
+	rows := []interface{}{
+		&ReflectionHistory{DiaryEntryID: old.ID, Content: "ดีขึ้นแล้ว"},
+		&Comment{DiaryID: old.ID, Username: "alice", Content: "ผ่านมาได้"},
+		&EntryEmbedding{Username: "alice", DiaryEntryID: old.ID, Model: "old", Vector: encodeVector([]float32{1})},
+		&Attachment{Username: "alice", DiaryEntryID: old.ID, Kind: "image", StorageKey: "alice/1.png"},
+		&UserPreference{Username: "alice"},
+		&ScoreSnapshot{Username: "alice", Date: "2026-01-01"},
+		&SummarySnapshot{Username: "alice"},
+		&DigestSchedule{Username: "alice"},
+		&Digest{Username: "alice", Period: "weekly", PeriodStart: now},
+		&Notification{Username: "alice", Kind: "reminder"},
+		&AlertDismissal{Username: "alice", AlertKey: "trigger:งาน"},
+		&WritingGoal{Username: "alice"},
+	}
+	for _, row := range rows {
+		if err := DB.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Indexing of a new entry is still running when the account goes
+	slow := &blockingEmbedder{localEmbedder: &localEmbedder{dims: 64}, started: make(chan struct{}, 4), release: make(chan struct{})}
+	embedder = slow
+	if w := doJSON(r, http.MethodPost, "/entries", gin.H{"title": "งาน", "content": "เหนื่อยกับงานอีกแล้ว"}); w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d %s", w.Code, w.Body)
+	}
+	<-slow.started
+
+	w := doJSON(r, http.MethodDelete, "/account", gin.H{"password": testPassword, "confirm": "alice"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d %s", w.Code, w.Body)
+	}
+	close(slow.release)
+	background.Wait()
+
+	if auth.UserExists("alice") {
+		t.Error("account still exists")
+	}
+	tables, err := DB.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if strings.HasPrefix(table, "sqlite_") {
+			continue
+		}
+		var count int64
+		DB.Table(table).Count(&count)
+		if count != 0 {
+			t.Errorf("%s has %d rows", table, count)
+		}
+	}
+
+	// Work started after the account is gone does nothing
+	indexEntry(DiaryEntry{ID: old.ID, Username: "alice", Content: "x"})
+	checkRelapse(DiaryEntry{ID: old.ID, Username: "alice"})
+	var embeddings, notes int64
+	DB.Model(&EntryEmbedding{}).Count(&embeddings)
+	DB.Model(&Notification{}).Count(&notes)
+	if embeddings != 0 || notes != 0 {
+		t.Errorf("embeddings = %d, notifications = %d", embeddings, notes)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp"
+)
+
+// Storage keeps attachment bytes. Local disk for now; an S3-compatible
+// implementation only needs these three methods.
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Attachment is an image or audio file linked to an entry or one of its reflections
+type Attachment struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"index"`
+	DiaryEntryID uint      `json:"diaryEntryId" gorm:"index"`
+	ReflectionID uint      `json:"reflectionId"` // 0 = attached to the entry itself
+	Kind         string    `json:"kind"`         // image, audio
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	HasThumbnail bool      `json:"hasThumbnail"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+const (
+	maxImageSize = 10 << 20
+	maxAudioSize = 25 << 20
+
+	thumbnailSize = 256
+
+	// A small file can declare huge dimensions, so images are checked before decoding
+	maxImagePixels = 40_000_000
+
+	// attachWindow lets users add files right after writing, before the lock kicks in
+	attachWindow = 10 * time.Minute
+)
+
+// attachmentTypes maps sniffed content types to attachment kinds
+var attachmentTypes = map[string]string{
+	"image/jpeg":      "image",
+	"image/png":       "image",
+	"image/gif":       "image",
+	"image/webp":      "image",
+	"audio/mpeg":      "audio",
+	"audio/wave":      "audio",
+	"audio/wav":       "audio",
+	"audio/aiff":      "audio",
+	"audio/ogg":       "audio",
+	"application/ogg": "audio",
+	"audio/mp4":       "audio",
+	// Browsers record voice memos as webm/mp4 containers, which sniff as video
+	"video/webm": "audio",
+	"video/mp4":  "audio",
+}
+
+var storage Storage
+
+func initStorage() {
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	storage = &LocalStorage{Root: dir}
+}
+
+// --- Local filesystem storage ---
+type LocalStorage struct {
+	Root string
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func (s *LocalStorage) Put(key string, r io.Reader) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+func (s *LocalStorage) Open(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// --- Helpers ---
+
+func newStorageKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return time.Now().Format("2006/01/") + hex.EncodeToString(b)
+}
+
+// canAttach reports whether files may be added to the entry, or to the
+// reflection written at reflectedAt (zero for the entry itself), right now
+func canAttach(entry DiaryEntry, reflectedAt time.Time, now time.Time) bool {
+	if !now.Before(entry.UnlockAt) {
+		return true
+	}
+	// A locked entry still accepts files right after it was written, and a
+	// reflection right after it was saved, although Respond locks the entry again
+	if reflectedAt.IsZero() {
+		return now.Sub(entry.CreatedAt) < attachWindow
+	}
+	return now.Sub(reflectedAt) < attachWindow
+}
+
+// checkImageSize reads only the image header and rejects images too large to decode
+func checkImageSize(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unreadable image")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return fmt.Errorf("images must be at most %d megapixels", maxImagePixels/1_000_000)
+	}
+	return nil
+}
+
+// makeThumbnail scales an image down to fit in thumbnailSize x thumbnailSize
+func makeThumbnail(data []byte) ([]byte, error) {
+	if err := checkImageSize(data); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	scale := float64(thumbnailSize) / float64(max(w, h))
+	if scale > 1 {
+		scale = 1
+	}
+	tw, th := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*w/tw, b.Min.Y+y*h/th))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deleteAttachmentFiles removes the stored files of the given attachments
+func deleteAttachmentFiles(attachments []Attachment) {
+	for _, a := range attachments {
+		if err := storage.Delete(a.StorageKey); err != nil {
+			log.Printf("Failed to delete attachment %d: %v", a.ID, err)
+		}
+		if a.ThumbnailKey != "" {
+			storage.Delete(a.ThumbnailKey)
+		}
+	}
+}
+
+// saveAttachment validates and stores an uploaded file for the entry
+func saveAttachment(entry DiaryEntry, reflectionID uint, data []byte, declaredType string) (*Attachment, int, error) {
+	contentType := http.DetectContentType(data)
+	kind, ok := attachmentTypes[contentType]
+	if !ok {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported file type: %s", contentType)
+	}
+	if strings.HasPrefix(contentType, "video/") {
+		if !strings.HasPrefix(declaredType, "audio/") {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported file type: %s", contentType)
+		}
+		contentType = declaredType
+	}
+
+	limit := int64(maxImageSize)
+	if kind == "audio" {
+		limit = maxAudioSize
+	}
+	if int64(len(data)) > limit {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("%s files must be at most %d MB", kind, limit>>20)
+	}
+	if kind == "image" {
+		if err := checkImageSize(data); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	attachment := Attachment{
+		Username:     entry.Username,
+		DiaryEntryID: entry.ID,
+		ReflectionID: reflectionID,
+		Kind:         kind,
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		StorageKey:   newStorageKey(),
+	}
+
+	if err := storage.Put(attachment.StorageKey, bytes.NewReader(data)); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to store file")
+	}
+
+	if kind == "image" {
+		if thumb, err := makeThumbnail(data); err == nil {
+			attachment.ThumbnailKey = attachment.StorageKey + "_thumb"
+			if err := storage.Put(attachment.ThumbnailKey, bytes.NewReader(thumb)); err == nil {
+				attachment.HasThumbnail = true
+			} else {
+				attachment.ThumbnailKey = ""
+			}
+		}
+	}
+
+	if err := DB.Create(&attachment).Error; err != nil {
+		deleteAttachmentFiles([]Attachment{attachment})
+		return nil, http.StatusInternalServerError, err
+	}
+	return &attachment, http.StatusCreated, nil
+}
+
+// readUpload reads the "file" form field, rejecting anything over the audio limit
+func readUpload(c *gin.Context) ([]byte, string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAudioSize+(1<<20))
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", fmt.Errorf("file is required")
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxAudioSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fileHeader.Header.Get("Content-Type"), nil
+}
+
+// --- Handlers ---
+
+// UploadAttachment adds an image or audio file to an entry or one of its reflections
+func UploadAttachment(c *gin.Context) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+
+	var reflectionID uint
+	var reflectedAt time.Time
+	if v := c.PostForm("reflectionId"); v != "" {
+		rid, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reflectionId"})
+			return
+		}
+		var history ReflectionHistory
+		if err := DB.Where("id = ? AND diary_entry_id = ?", rid, entry.ID).First(&history).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reflection not found"})
+			return
+		}
+		reflectionID, reflectedAt = history.ID, history.CreatedAt
+	}
+
+	if !canAttach(entry, reflectedAt, time.Now()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Entry is locked"})
+		return
+	}
+
+	data, declaredType, err := readUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, status, err := saveAttachment(entry, reflectionID, data, declaredType)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// GetAttachments lists the attachments of an entry (hidden while it is locked)
+func GetAttachments(c *gin.Context) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+
+	if time.Now().Before(entry.UnlockAt) {
+		c.JSON(http.StatusOK, gin.H{"attachments": []Attachment{}, "isLocked": true})
+		return
+	}
+
+	var attachments []Attachment
+	DB.Where("diary_entry_id = ?", entry.ID).Order("created_at asc").Find(&attachments)
+	c.JSON(http.StatusOK, gin.H{"attachments": attachments, "isLocked": false})
+}
+
+// DownloadAttachment streams the attachment file (or its thumbnail)
+func DownloadAttachment(c *gin.Context) {
+	attachment, ok := loadAttachment(c, false)
+	if !ok {
+		return
+	}
+
+	key := attachment.StorageKey
+	contentType := attachment.ContentType
+	if strings.HasSuffix(c.FullPath(), "/thumbnail") {
+		if !attachment.HasThumbnail {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this attachment"})
+			return
+		}
+		key = attachment.ThumbnailKey
+		contentType = "image/jpeg"
+	}
+
+	f, err := storage.Open(key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	defer f.Close()
+
+	c.Header("Cache-Control", "private, no-store")
+	c.DataFromReader(http.StatusOK, -1, contentType, f, nil)
+}
+
+// DeleteAttachment removes an attachment and its files.
+// Like uploads, this is allowed right after writing so a wrong file can be taken back.
+func DeleteAttachment(c *gin.Context) {
+	attachment, ok := loadAttachment(c, true)
+	if !ok {
+		return
+	}
+
+	DB.Delete(&attachment)
+	deleteAttachmentFiles([]Attachment{attachment})
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted", "id": attachment.ID})
+}
+
+// loadAttachment finds the user's attachment and enforces the entry lock
+func loadAttachment(c *gin.Context, allowAttachWindow bool) (Attachment, bool) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var attachment Attachment
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return attachment, false
+	}
+
+	var entry DiaryEntry
+	if err := DB.First(&entry, attachment.DiaryEntryID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return attachment, false
+	}
+
+	now := time.Now()
+	locked := now.Before(entry.UnlockAt)
+	if allowAttachWindow {
+		var reflectedAt time.Time
+		if attachment.ReflectionID != 0 {
+			var history ReflectionHistory
+			if err := DB.Where("id = ? AND diary_entry_id = ?", attachment.ReflectionID, entry.ID).First(&history).Error; err == nil {
+				reflectedAt = history.CreatedAt
+			} else {
+				// A reflection that no longer exists gives no window
+				reflectedAt = now.Add(-attachWindow)
+			}
+		}
+		locked = !canAttach(entry, reflectedAt, now)
+	}
+	if locked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Entry is locked"})
+		return attachment, false
+	}
+
+	return attachment, true
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func attachmentRouter(username string) *gin.Engine {
+	r := testRouter(username)
+	r.POST("/entries/:id/respond", Respond)
+	r.POST("/entries/:id/attachments", UploadAttachment)
+	r.GET("/attachments/:id", DownloadAttachment)
+	r.GET("/attachments/:id/thumbnail", DownloadAttachment)
+	r.DELETE("/attachments/:id", DeleteAttachment)
+	return r
+}
+
+// upload posts data as the "file" field, declared as declaredType
+func upload(t *testing.T, r *gin.Engine, entryID uint, data []byte, declaredType string, reflectionID uint) (int, Attachment) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if reflectionID != 0 {
+		form.WriteField("reflectionId", strconv.FormatUint(uint64(reflectionID), 10))
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="upload"`)
+	h.Set("Content-Type", declaredType)
+	part, _ := form.CreatePart(h)
+	part.Write(data)
+	form.Close()
+
+	w := doRequest(r, http.MethodPost, fmt.Sprintf("/entries/%d/attachments", entryID), form.FormDataContentType(), &body)
+	var attachment Attachment
+	if w.Code == http.StatusCreated {
+		decodeBody(t, w, &attachment)
+	}
+	return w.Code, attachment
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func openEntry(t *testing.T, username string) DiaryEntry {
+	t.Helper()
+	entry := DiaryEntry{Username: username, Title: "วันนี้", Content: "เหนื่อย", UnlockAt: time.Now().Add(-time.Minute)}
+	if err := DB.Create(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestUploadAttachmentSniffsType(t *testing.T) {
+	setupTestDB(t)
+	r := attachmentRouter("alice")
+	entry := openEntry(t, "alice")
+
+	// The declared type is ignored for images
+	code, attachment := upload(t, r, entry.ID, testPNG(t, 600, 300), "application/pdf", 0)
+	if code != http.StatusCreated || attachment.Kind != "image" || attachment.ContentType != "image/png" || !attachment.HasThumbnail {
+		t.Fatalf("png: status = %d, attachment = %+v", code, attachment)
+	}
+	w := doJSON(r, http.MethodGet, fmt.Sprintf("/attachments/%d/thumbnail", attachment.ID), nil)
+	thumb, _, err := image.DecodeConfig(w.Body)
+	if w.Code != http.StatusOK || err != nil || thumb.Width != thumbnailSize || thumb.Height != thumbnailSize/2 {
+		t.Errorf("thumbnail: status = %d, %+v, %v", w.Code, thumb, err)
+	}
+
+	if code, _ := upload(t, r, entry.ID, []byte("#!/bin/sh\necho hi\n"), "image/png", 0); code != http.StatusUnsupportedMediaType {
+		t.Errorf("script declared as png: status = %d", code)
+	}
+
+	// Voice memos sniff as video/webm and keep their declared audio type
+	webm := append([]byte{0x1a, 0x45, 0xdf, 0xa3}, make([]byte, 64)...)
+	if code, a := upload(t, r, entry.ID, webm, "audio/webm", 0); code != http.StatusCreated || a.Kind != "audio" || a.ContentType != "audio/webm" {
+		t.Errorf("audio webm: status = %d, attachment = %+v", code, a)
+	}
+	if code, _ := upload(t, r, entry.ID, webm, "video/webm", 0); code != http.StatusUnsupportedMediaType {
+		t.Errorf("video webm: status = %d", code)
+	}
+}
+
+func TestUploadAttachmentSizeLimits(t *testing.T) {
+	setupTestDB(t)
+	r := attachmentRouter("alice")
+	entry := openEntry(t, "alice")
+
+	big := append(testPNG(t, 1, 1), make([]byte, maxImageSize)...)
+	if code, _ := upload(t, r, entry.ID, big, "image/png", 0); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("image over %d MB: status = %d", maxImageSize>>20, code)
+	}
+
+	// A few bytes of GIF header claiming 10000 x 10000 pixels
+	huge := []byte("GIF89a\x10\x27\x10\x27\x00\x00\x00;")
+	if code, _ := upload(t, r, entry.ID, huge, "image/gif", 0); code != http.StatusBadRequest {
+		t.Errorf("100 megapixel image: status = %d", code)
+	}
+
+	var count int64
+	DB.Model(&Attachment{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d attachments stored", count)
+	}
+}
+
+func TestAttachWindowOfLockedEntry(t *testing.T) {
+	setupTestDB(t)
+	r := attachmentRouter("alice")
+	now := time.Now()
+
+	fresh := DiaryEntry{Username: "alice", Content: "เพิ่งเขียน", UnlockAt: now.Add(24 * time.Hour)}
+	old := DiaryEntry{Username: "alice", Content: "เขียนเมื่อวาน", CreatedAt: now.Add(-time.Hour), UnlockAt: now.Add(24 * time.Hour)}
+	DB.Create(&fresh)
+	DB.Create(&old)
+
+	code, attachment := upload(t, r, fresh.ID, testPNG(t, 2, 2), "image/png", 0)
+	if code != http.StatusCreated {
+		t.Fatalf("new entry: status = %d", code)
+	}
+	if code, _ := upload(t, r, old.ID, testPNG(t, 2, 2), "image/png", 0); code != http.StatusForbidden {
+		t.Errorf("entry locked since an hour: status = %d", code)
+	}
+
+	// Files can be taken back in the window but not read while locked
+	if w := doJSON(r, http.MethodGet, fmt.Sprintf("/attachments/%d", attachment.ID), nil); w.Code != http.StatusForbidden {
+		t.Errorf("download while locked: status = %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, fmt.Sprintf("/attachments/%d", attachment.ID), nil); w.Code != http.StatusOK {
+		t.Errorf("delete in window: status = %d", w.Code)
+	}
+}
+
+func TestAttachToNewReflectionAfterRelock(t *testing.T) {
+	setupTestDB(t)
+	r := attachmentRouter("alice")
+	entry := DiaryEntry{Username: "alice", Content: "เหนื่อย", CreatedAt: time.Now().Add(-2 * time.Hour), UnlockAt: time.Now().Add(-time.Minute)}
+	DB.Create(&entry)
+	earlier := ReflectionHistory{DiaryEntryID: entry.ID, Content: "ยังไม่ดีขึ้น", Status: "still_dealing", CreatedAt: time.Now().Add(-time.Hour)}
+	DB.Create(&earlier)
+
+	w := doJSON(r, http.MethodPost, fmt.Sprintf("/entries/%d/respond", entry.ID), gin.H{"status": "still_dealing", "reflection": "ยังเหนื่อยอยู่"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("respond: status = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Entry DiaryEntry `json:"entry"`
+	}
+	decodeBody(t, w, &resp)
+	if !resp.Entry.UnlockAt.After(time.Now()) || len(resp.Entry.Reflections) != 2 {
+		t.Fatalf("entry after respond = %+v", resp.Entry)
+	}
+	reflection := resp.Entry.Reflections[1]
+
+	code, attachment := upload(t, r, entry.ID, testPNG(t, 2, 2), "image/png", reflection.ID)
+	if code != http.StatusCreated || attachment.ReflectionID != reflection.ID {
+		t.Fatalf("new reflection: status = %d, attachment = %+v", code, attachment)
+	}
+	if code, _ := upload(t, r, entry.ID, testPNG(t, 2, 2), "image/png", earlier.ID); code != http.StatusForbidden {
+		t.Errorf("earlier reflection: status = %d", code)
+	}
+	if code, _ := upload(t, r, entry.ID, testPNG(t, 2, 2), "image/png", 0); code != http.StatusForbidden {
+		t.Errorf("entry itself: status = %d", code)
+	}
+	if w := doJSON(r, http.MethodDelete, fmt.Sprintf("/attachments/%d", attachment.ID), nil); w.Code != http.StatusOK {
+		t.Errorf("delete in window: status = %d", w.Code)
+	}
+}
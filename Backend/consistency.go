@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const goalHistoryWeeks = 8
+
+// WritingGoal is a user's weekly writing target and reminder settings
+type WritingGoal struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	Username       string    `json:"username" gorm:"uniqueIndex"`
+	EntriesPerWeek int       `json:"entriesPerWeek"`
+	Timezone       string    `json:"timezone"`
+	Reminders      bool      `json:"reminders"`
+	ReminderHour   int       `json:"reminderHour"` // Local hour the daily reminder check runs
+	LastReminder   string    `json:"-"`            // YYYY-MM-DD (local) of the last reminder sent
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// writingGoal returns the saved goal or the defaults
+func writingGoal(username string) WritingGoal {
+	goal := WritingGoal{Username: username, EntriesPerWeek: 3, Timezone: appLocation().String(), ReminderHour: 20}
+	DB.Where("username = ?", username).First(&goal)
+	return goal
+}
+
+func (g WritingGoal) location() *time.Location {
+	if loc, err := time.LoadLocation(g.Timezone); err == nil && g.Timezone != "" {
+		return loc
+	}
+	return appLocation()
+}
+
+// Consistency is how regularly a user writes and reflects
+type Consistency struct {
+	Timezone      string       `json:"timezone"`
+	CurrentStreak int          `json:"currentStreak"` // Days in a row, alive until the end of today
+	LongestStreak int          `json:"longestStreak"`
+	WrittenToday  bool         `json:"writtenToday"`
+	WeeklyGoal    weeklyGoal   `json:"weeklyGoal"`
+	Reflection    reflectStats `json:"reflectionCompletion"`
+}
+
+type weeklyGoal struct {
+	Target    int         `json:"target"`
+	WeekStart string      `json:"weekStart"`
+	ThisWeek  int         `json:"thisWeek"`
+	Met       bool        `json:"met"`
+	History   []weekCount `json:"history"` // Previous weeks, oldest first
+	WeeksMet  int         `json:"weeksMet"`
+}
+
+type weekCount struct {
+	WeekStart string `json:"weekStart"`
+	Entries   int    `json:"entries"`
+	Met       bool   `json:"met"`
+}
+
+type reflectStats struct {
+	Unlocked  int     `json:"unlocked"` // Entries open for a reflection or already reflected on
+	Reflected int     `json:"reflected"`
+	Pending   int     `json:"pending"`
+	Rate      float64 `json:"rate"` // Percent of those that got a reflection
+}
+
+// computeConsistency works out streaks, the weekly goal and reflection completion in loc
+func computeConsistency(username string, goal WritingGoal, loc *time.Location, now time.Time) Consistency {
+	var entries []DiaryEntry
+	DB.Select("id", "created_at", "unlock_at", "status", "is_draft").
+		Where("username = ? AND is_draft = ?", username, false).Order("created_at asc").Find(&entries)
+
+	today := bucketStart(now, "day", loc)
+	result := Consistency{Timezone: loc.String()}
+
+	// Streaks: consecutive local days with at least one entry
+	days := make(map[time.Time]bool)
+	for _, e := range entries {
+		days[bucketStart(e.CreatedAt, "day", loc)] = true
+	}
+	var previous time.Time
+	run := 0
+	for _, e := range entries {
+		day := bucketStart(e.CreatedAt, "day", loc)
+		if day.Equal(previous) {
+			continue
+		}
+		if !previous.IsZero() && day.Equal(previous.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		previous = day
+		result.LongestStreak = max(result.LongestStreak, run)
+	}
+	result.WrittenToday = days[today]
+	// A streak stays alive until today is over, so start from yesterday if nothing is written yet
+	day := today
+	if !result.WrittenToday {
+		day = today.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		result.CurrentStreak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	// Weekly goal, weeks start on Monday
+	weekStart := bucketStart(now, "week", loc)
+	counts := make(map[time.Time]int)
+	for _, e := range entries {
+		counts[bucketStart(e.CreatedAt, "week", loc)]++
+	}
+	result.WeeklyGoal = weeklyGoal{
+		Target:    goal.EntriesPerWeek,
+		WeekStart: weekStart.Format("2006-01-02"),
+		ThisWeek:  counts[weekStart],
+		Met:       goal.EntriesPerWeek > 0 && counts[weekStart] >= goal.EntriesPerWeek,
+		History:   []weekCount{},
+	}
+	for i := goalHistoryWeeks; i >= 1; i-- {
+		week := weekStart.AddDate(0, 0, -7*i)
+		met := goal.EntriesPerWeek > 0 && counts[week] >= goal.EntriesPerWeek
+		result.WeeklyGoal.History = append(result.WeeklyGoal.History, weekCount{week.Format("2006-01-02"), counts[week], met})
+		if met {
+			result.WeeklyGoal.WeeksMet++
+		}
+	}
+
+	// Reflection completion. A reflection relocks the entry (still_dealing, need_help),
+	// so reflected entries count whatever their lock; only waiting ones must be unlocked.
+	for _, e := range entries {
+		switch {
+		case e.Status != "":
+			result.Reflection.Reflected++
+		case !now.Before(e.UnlockAt):
+			result.Reflection.Pending++
+		default:
+			continue
+		}
+		result.Reflection.Unlocked++
+	}
+	if result.Reflection.Unlocked > 0 {
+		result.Reflection.Rate = round1(float64(result.Reflection.Reflected) * 100 / float64(result.Reflection.Unlocked))
+	}
+
+	return result
+}
+
+// --- Reminders ---
+
+// reminderMessages are the gentle nudges that apply right now, if any
+func reminderMessages(stats Consistency, now time.Time, loc *time.Location) []string {
+	var messages []string
+	if !stats.WrittenToday && stats.CurrentStreak >= 2 {
+		messages = append(messages, fmt.Sprintf("คุณเขียนต่อเนื่องมา %d วันแล้ว วันนี้มาเขียนสั้นๆ สักนิดไหม 🔥", stats.CurrentStreak))
+	}
+	goal := stats.WeeklyGoal
+	// Weekday 0 is Sunday, the last day of the week
+	daysLeft := (7 - int(now.In(loc).Weekday())) % 7
+	if goal.Target > 0 && !goal.Met && goal.Target-goal.ThisWeek > daysLeft {
+		messages = append(messages, fmt.Sprintf("สัปดาห์นี้เขียนไปแล้ว %d จาก %d ครั้ง ไม่ต้องกดดันตัวเองนะ แค่อยากชวนกลับมาเขียน 💛", goal.ThisWeek, goal.Target))
+	}
+	if stats.Reflection.Pending > 0 {
+		messages = append(messages, fmt.Sprintf("มีบันทึก %d รายการที่ปลดล็อกแล้วและรอให้คุณกลับไปทบทวน 🌱", stats.Reflection.Pending))
+	}
+	return messages
+}
+
+// runWritingReminders sends at most one reminder per user per day, at their reminder hour
+func runWritingReminders(now time.Time) {
+	var goals []WritingGoal
+	DB.Where("reminders = ?", true).Find(&goals)
+
+	for _, g := range goals {
+		loc := g.location()
+		local := now.In(loc)
+		today := local.Format("2006-01-02")
+		if local.Hour() != g.ReminderHour || g.LastReminder == today {
+			continue
+		}
+
+		messages := reminderMessages(computeConsistency(g.Username, g, loc, now), now, loc)
+		DB.Model(&WritingGoal{}).Where("id = ?", g.ID).Update("last_reminder", today)
+		if len(messages) == 0 {
+			continue
+		}
+
+		err := DB.Create(&Notification{
+			Username:  g.Username,
+			Kind:      "reminder",
+			Title:     "✍️ ชวนกลับมาเขียน",
+			Body:      strings.Join(messages, "\n"),
+			Link:      "/stats/consistency",
+			CreatedAt: now,
+		}).Error
+		if err != nil {
+			log.Printf("Failed to save reminder for %s: %v", g.Username, err)
+		}
+	}
+}
+
+// --- Handlers ---
+
+// GetConsistency returns streaks, weekly goal progress and reflection completion.
+// ?tz= overrides the time zone saved with the goal.
+func GetConsistency(c *gin.Context) {
+	username := c.GetString("username")
+	goal := writingGoal(username)
+
+	loc := goal.location()
+	if c.Query("tz") != "" {
+		var err error
+		if loc, err = userLocation(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, computeConsistency(username, goal, loc, time.Now()))
+}
+
+// GetWritingGoal returns the user's goal (defaults if none is saved)
+func GetWritingGoal(c *gin.Context) {
+	c.JSON(http.StatusOK, writingGoal(c.GetString("username")))
+}
+
+// SaveWritingGoal creates or updates the user's goal and reminder settings
+func SaveWritingGoal(c *gin.Context) {
+	username := c.GetString("username")
+
+	var input WritingGoal
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.EntriesPerWeek < 0 || input.EntriesPerWeek > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entriesPerWeek must be between 0 and 50"})
+		return
+	}
+	if input.ReminderHour < 0 || input.ReminderHour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder hour"})
+		return
+	}
+	if input.Timezone == "" {
+		input.Timezone = appLocation().String()
+	}
+	if _, err := time.LoadLocation(input.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+		return
+	}
+
+	var goal WritingGoal
+	DB.Where("username = ?", username).First(&goal)
+	input.ID = goal.ID
+	input.Username = username
+	input.LastReminder = goal.LastReminder
+	if err := DB.Save(&input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, input)
+}
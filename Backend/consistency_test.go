@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeConsistency(t *testing.T) {
+	// Wednesday; the week started on Monday 2026-03-09
+	now := time.Date(2026, 3, 11, 15, 0, 0, 0, time.UTC)
+	day := func(offset int) time.Time { return now.AddDate(0, 0, offset).Add(-time.Hour) }
+
+	tests := []struct {
+		name     string
+		days     []int // Day offsets of the entries
+		target   int
+		current  int
+		longest  int
+		today    bool
+		week     int
+		met      bool
+		metWeeks int
+	}{
+		{"no entries", nil, 3, 0, 0, false, 0, false, 0},
+		{"streak including today", []int{0, -1, -2}, 3, 3, 3, true, 3, true, 0},
+		{"streak alive until today ends", []int{-1, -2}, 3, 2, 2, false, 2, false, 0},
+		{"gap breaks the streak", []int{-1, -3, -4, -5}, 3, 1, 3, false, 1, false, 1},
+		{"several entries a day are one streak day", []int{0, 0, 0, -2}, 3, 1, 1, true, 4, true, 0},
+		{"goal met in earlier weeks", []int{-7, -8, -14, -15, -16}, 2, 0, 3, false, 0, false, 2},
+		{"no target is never met", []int{0, -1}, 0, 2, 2, true, 2, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			for _, d := range tt.days {
+				DB.Create(&DiaryEntry{Username: "alice", CreatedAt: day(d), UnlockAt: day(d)})
+			}
+			// Drafts and other users don't count
+			DB.Create(&DiaryEntry{Username: "alice", IsDraft: true, CreatedAt: day(-3)})
+			DB.Create(&DiaryEntry{Username: "bob", CreatedAt: day(-3)})
+
+			got := computeConsistency("alice", WritingGoal{EntriesPerWeek: tt.target}, time.UTC, now)
+			if got.CurrentStreak != tt.current || got.LongestStreak != tt.longest || got.WrittenToday != tt.today {
+				t.Errorf("streak = %d, longest = %d, today = %v; want %d, %d, %v",
+					got.CurrentStreak, got.LongestStreak, got.WrittenToday, tt.current, tt.longest, tt.today)
+			}
+			goal := got.WeeklyGoal
+			if goal.WeekStart != "2026-03-09" || goal.ThisWeek != tt.week || goal.Met != tt.met || goal.WeeksMet != tt.metWeeks {
+				t.Errorf("goal = %+v; want %d this week, met %v, %d weeks met", goal, tt.week, tt.met, tt.metWeeks)
+			}
+			if len(goal.History) != goalHistoryWeeks || goal.History[goalHistoryWeeks-1].WeekStart != "2026-03-02" {
+				t.Errorf("history = %+v", goal.History)
+			}
+		})
+	}
+}
+
+func TestReflectionCompletion(t *testing.T) {
+	now := time.Date(2026, 3, 11, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name                         string
+		status                       string
+		unlockAt                     time.Time
+		unlocked, reflected, pending int
+	}{
+		{"waiting for a reflection", "", now.Add(-time.Hour), 1, 0, 1},
+		{"still locked", "", now.Add(time.Hour), 0, 0, 0},
+		{"reflected", "over_it", now.Add(-time.Hour), 1, 1, 0},
+		// Respond locks still_dealing for 12 hours and need_help for 6
+		{"reflected and locked again", "still_dealing", now.Add(12 * time.Hour), 1, 1, 0},
+		{"asked for help and locked again", "need_help", now.Add(6 * time.Hour), 1, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			DB.Create(&DiaryEntry{Username: "alice", Status: tt.status, CreatedAt: now.AddDate(0, 0, -2), UnlockAt: tt.unlockAt})
+
+			got := computeConsistency("alice", WritingGoal{}, time.UTC, now).Reflection
+			if got.Unlocked != tt.unlocked || got.Reflected != tt.reflected || got.Pending != tt.pending {
+				t.Errorf("reflection = %+v; want %d unlocked, %d reflected, %d pending", got, tt.unlocked, tt.reflected, tt.pending)
+			}
+		})
+	}
+
+	// The rate is over every entry counted
+	setupTestDB(t)
+	for _, e := range []DiaryEntry{
+		{Status: "over_it", UnlockAt: now.Add(-time.Hour)},
+		{Status: "need_help", UnlockAt: now.Add(time.Hour)},
+		{UnlockAt: now.Add(-time.Hour)},
+		{UnlockAt: now.Add(time.Hour)},
+	} {
+		e.Username, e.CreatedAt = "alice", now.AddDate(0, 0, -1)
+		DB.Create(&e)
+	}
+	got := computeConsistency("alice", WritingGoal{}, time.UTC, now).Reflection
+	if got.Unlocked != 3 || got.Rate != 66.7 {
+		t.Errorf("reflection = %+v; want 3 counted at 66.7%%", got)
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// auth.db and diary.db only share the username, so the diary side registers
+// hooks to take part in exporting and deleting an account.
+var (
+	accountExporters = map[string]func(username string) (interface{}, error){}
+	accountDeleters  []func(username string) error
+)
+
+// Accounts without a password (single sign-on, passkeys) confirm deletion or a
+// new password by having logged in recently instead
+const reauthWindow = 10 * time.Minute
+
+// UserExists reports whether username still has an account. Background work on the
+// diary side checks it so it does not write data back for a deleted account.
+func UserExists(username string) bool {
+	var count int64
+	db.Model(&User{}).Where("username = ?", username).Count(&count)
+	return count > 0
+}
+
+// RegisterAccountExporter adds a section to the account export
+func RegisterAccountExporter(name string, export func(username string) (interface{}, error)) {
+	accountExporters[name] = export
+}
+
+// RegisterAccountDeleter adds a step that removes a user's data before the
+// account itself is deleted. Steps must be safe to run again after a failure.
+func RegisterAccountDeleter(remove func(username string) error) {
+	accountDeleters = append(accountDeleters, remove)
+}
+
+// exportAccount collects everything stored about the user
+func exportAccount(user User) (gin.H, error) {
+	passkeys := []Passkey{}
+	db.Where("username = ?", user.Username).Find(&passkeys)
+	identities := []Identity{}
+	db.Where("username = ?", user.Username).Find(&identities)
+
+	data := gin.H{
+		"exportedAt": time.Now(),
+		"account": gin.H{
+			"username":        user.Username,
+			"displayName":     user.DisplayName,
+			"avatar":          user.Avatar,
+			"email":           user.Email,
+			"emailVerifiedAt": user.EmailVerifiedAt,
+			"totpEnabled":     user.TOTPEnabled,
+			"createdAt":       user.CreatedAt,
+			"passkeys":        passkeys,
+			"identities":      identities,
+		},
+	}
+	for name, export := range accountExporters {
+		section, err := export(user.Username)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		data[name] = section
+	}
+	return data, nil
+}
+
+// deleteAuthData removes every auth.db row that belongs to the user in one transaction
+func deleteAuthData(username string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Model(&Session{}).Select("id").Where("username = ?", username)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Session{}, &EmailToken{}, &RecoveryCode{}, &LoginChallenge{},
+			&Passkey{}, &WebAuthnChallenge{}, &Identity{}} {
+			if err := tx.Where("username = ?", username).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("link_username = ?", username).Delete(&OIDCState{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("username = ?", username).Delete(&User{}).Error
+	})
+}
+
+// ExportAccount downloads all of the user's data as JSON
+func ExportAccount(c *gin.Context) {
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	data, err := exportAccount(user)
+	if err != nil {
+		log.Printf("Account export for %s failed: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="yesterdays-me-%s-%s.json"`, user.Username, time.Now().Format("2006-01-02")))
+	c.JSON(http.StatusOK, data)
+}
+
+// reauthenticate confirms a sensitive change the way a login would: the password,
+// or a session started within reauthWindow for an account without one, plus the
+// second factor when 2FA is on. It answers the request itself when it fails.
+func reauthenticate(c *gin.Context, user *User, password, code, recoveryCode string) bool {
+	if user.Password != "" {
+		if wait, locked := loginLocked(user); locked {
+			lockedResponse(c, wait)
+			return false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			recordFailedLogin(user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return false
+		}
+	} else {
+		var session Session
+		if err := db.Where("sid = ?", c.GetString("sid")).First(&session).Error; err != nil || time.Since(session.CreatedAt) > reauthWindow {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in again to confirm", "reauthRequired": true})
+			return false
+		}
+	}
+	if user.TOTPEnabled && !checkSecondFactor(user, code, recoveryCode) {
+		recordFailedLogin(user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return false
+	}
+	return true
+}
+
+// DeleteAccount permanently deletes the account and everything tied to it in both
+// databases. It needs the password (and a 2FA code when enabled), the username typed
+// as confirmation, and with "export": true returns the data export in the response.
+//
+// Other sessions are revoked first so nothing new is written meanwhile, then the diary
+// data goes, then the account. If a step fails the account is kept and the request
+// can simply be repeated from the current session.
+func DeleteAccount(c *gin.Context) {
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+		Confirm      string `json:"confirm" binding:"required"` // Must equal the username
+		Export       bool   `json:"export"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if input.Confirm != user.Username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Type your username to confirm"})
+		return
+	}
+
+	if !reauthenticate(c, &user, input.Password, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	var export gin.H
+	if input.Export {
+		var err error
+		if export, err = exportAccount(user); err != nil {
+			log.Printf("Account export for %s failed: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account, nothing was deleted"})
+			return
+		}
+	}
+
+	revokeSessions("account_deleted", "username = ? AND sid <> ?", user.Username, c.GetString("sid"))
+	for _, remove := range accountDeleters {
+		if err := remove(user.Username); err != nil {
+			log.Printf("Deleting data of %s failed: %v", user.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account data, please try again"})
+			return
+		}
+	}
+	if err := deleteAuthData(user.Username); err != nil {
+		log.Printf("Deleting account %s failed after its data was removed: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account, please try again"})
+		return
+	}
+	log.Printf("Account %s deleted", user.Username)
+
+	response := gin.H{"message": "Account deleted"}
+	if export != nil {
+		response["export"] = export
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -1,14 +1,12 @@
 package auth
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -16,34 +14,42 @@ import (
 
 // User model for isolated auth
 type User struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	Username    string    `json:"username" gorm:"unique"`
-	Password    string    `json:"password"`
-	DisplayName string    `json:"displayName"`
-	Avatar      string    `json:"avatar"` // URL or Base64
-	CreatedAt   time.Time `json:"createdAt"`
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	Username        string     `json:"username" gorm:"unique"`
+	Password        string     `json:"password"`
+	DisplayName     string     `json:"displayName"`
+	Avatar          string     `json:"avatar"`             // URL or Base64
+	Email           string     `json:"email" gorm:"index"` // Optional, used for password resets once verified
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	TOTPEnabled     bool       `json:"totpEnabled"`
+	TOTPSecret      string     `json:"-"` // Base32, set once enrollment is confirmed
+	TOTPPending     string     `json:"-"` // Secret waiting for its first valid code
+	TOTPLastStep    int64      `json:"-"` // Last accepted time step, so a code works only once
+	FailedLogins    int        `json:"-"` // Failed attempts in a row, see lockout.go
+	LockedUntil     *time.Time `json:"-"`
+	CreatedAt       time.Time  `json:"createdAt"`
 }
 
 var db *gorm.DB
-var jwtSecret = []byte("super-secret-key-change-this") // In prod, use env var
 
-// InitAuthDB initializes a separate database connection for Auth
+// InitAuthDB initializes a separate database connection for Auth, in the file
+// named by AUTH_DB_PATH (default auth.db)
 func InitAuthDB() {
 	var err error
-	db, err = gorm.Open(sqlite.Open("auth.db"), &gorm.Config{})
+	db, err = gorm.Open(sqlite.Open(envOr("AUTH_DB_PATH", "auth.db")), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to auth database:", err)
 	}
-	db.AutoMigrate(&User{})
+	migrateAuthDB()
+	initSessions()
+	initMail()
+	initWebAuthn()
+	initOIDC()
+	initPasswordPolicy()
 }
 
-// GenerateToken creates a JWT token for a user
-func GenerateToken(username string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
-	})
-	return token.SignedString(jwtSecret)
+func migrateAuthDB() {
+	db.AutoMigrate(&User{}, &Session{}, &RefreshToken{}, &EmailToken{}, &RecoveryCode{}, &LoginChallenge{}, &Passkey{}, &WebAuthnChallenge{}, &Identity{}, &OIDCState{})
 }
 
 // AuthMiddleware validates JWT tokens and sets currentUser context
@@ -57,28 +63,28 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := parseClaims(tokenString)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
+		username, _ := claims["username"].(string)
+		sid, _ := claims["sid"].(string)
+		if username == "" || sid == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			c.Abort()
 			return
 		}
+		if !sessionActive(c, sid, username) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+			c.Abort()
+			return
+		}
 
-		username := claims["username"].(string)
 		c.Set("username", username)
+		c.Set("sid", sid)
 		c.Next()
 	}
 }
@@ -88,6 +94,7 @@ func Register(c *gin.Context) {
 	var input struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Email    string `json:"email"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -95,6 +102,29 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	username, err := normalizeUsername(input.Username)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if usernameTaken(username) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+		return
+	}
+	if err := checkPassword(input.Password, username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := ""
+	if strings.TrimSpace(input.Email) != "" {
+		var err error
+		if email, err = normalizeEmail(input.Email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
@@ -102,8 +132,9 @@ func Register(c *gin.Context) {
 	}
 
 	user := User{
-		Username: input.Username,
+		Username: username,
 		Password: string(hashedPassword),
+		Email:    email,
 	}
 
 	if err := db.Create(&user).Error; err != nil {
@@ -111,10 +142,17 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if user.Email != "" {
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Username, err)
+		}
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
 
-// Login handler
+// Login handler. The per-IP limit (loginLimit in main) runs before it; the
+// per-account lockout is checked here, for unknown names as well.
 func Login(c *gin.Context) {
 	var input struct {
 		Username string `json:"username" binding:"required"`
@@ -127,29 +165,53 @@ func Login(c *gin.Context) {
 	}
 
 	var user User
-	if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
+	if err := findUserByLogin(input.Username, &user); err != nil {
+		if wait, locked := phantomLocked(input.Username); locked {
+			lockedResponse(c, wait)
+			return
+		}
+		recordPhantomFailure(input.Username, input.Password)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if wait, locked := loginLocked(&user); locked {
+		lockedResponse(c, wait)
+		return
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		recordFailedLogin(&user)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	token, err := GenerateToken(user.Username)
+	if user.TOTPEnabled {
+		challenge, err := startChallenge(user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	loginResponse(c, user)
+}
+
+// loginResponse starts a session and answers with its tokens and the profile basics
+func loginResponse(c *gin.Context, user User) {
+	resetFailedLogins(&user)
+	response, err := startSession(c, user.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Login successful",
-		"token":       token,
-		"username":    user.Username,
-		"displayName": user.DisplayName,
-		"avatar":      user.Avatar,
-	})
+	response["message"] = "Login successful"
+	response["username"] = user.Username
+	response["displayName"] = user.DisplayName
+	response["avatar"] = user.Avatar
+	c.JSON(http.StatusOK, response)
 }
 
 // GetProfile returns the current user's profile
@@ -162,9 +224,11 @@ func GetProfile(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"username":    user.Username,
-		"displayName": user.DisplayName,
-		"avatar":      user.Avatar,
+		"username":      user.Username,
+		"displayName":   user.DisplayName,
+		"avatar":        user.Avatar,
+		"email":         user.Email,
+		"emailVerified": user.EmailVerifiedAt != nil,
 	})
 }
 
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) decoder, enough for WebAuthn attestation objects
+// and COSE keys. Values decode to uint64/int64, []byte, string, bool, nil,
+// float64, []interface{} and map[interface{}]interface{}.
+
+var errCBOR = errors.New("malformed CBOR")
+
+const cborMaxDepth = 16
+
+// decodeCBOR decodes one item and returns it with the bytes that follow it
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func cborHead(data []byte) (major byte, arg uint64, rest []byte, err error) {
+	if len(data) < 1 {
+		return 0, 0, nil, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	switch {
+	case info < 24:
+		return major, uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return major, uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return major, uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return major, uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return major, binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	// Indefinite lengths (31) are not used by authenticators
+	return 0, 0, nil, errCBOR
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errCBOR
+	}
+	start := data
+	major, arg, data, err := cborHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		return arg, data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		if major == 2 {
+			return data[:arg], data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) { // Every item takes at least one byte
+			return nil, nil, errCBOR
+		}
+		list := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[cborKey(key)] = value
+		}
+		return m, data, nil
+	case 6:
+		// Tags are skipped, the tagged item is returned as is
+		return decodeCBORItem(data, depth+1)
+	case 7:
+		switch start[0] & 0x1f {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), data, nil
+		case 27:
+			return math.Float64frombits(arg), data, nil
+		}
+	}
+	return nil, nil, errCBOR
+}
+
+// cborKey folds small non-negative integers into int64 so map lookups like m[int64(3)] work
+func cborKey(key interface{}) interface{} {
+	if u, ok := key.(uint64); ok && u <= math.MaxInt64 {
+		return int64(u)
+	}
+	return key
+}
+
+func cborInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"dt-backend/mailer"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// EmailToken is a single-use link token sent by email, stored hashed
+type EmailToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"index"`
+	Purpose   string // verify_email or reset_password
+	Email     string // Address the token was sent to
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+var mailSender mailer.Sender
+
+// SetMailSender replaces the sender used for verification and reset emails
+func SetMailSender(s mailer.Sender) {
+	mailSender = s
+}
+
+func initMail() {
+	if mailSender == nil {
+		mailSender = mailer.FromEnv()
+	}
+	if os.Getenv("APP_URL") == "" {
+		log.Printf("APP_URL is not set, email links point to http://localhost:5173")
+	}
+}
+
+func appURL(path string, token string) string {
+	base := strings.TrimSuffix(envOr("APP_URL", "http://localhost:5173"), "/")
+	return base + path + "?token=" + url.QueryEscape(token)
+}
+
+// normalizeEmail validates a bare address and lowercases it
+func normalizeEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw {
+		return "", errors.New("Invalid email address")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// createEmailToken stores a new token, replacing unused ones with the same purpose
+func createEmailToken(username, purpose, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	db.Model(&EmailToken{}).Where("username = ? AND purpose = ? AND used_at IS NULL", username, purpose).Update("used_at", now)
+
+	token := randomToken(32)
+	err := db.Create(&EmailToken{
+		Username:  username,
+		Purpose:   purpose,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}).Error
+	return token, err
+}
+
+// findEmailToken looks up an unused, unexpired token without consuming it
+func findEmailToken(token, purpose string) (EmailToken, bool) {
+	var record EmailToken
+	if err := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&record).Error; err != nil {
+		return record, false
+	}
+	return record, record.UsedAt == nil && time.Now().Before(record.ExpiresAt)
+}
+
+// claimEmailToken marks a token used; only one caller can win
+func claimEmailToken(record EmailToken) bool {
+	result := db.Model(&EmailToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// useEmailToken consumes a token; it works exactly once and only before it expires
+func useEmailToken(token, purpose string) (EmailToken, bool) {
+	record, ok := findEmailToken(token, purpose)
+	return record, ok && claimEmailToken(record)
+}
+
+func sendVerificationEmail(user User) error {
+	token, err := createEmailToken(user.Username, "verify_email", user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("สวัสดี %s\n\nกรุณายืนยันอีเมลของคุณสำหรับ Yesterday's Me โดยเปิดลิงก์นี้ภายใน 48 ชั่วโมง:\n%s\n\nหากคุณไม่ได้ขอ สามารถเพิกเฉยต่ออีเมลนี้ได้",
+		user.Username, appURL("/verify-email", token))
+	return mailSender.Send(user.Email, "ยืนยันอีเมลของคุณ", body)
+}
+
+// VerifiedEmail returns the user's email address if it has been verified
+func VerifiedEmail(username string) (string, bool) {
+	var user User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return "", false
+	}
+	if user.Email == "" || user.EmailVerifiedAt == nil {
+		return "", false
+	}
+	return user.Email, true
+}
+
+// emailTaken reports whether another account already verified this address
+func emailTaken(email, username string) bool {
+	var count int64
+	db.Model(&User{}).Where("email = ? AND username <> ? AND email_verified_at IS NOT NULL", email, username).Count(&count)
+	return count > 0
+}
+
+// UpdateEmail sets (or with an empty value, removes) the user's email and sends a verification link
+func UpdateEmail(c *gin.Context) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	email := ""
+	if strings.TrimSpace(input.Email) != "" {
+		var err error
+		if email, err = normalizeEmail(input.Email); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if emailTaken(email, user.Username) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+			return
+		}
+	}
+
+	if email != user.Email {
+		user.Email = email
+		user.EmailVerifiedAt = nil
+		if err := db.Save(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update email"})
+			return
+		}
+	}
+	if user.Email != "" && user.EmailVerifiedAt == nil {
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Username, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email": user.Email, "emailVerified": user.EmailVerifiedAt != nil})
+}
+
+// VerifyEmail confirms the address a verification link was sent to
+func VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, ok := useEmailToken(input.Token, "verify_email")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	var user User
+	// The user may have changed their email since the link was sent
+	if err := db.Where("username = ? AND email = ?", record.Username, record.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	if emailTaken(user.Email, user.Username) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already in use"})
+		return
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	db.Save(&user)
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "email": user.Email})
+}
+
+// ForgotPassword emails a reset link to the verified address of an account.
+// The response is the same whether or not the account exists.
+func ForgotPassword(c *gin.Context) {
+	var input struct {
+		Login string `json:"login" binding:"required"` // Username or email
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	login := strings.TrimSpace(input.Login)
+	var user User
+	err := db.Where("(LOWER(username) = ? OR email = ?) AND email <> '' AND email_verified_at IS NOT NULL", strings.ToLower(login), strings.ToLower(login)).
+		First(&user).Error
+	if err == nil {
+		token, err := createEmailToken(user.Username, "reset_password", user.Email, resetPasswordTTL)
+		if err == nil {
+			body := fmt.Sprintf("สวัสดี %s\n\nมีคำขอรีเซ็ตรหัสผ่านสำหรับบัญชีของคุณ ตั้งรหัสผ่านใหม่ได้ที่ลิงก์นี้ภายใน 1 ชั่วโมง (ใช้ได้ครั้งเดียว):\n%s\n\nหากคุณไม่ได้ขอ บัญชีของคุณยังปลอดภัย สามารถเพิกเฉยต่ออีเมลนี้ได้",
+				user.Username, appURL("/reset-password", token))
+			err = mailSender.Send(user.Email, "รีเซ็ตรหัสผ่าน Yesterday's Me", body)
+		}
+		if err != nil {
+			log.Printf("Failed to send password reset to %s: %v", user.Username, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account has a verified email, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password with a reset token and ends every session
+func ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, ok := findEmailToken(input.Token, "reset_password")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	// The link stays valid until a password passes the policy
+	if err := checkPassword(input.Password, record.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	if !claimEmailToken(record) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+	if err := db.Model(&User{}).Where("username = ?", record.Username).Updates(map[string]interface{}{"password": string(hashedPassword), "failed_logins": 0, "locked_until": nil}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	revokeSessions("password_reset", "username = ?", record.Username)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in again"})
+}
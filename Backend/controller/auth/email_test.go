@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type sentMail struct{ to, subject, body string }
+
+// fakeSender records emails instead of sending them
+type fakeSender struct{ sent []sentMail }
+
+func (s *fakeSender) Send(to, subject, body string) error {
+	s.sent = append(s.sent, sentMail{to, subject, body})
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastToken returns the token from the link in the last email
+func (s *fakeSender) lastToken(t *testing.T) string {
+	t.Helper()
+	if len(s.sent) == 0 {
+		t.Fatal("no email sent")
+	}
+	m := linkToken.FindStringSubmatch(s.sent[len(s.sent)-1].body)
+	if m == nil {
+		t.Fatalf("no link in %q", s.sent[len(s.sent)-1].body)
+	}
+	return m[1]
+}
+
+func setupMail(t *testing.T) *fakeSender {
+	t.Helper()
+	sender := &fakeSender{}
+	previous := mailSender
+	SetMailSender(sender)
+	t.Cleanup(func() { SetMailSender(previous) })
+	return sender
+}
+
+func emailRouter(username string) *gin.Engine {
+	r := testRouter(username)
+	r.PUT("/profile/email", UpdateEmail)
+	r.POST("/auth/verify-email", VerifyEmail)
+	r.POST("/auth/forgot-password", ForgotPassword)
+	r.POST("/auth/reset-password", ResetPassword)
+	return r
+}
+
+func TestVerifyEmailTokenLifecycle(t *testing.T) {
+	setupAuthTestDB(t)
+	sender := setupMail(t)
+	db.Create(&User{Username: "alice"})
+	r := emailRouter("alice")
+
+	if w := doJSON(r, http.MethodPut, "/profile/email", gin.H{"email": " Alice@Example.com "}); w.Code != http.StatusOK {
+		t.Fatalf("update email: %d %s", w.Code, w.Body)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].to != "alice@example.com" {
+		t.Fatalf("sent = %+v", sender.sent)
+	}
+	superseded := sender.lastToken(t)
+
+	// Asking again replaces the earlier link
+	doJSON(r, http.MethodPut, "/profile/email", gin.H{"email": "alice@example.com"})
+	token := sender.lastToken(t)
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": superseded}); w.Code != http.StatusBadRequest {
+		t.Errorf("superseded link: %d", w.Code)
+	}
+
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": token}); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if email, ok := VerifiedEmail("alice"); !ok || email != "alice@example.com" {
+		t.Errorf("VerifiedEmail = %q, %v", email, ok)
+	}
+	// Links work once
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": token}); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: %d", w.Code)
+	}
+}
+
+func TestVerifyEmailRejectsStaleLinks(t *testing.T) {
+	setupAuthTestDB(t)
+	sender := setupMail(t)
+	db.Create(&User{Username: "alice"})
+	r := emailRouter("alice")
+
+	doJSON(r, http.MethodPut, "/profile/email", gin.H{"email": "alice@example.com"})
+	expired := sender.lastToken(t)
+	db.Model(&EmailToken{}).Where("token_hash = ?", hashToken(expired)).Update("expires_at", time.Now().Add(-time.Minute))
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": expired}); w.Code != http.StatusBadRequest {
+		t.Errorf("expired link: %d", w.Code)
+	}
+
+	// A link for an address the user has since replaced verifies nothing
+	doJSON(r, http.MethodPut, "/profile/email", gin.H{"email": "old@example.com"})
+	old := sender.lastToken(t)
+	db.Model(&User{}).Where("username = ?", "alice").Update("email", "new@example.com")
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": old}); w.Code != http.StatusBadRequest {
+		t.Errorf("link for a replaced address: %d", w.Code)
+	}
+	if _, ok := VerifiedEmail("alice"); ok {
+		t.Error("email verified by a stale link")
+	}
+
+	// Reset tokens are not verification tokens
+	reset, _ := createEmailToken("alice", "reset_password", "new@example.com", resetPasswordTTL)
+	if w := doJSON(r, http.MethodPost, "/auth/verify-email", gin.H{"token": reset}); w.Code != http.StatusBadRequest {
+		t.Errorf("reset token used to verify: %d", w.Code)
+	}
+}
+
+func TestForgotPasswordNeedsVerifiedEmail(t *testing.T) {
+	setupAuthTestDB(t)
+	sender := setupMail(t)
+	now := time.Now()
+	db.Create(&User{Username: "alice", Email: "alice@example.com", EmailVerifiedAt: &now})
+	db.Create(&User{Username: "bob", Email: "bob@example.com"})
+	r := emailRouter("")
+
+	var bodies []string
+	for _, login := range []string{"nobody", "bob", "bob@example.com", "ALICE@example.com"} {
+		w := doJSON(r, http.MethodPost, "/auth/forgot-password", gin.H{"login": login})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d", login, w.Code)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	for _, b := range bodies[1:] {
+		if b != bodies[0] {
+			t.Errorf("responses differ: %q vs %q", b, bodies[0])
+		}
+	}
+	if len(sender.sent) != 1 || sender.sent[0].to != "alice@example.com" {
+		t.Errorf("sent = %+v", sender.sent)
+	}
+}
+
+func TestResetPasswordTokenLifecycle(t *testing.T) {
+	setupAuthTestDB(t)
+	sender := setupMail(t)
+	now := time.Now()
+	db.Create(&User{Username: "alice", Password: "old", Email: "alice@example.com", EmailVerifiedAt: &now})
+	session := login(t, "alice", "test")
+	r := emailRouter("")
+
+	doJSON(r, http.MethodPost, "/auth/forgot-password", gin.H{"login": "alice"})
+	token := sender.lastToken(t)
+
+	// A password the policy rejects leaves the link usable
+	if w := doJSON(r, http.MethodPost, "/auth/reset-password", gin.H{"token": token, "password": "short"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("weak password: %d", w.Code)
+	}
+	if _, ok := findEmailToken(token, "reset_password"); !ok {
+		t.Fatal("weak password consumed the link")
+	}
+
+	if w := doJSON(r, http.MethodPost, "/auth/reset-password", gin.H{"token": token, "password": testNewPassword}); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body)
+	}
+	var user User
+	db.Where("username = ?", "alice").First(&user)
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(testNewPassword)) != nil {
+		t.Error("password was not changed")
+	}
+	if w := doAuth(sessionRouter(), http.MethodGet, "/me", session.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("session after reset: %d", w.Code)
+	}
+
+	if w := doJSON(r, http.MethodPost, "/auth/reset-password", gin.H{"token": token, "password": "An0ther-Lantern-42"}); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: %d", w.Code)
+	}
+
+	doJSON(r, http.MethodPost, "/auth/forgot-password", gin.H{"login": "alice"})
+	expired := sender.lastToken(t)
+	db.Model(&EmailToken{}).Where("token_hash = ?", hashToken(expired)).Update("expires_at", time.Now().Add(-time.Minute))
+	if w := doJSON(r, http.MethodPost, "/auth/reset-password", gin.H{"token": expired, "password": "An0ther-Lantern-42"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expired link: %d", w.Code)
+	}
+}
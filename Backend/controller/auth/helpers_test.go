@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupAuthTestDB points db at a fresh in-memory database and signs tokens with a
+// fixed test secret. Failures counted for unknown login names are forgotten too.
+func setupAuthTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	var err error
+	db, err = gorm.Open(sqlite.Open(fmt.Sprintf("file:auth_%s?mode=memory&cache=shared", name)),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	migrateAuthDB()
+	initPasswordPolicy()
+	phantomMu.Lock()
+	phantoms = make(map[string]*phantomLogin)
+	phantomMu.Unlock()
+
+	set, err := keysFromEnv(strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysMu.Lock()
+	keys = set
+	keysMu.Unlock()
+	issuer, aud, tokenTT = "test", "test-app", 15*time.Minute
+}
+
+// testRouter returns an engine where every request is made as username, or
+// anonymously when username is empty
+func testRouter(username string) *gin.Engine {
+	r := gin.New()
+	if username != "" {
+		r.Use(func(c *gin.Context) {
+			c.Set("username", username)
+		})
+	}
+	return r
+}
+
+func doJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body == nil {
+		reader = bytes.NewReader(nil)
+	} else {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
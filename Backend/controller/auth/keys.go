@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultKeysFile = "jwt_keys.json"
+
+// signingKey is one JWT key. Keys without a private part can only verify,
+// which is how retired asymmetric keys are kept around until their tokens expire.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{} // nil = verify only
+	verify interface{}
+}
+
+type keySet struct {
+	active *signingKey
+	byKid  map[string]*signingKey
+}
+
+// keyFile is the format of JWT_KEYS_FILE. Paths are relative to the file.
+//
+//	{"active": "2026-10", "keys": [
+//	  {"kid": "2026-10", "alg": "EdDSA", "privateKeyFile": "ed25519.pem"},
+//	  {"kid": "2026-04", "alg": "HS256", "secret": "..."},
+//	  {"kid": "2025-10", "alg": "RS256", "publicKeyFile": "rsa.pub.pem"}
+//	]}
+type keyFile struct {
+	Active string       `json:"active"`
+	Keys   []keyFileKey `json:"keys"`
+}
+
+type keyFileKey struct {
+	Kid            string `json:"kid"`
+	Alg            string `json:"alg"` // HS256, EdDSA or RS256
+	Secret         string `json:"secret,omitempty"`
+	PrivateKeyFile string `json:"privateKeyFile,omitempty"`
+	PublicKeyFile  string `json:"publicKeyFile,omitempty"`
+}
+
+var (
+	keysMu  sync.RWMutex
+	keys    *keySet
+	issuer  string
+	aud     string
+	tokenTT time.Duration
+)
+
+// InitKeys loads the signing keys and token settings. Keys come from JWT_SECRET
+// (plus JWT_KEY_ID and JWT_PREVIOUS_SECRETS="kid=secret,...") or from the key file
+// in JWT_KEYS_FILE. Without either, a random key is generated into jwt_keys.json.
+// Sending SIGHUP reloads the keys so they can be rotated without a restart.
+func InitKeys() {
+	issuer = envOr("JWT_ISSUER", "yesterdays-me")
+	aud = envOr("JWT_AUDIENCE", "yesterdays-me-app")
+	tokenTT = 15 * time.Minute // Short-lived; clients renew with a refresh token
+	if v := os.Getenv("JWT_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid JWT_TTL %q", v)
+		}
+		tokenTT = d
+	}
+
+	if err := reloadKeys(); err != nil {
+		log.Fatal("Failed to load JWT keys: ", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadKeys(); err != nil {
+				log.Printf("JWT key reload failed, keeping current keys: %v", err)
+			}
+		}
+	}()
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func reloadKeys() error {
+	var set *keySet
+	var err error
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		set, err = keysFromEnv(secret)
+	} else {
+		path := os.Getenv("JWT_KEYS_FILE")
+		if path == "" {
+			path = defaultKeysFile
+			if err := generateKeyFile(path); err != nil {
+				return err
+			}
+		}
+		set, err = keysFromFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	keysMu.Lock()
+	keys = set
+	keysMu.Unlock()
+	log.Printf("JWT keys loaded: signing with %s (%s), %d key(s) accepted", set.active.kid, set.active.method.Alg(), len(set.byKid))
+	return nil
+}
+
+func currentKeys() *keySet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return keys
+}
+
+func keysFromEnv(secret string) (*keySet, error) {
+	if len(secret) < 32 {
+		log.Printf("Warning: JWT_SECRET is shorter than 32 characters")
+	}
+	active := &signingKey{kid: envOr("JWT_KEY_ID", "primary"), method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}
+	set := &keySet{active: active, byKid: map[string]*signingKey{active.kid: active}}
+
+	for _, pair := range strings.Split(os.Getenv("JWT_PREVIOUS_SECRETS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kid, old, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || kid == "" || old == "" {
+			return nil, fmt.Errorf("JWT_PREVIOUS_SECRETS entries must look like kid=secret")
+		}
+		if _, dup := set.byKid[kid]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+		// Previous secrets only verify; new tokens always use the active key
+		set.byKid[kid] = &signingKey{kid: kid, method: jwt.SigningMethodHS256, verify: []byte(old)}
+	}
+	return set, nil
+}
+
+func keysFromFile(path string) (*keySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	readPEM := func(name string) ([]byte, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return os.ReadFile(name)
+	}
+
+	set := &keySet{byKid: make(map[string]*signingKey)}
+	for _, k := range file.Keys {
+		if k.Kid == "" {
+			return nil, fmt.Errorf("%s: every key needs a kid", path)
+		}
+		if _, dup := set.byKid[k.Kid]; dup {
+			return nil, fmt.Errorf("%s: duplicate key id %q", path, k.Kid)
+		}
+		key, err := parseKey(k, readPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %v", path, k.Kid, err)
+		}
+		set.byKid[k.Kid] = key
+	}
+
+	set.active = set.byKid[file.Active]
+	if set.active == nil {
+		return nil, fmt.Errorf("%s: active key %q not found", path, file.Active)
+	}
+	if set.active.sign == nil {
+		return nil, fmt.Errorf("%s: active key %q has no private key", path, file.Active)
+	}
+	return set, nil
+}
+
+func parseKey(k keyFileKey, readPEM func(string) ([]byte, error)) (*signingKey, error) {
+	key := &signingKey{kid: k.Kid}
+
+	switch k.Alg {
+	case "HS256":
+		if len(k.Secret) < 32 {
+			return nil, errors.New("HS256 secrets must be at least 32 characters")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.sign, key.verify = []byte(k.Secret), []byte(k.Secret)
+		return key, nil
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", k.Alg)
+	}
+
+	switch {
+	case k.PrivateKeyFile != "":
+		pem, err := readPEM(k.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if k.Alg == "EdDSA" {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.sign, key.verify = private, private.(ed25519.PrivateKey).Public()
+		} else {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.sign, key.verify = private, &private.PublicKey
+		}
+	case k.PublicKeyFile != "":
+		pem, err := readPEM(k.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if k.Alg == "EdDSA" {
+			key.verify, err = jwt.ParseEdPublicKeyFromPEM(pem)
+		} else {
+			key.verify, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("privateKeyFile or publicKeyFile is required")
+	}
+	return key, nil
+}
+
+// generateKeyFile writes a random HS256 key to path unless the file already exists
+func generateKeyFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	kid := time.Now().UTC().Format("2006-01-02")
+	data, _ := json.MarshalIndent(keyFile{
+		Active: kid,
+		Keys:   []keyFileKey{{Kid: kid, Alg: "HS256", Secret: base64.RawURLEncoding.EncodeToString(secret)}},
+	}, "", "  ")
+	log.Printf("No JWT keys configured, generated a new key in %s", path)
+	return os.WriteFile(path, data, 0600)
+}
+
+// signClaims adds the standard claims and signs with the active key
+func signClaims(claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	set := currentKeys()
+	now := time.Now()
+	claims["iss"] = issuer
+	claims["aud"] = aud
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	token := jwt.NewWithClaims(set.active.method, claims)
+	token.Header["kid"] = set.active.kid
+	return token.SignedString(set.active.sign)
+}
+
+// parseClaims verifies a token against the key named by its kid and checks iss, aud, iat and exp
+func parseClaims(tokenString string) (jwt.MapClaims, error) {
+	set := currentKeys()
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := set.byKid[kid]
+		if key == nil {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		// The alg must match the key, otherwise a public key could be used as an HMAC secret
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verify, nil
+	},
+		jwt.WithValidMethods([]string{"HS256", "EdDSA", "RS256"}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(aud),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	if claims["iat"] == nil {
+		return nil, errors.New("token has no iat")
+	}
+	return claims, nil
+}
+
+// JWKS publishes the public halves of the asymmetric keys so other services can verify tokens
+func JWKS(c *gin.Context) {
+	set := currentKeys()
+	list := []gin.H{}
+	for kid, key := range set.byKid {
+		switch pub := key.verify.(type) {
+		case ed25519.PublicKey:
+			list = append(list, gin.H{
+				"kty": "OKP", "crv": "Ed25519", "alg": "EdDSA", "use": "sig", "kid": kid,
+				"x": base64.RawURLEncoding.EncodeToString(pub),
+			})
+		case *rsa.PublicKey:
+			list = append(list, gin.H{
+				"kty": "RSA", "alg": "RS256", "use": "sig", "kid": kid,
+				"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": list})
+}
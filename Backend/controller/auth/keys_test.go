@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func useKeys(t *testing.T, set *keySet) {
+	t.Helper()
+	keysMu.Lock()
+	keys = set
+	keysMu.Unlock()
+}
+
+func TestRotatedSecretStillVerifies(t *testing.T) {
+	setupAuthTestDB(t)
+	oldSecret, newSecret := strings.Repeat("o", 32), strings.Repeat("n", 32)
+
+	t.Setenv("JWT_KEY_ID", "2026-04")
+	set, err := keysFromEnv(oldSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, set)
+	oldToken, err := signClaims(jwt.MapClaims{"username": "alice"}, tokenTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: the new key signs, the old one only verifies
+	t.Setenv("JWT_KEY_ID", "2026-10")
+	t.Setenv("JWT_PREVIOUS_SECRETS", "2026-04="+oldSecret)
+	set, err = keysFromEnv(newSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, set)
+	if set.byKid["2026-04"].sign != nil {
+		t.Error("previous secret can still sign")
+	}
+
+	if claims, err := parseClaims(oldToken); err != nil || claims["username"] != "alice" {
+		t.Errorf("token from the previous key: %v, %v", claims, err)
+	}
+	newToken, _ := signClaims(jwt.MapClaims{"username": "alice"}, tokenTT)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	if parsed.Header["kid"] != "2026-10" {
+		t.Errorf("new token kid = %v", parsed.Header["kid"])
+	}
+	if _, err := parseClaims(newToken); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+
+	// Once the previous secret is dropped its tokens stop working
+	t.Setenv("JWT_PREVIOUS_SECRETS", "")
+	set, _ = keysFromEnv(newSecret)
+	useKeys(t, set)
+	if _, err := parseClaims(oldToken); err == nil {
+		t.Error("token from a removed key still verifies")
+	}
+}
+
+func TestKeysFromEnvRejectsBadPrevious(t *testing.T) {
+	for _, previous := range []string{"no-equals", "=secret", "primary=" + strings.Repeat("x", 32)} {
+		t.Setenv("JWT_KEY_ID", "primary")
+		t.Setenv("JWT_PREVIOUS_SECRETS", previous)
+		if _, err := keysFromEnv(strings.Repeat("k", 32)); err == nil {
+			t.Errorf("JWT_PREVIOUS_SECRETS=%q accepted", previous)
+		}
+	}
+}
+
+func TestParseClaimsChecksKidAndAlg(t *testing.T) {
+	setupAuthTestDB(t)
+	secret := []byte(strings.Repeat("k", 32))
+	claims := func() jwt.MapClaims {
+		token, _ := signClaims(jwt.MapClaims{"username": "alice"}, tokenTT)
+		parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		return parsed.Claims.(jwt.MapClaims)
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	unknown.Header["kid"] = "someone-else"
+	signed, _ := unknown.SignedString(secret)
+	if _, err := parseClaims(signed); err == nil {
+		t.Error("token with an unknown kid accepted")
+	}
+
+	// HS384 with the right secret and kid is still not the key's algorithm
+	wrongAlg := jwt.NewWithClaims(jwt.SigningMethodHS384, claims())
+	wrongAlg.Header["kid"] = "primary"
+	signed, _ = wrongAlg.SignedString(secret)
+	if _, err := parseClaims(signed); err == nil {
+		t.Error("token with another alg accepted")
+	}
+
+	noKid := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	signed, _ = noKid.SignedString(secret)
+	if _, err := parseClaims(signed); err == nil {
+		t.Error("token without a kid accepted")
+	}
+}
+
+// writeKeyFile writes an EdDSA signing key, a retired RSA public key and an
+// HS256 key and returns the key file path
+func writeKeyFile(t *testing.T) (string, ed25519.PublicKey, *rsa.PublicKey) {
+	t.Helper()
+	dir := t.TempDir()
+	writePEM := func(name, kind string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edPriv)
+	writePEM("ed25519.pem", "PRIVATE KEY", der)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ = x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	writePEM("rsa.pub.pem", "PUBLIC KEY", der)
+
+	path := filepath.Join(dir, "keys.json")
+	os.WriteFile(path, []byte(`{"active": "2026-10", "keys": [
+		{"kid": "2026-10", "alg": "EdDSA", "privateKeyFile": "ed25519.pem"},
+		{"kid": "2026-04", "alg": "HS256", "secret": "`+strings.Repeat("h", 32)+`"},
+		{"kid": "2025-10", "alg": "RS256", "publicKeyFile": "rsa.pub.pem"}
+	]}`), 0600)
+	return path, edPub, &rsaKey.PublicKey
+}
+
+func TestKeysFromFile(t *testing.T) {
+	setupAuthTestDB(t)
+	path, _, _ := writeKeyFile(t)
+	set, err := keysFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, set)
+
+	if set.active.kid != "2026-10" || set.active.method != jwt.SigningMethodEdDSA {
+		t.Errorf("active key = %s %s", set.active.kid, set.active.method.Alg())
+	}
+	if set.byKid["2025-10"].sign != nil {
+		t.Error("public-only key can sign")
+	}
+	token, err := signClaims(jwt.MapClaims{"username": "alice"}, tokenTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseClaims(token); err != nil {
+		t.Errorf("EdDSA token: %v", err)
+	}
+
+	// An EdDSA public key must not be usable as an HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "mallory"})
+	forged.Header["kid"] = "2026-10"
+	signed, _ := forged.SignedString([]byte(set.active.verify.(ed25519.PublicKey)))
+	if _, err := parseClaims(signed); err == nil {
+		t.Error("HS256 token signed with the public key accepted")
+	}
+
+	for name, content := range map[string]string{
+		"missing active": `{"active": "gone", "keys": [{"kid": "a", "alg": "HS256", "secret": "` + strings.Repeat("h", 32) + `"}]}`,
+		"public active":  `{"active": "old", "keys": [{"kid": "old", "alg": "RS256", "publicKeyFile": "rsa.pub.pem"}]}`,
+		"short secret":   `{"active": "a", "keys": [{"kid": "a", "alg": "HS256", "secret": "short"}]}`,
+		"duplicate kid":  `{"active": "a", "keys": [{"kid": "a", "alg": "HS256", "secret": "` + strings.Repeat("h", 32) + `"}, {"kid": "a", "alg": "HS256", "secret": "` + strings.Repeat("i", 32) + `"}]}`,
+		"unknown alg":    `{"active": "a", "keys": [{"kid": "a", "alg": "none"}]}`,
+	} {
+		bad := filepath.Join(filepath.Dir(path), "bad.json")
+		os.WriteFile(bad, []byte(content), 0600)
+		if _, err := keysFromFile(bad); err == nil {
+			t.Errorf("%s: key file accepted", name)
+		}
+	}
+}
+
+func TestGenerateKeyFileKeepsExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultKeysFile)
+	if err := generateKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(path)
+	if _, err := keysFromFile(path); err != nil {
+		t.Errorf("generated file: %v", err)
+	}
+	generateKeyFile(path)
+	if second, _ := os.ReadFile(path); string(second) != string(first) {
+		t.Error("existing key file was overwritten")
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	setupAuthTestDB(t)
+	path, edPub, rsaPub := writeKeyFile(t)
+	set, err := keysFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeys(t, set)
+
+	r := testRouter("")
+	r.GET("/.well-known/jwks.json", JWKS)
+	var resp struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	w := doJSON(r, http.MethodGet, "/.well-known/jwks.json", nil)
+	decodeBody(t, w, &resp)
+	if len(resp.Keys) != 2 {
+		t.Fatalf("keys = %+v", resp.Keys)
+	}
+	byKid := make(map[string]map[string]string)
+	for _, k := range resp.Keys {
+		byKid[k["kid"]] = k
+	}
+
+	ed := byKid["2026-10"]
+	if ed["kty"] != "OKP" || ed["crv"] != "Ed25519" || ed["alg"] != "EdDSA" || ed["x"] != base64.RawURLEncoding.EncodeToString(edPub) {
+		t.Errorf("EdDSA key = %+v", ed)
+	}
+	rs := byKid["2025-10"]
+	if rs["kty"] != "RSA" || rs["alg"] != "RS256" || rs["n"] != base64.RawURLEncoding.EncodeToString(rsaPub.N.Bytes()) || rs["e"] != "AQAB" {
+		t.Errorf("RSA key = %+v", rs)
+	}
+	// The HMAC secret is never published
+	if _, ok := byKid["2026-04"]; ok || strings.Contains(w.Body.String(), strings.Repeat("h", 32)) {
+		t.Errorf("JWKS leaks the HS256 key: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// After freeFailedLogins wrong passwords or codes in a row, an account is locked
+// for lockoutBase, doubling with every further failure up to lockoutMax.
+// A successful login resets the counter.
+const (
+	freeFailedLogins = 5
+	lockoutBase      = 30 * time.Second
+	lockoutMax       = time.Hour
+	phantomTTL       = 24 * time.Hour // How long failures for unknown names are remembered
+)
+
+// lockoutFor returns how long an account is locked after failed attempts in a row
+func lockoutFor(failed int) time.Duration {
+	over := failed - freeFailedLogins
+	if over < 0 {
+		return 0
+	}
+	if over >= 8 { // Avoids overflowing the shift; 30s << 7 is already past lockoutMax
+		return lockoutMax
+	}
+	return min(lockoutBase<<over, lockoutMax)
+}
+
+// loginLocked returns how long the account is still locked for
+func loginLocked(user *User) (time.Duration, bool) {
+	if user.LockedUntil == nil {
+		return 0, false
+	}
+	wait := time.Until(*user.LockedUntil)
+	return wait, wait > 0
+}
+
+// recordFailedLogin counts a failed attempt and locks the account when needed
+func recordFailedLogin(user *User) {
+	db.Model(&User{}).Where("id = ?", user.ID).Update("failed_logins", gorm.Expr("failed_logins + 1"))
+	db.Where("id = ?", user.ID).First(user)
+
+	lock := lockoutFor(user.FailedLogins)
+	if lock == 0 {
+		return
+	}
+	until := time.Now().Add(lock)
+	user.LockedUntil = &until
+	db.Model(&User{}).Where("id = ?", user.ID).Update("locked_until", until)
+	log.Printf("Login for %s locked for %s after %d failed attempts", user.Username, lock, user.FailedLogins)
+}
+
+// resetFailedLogins clears the counter after a successful login
+func resetFailedLogins(user *User) {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return
+	}
+	db.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+}
+
+func lockedResponse(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later", "retryAfter": seconds})
+}
+
+// Unknown names get the same lockout as real accounts, counted in memory, so the
+// response to a login never tells whether the account exists.
+type phantomLogin struct {
+	failed      int
+	lockedUntil time.Time
+	last        time.Time
+}
+
+var (
+	phantomMu    sync.Mutex
+	phantoms     = make(map[string]*phantomLogin)
+	phantomSweep time.Time
+
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+func phantomKey(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// phantomLocked is loginLocked for a name without an account
+func phantomLocked(login string) (time.Duration, bool) {
+	phantomMu.Lock()
+	defer phantomMu.Unlock()
+	p := phantoms[phantomKey(login)]
+	if p == nil {
+		return 0, false
+	}
+	wait := time.Until(p.lockedUntil)
+	return wait, wait > 0
+}
+
+// recordPhantomFailure is recordFailedLogin for a name without an account. It
+// spends the time of a password check too, so the answer doesn't come back faster.
+func recordPhantomFailure(login, password string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte(randomToken(16)), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+
+	phantomMu.Lock()
+	defer phantomMu.Unlock()
+	now := time.Now()
+	if now.Sub(phantomSweep) >= time.Minute {
+		phantomSweep = now
+		for key, p := range phantoms {
+			if now.Sub(p.last) >= phantomTTL {
+				delete(phantoms, key)
+			}
+		}
+	}
+
+	key := phantomKey(login)
+	p := phantoms[key]
+	if p == nil {
+		p = &phantomLogin{}
+		phantoms[key] = p
+	}
+	p.failed++
+	p.last = now
+	if lock := lockoutFor(p.failed); lock > 0 {
+		p.lockedUntil = now.Add(lock)
+	}
+}
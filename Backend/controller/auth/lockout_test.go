@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLockoutDoubles(t *testing.T) {
+	for failed, want := range map[int]time.Duration{
+		0:                     0,
+		freeFailedLogins - 1:  0,
+		freeFailedLogins:      30 * time.Second,
+		freeFailedLogins + 1:  time.Minute,
+		freeFailedLogins + 3:  4 * time.Minute,
+		freeFailedLogins + 6:  32 * time.Minute,
+		freeFailedLogins + 7:  lockoutMax,
+		freeFailedLogins + 70: lockoutMax,
+	} {
+		if got := lockoutFor(failed); got != want {
+			t.Errorf("lockoutFor(%d) = %s, want %s", failed, got, want)
+		}
+	}
+}
+
+func TestRecordFailedLoginLocksAccount(t *testing.T) {
+	setupAuthTestDB(t)
+	user := User{Username: "alice"}
+	db.Create(&user)
+
+	for i := 1; i < freeFailedLogins; i++ {
+		recordFailedLogin(&user)
+	}
+	if _, locked := loginLocked(&user); locked {
+		t.Fatalf("locked after %d failures", user.FailedLogins)
+	}
+	recordFailedLogin(&user)
+	wait, locked := loginLocked(&user)
+	if !locked || wait > lockoutBase || wait < lockoutBase-time.Second {
+		t.Errorf("after %d failures: locked %v for %s", user.FailedLogins, locked, wait)
+	}
+	recordFailedLogin(&user)
+	if wait, _ := loginLocked(&user); wait < 2*lockoutBase-time.Second {
+		t.Errorf("second lock is %s, want twice the first", wait)
+	}
+
+	// The counter is stored, not just kept on the struct
+	var stored User
+	db.First(&stored, user.ID)
+	if stored.FailedLogins != freeFailedLogins+1 || stored.LockedUntil == nil {
+		t.Errorf("stored = %d failures, locked until %v", stored.FailedLogins, stored.LockedUntil)
+	}
+
+	resetFailedLogins(&stored)
+	var reset User
+	db.First(&reset, user.ID)
+	if _, locked := loginLocked(&reset); locked || reset.FailedLogins != 0 {
+		t.Errorf("after reset: %d failures, locked %v", reset.FailedLogins, locked)
+	}
+}
+
+func TestLoginAnswersUnknownNamesLikeAccounts(t *testing.T) {
+	setupAuthTestDB(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testNewPassword), bcrypt.MinCost)
+	db.Create(&User{Username: "alice", Password: string(hash)})
+	r := testRouter("")
+	r.POST("/login", Login)
+
+	attempt := func(username string) (int, string, string) {
+		w := doJSON(r, http.MethodPost, "/login", gin.H{"username": username, "password": "wrong"})
+		var resp struct {
+			Error string `json:"error"`
+		}
+		decodeBody(t, w, &resp)
+		return w.Code, resp.Error, w.Header().Get("Retry-After")
+	}
+	for i := 0; i <= freeFailedLogins; i++ {
+		code, msg, retry := attempt("alice")
+		ghostCode, ghostMsg, ghostRetry := attempt("ghost")
+		if code != ghostCode || msg != ghostMsg || (retry == "") != (ghostRetry == "") {
+			t.Fatalf("attempt %d: alice %d %q %q, ghost %d %q %q", i+1, code, msg, retry, ghostCode, ghostMsg, ghostRetry)
+		}
+	}
+	// Both are locked now, whatever the case of the name
+	if code, _, retry := attempt("GHOST"); code != http.StatusTooManyRequests || retry == "" {
+		t.Errorf("unknown name after the limit: %d, Retry-After %q", code, retry)
+	}
+	if code, _, retry := attempt("alice"); code != http.StatusTooManyRequests || retry == "" {
+		t.Errorf("account after the limit: %d, Retry-After %q", code, retry)
+	}
+
+	// Even the right password waits for the lock
+	w := doJSON(r, http.MethodPost, "/login", gin.H{"username": "alice", "password": testNewPassword})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("right password while locked: %d", w.Code)
+	}
+	db.Model(&User{}).Where("username = ?", "alice").Update("locked_until", time.Now().Add(-time.Second))
+	w = doJSON(r, http.MethodPost, "/login", gin.H{"username": "alice", "password": testNewPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("right password after the lock: %d %s", w.Code, w.Body)
+	}
+	var user User
+	db.Where("username = ?", "alice").First(&user)
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("after login: %d failures, locked until %v", user.FailedLogins, user.LockedUntil)
+	}
+}
@@ -0,0 +1,556 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect login (authorization code flow with PKCE). Providers are listed in
+// OIDC_PROVIDERS="google,work" and each one is configured with OIDC_<ID>_ISSUER,
+// OIDC_<ID>_CLIENT_ID, optional OIDC_<ID>_CLIENT_SECRET, OIDC_<ID>_NAME and
+// OIDC_<ID>_SCOPES. The provider redirects back to OIDC_REDIRECT_URL
+// (default APP_URL + "/oidc/callback"), where the frontend hands the code to us.
+//
+// Starting a flow also sets an HttpOnly cookie, and the callback only accepts a
+// state from the browser holding that cookie. Otherwise someone could send their
+// own state to another person and log them into, or link their identity to, the
+// wrong account.
+
+const (
+	oidcCookie       = "oidc_flow"
+	oidcStateTTL     = 10 * time.Minute
+	oidcMetadataTTL  = time.Hour
+	oidcJWKSCooldown = time.Minute // Least time between JWKS refetches for an unknown kid
+)
+
+// Identity links an account to a subject at an OpenID provider
+type Identity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Username    string     `json:"-" gorm:"index"`
+	Provider    string     `json:"provider"`
+	Issuer      string     `json:"issuer" gorm:"uniqueIndex:idx_identity_subject"`
+	Subject     string     `json:"-" gorm:"uniqueIndex:idx_identity_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// OIDCState remembers one authorization request until the provider redirects back
+type OIDCState struct {
+	ID           uint   `gorm:"primaryKey"`
+	StateHash    string `gorm:"uniqueIndex"`
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUsername string // Set when a logged in user is linking an identity
+	BrowserHash  string // Hash of the oidcCookie value of the browser that started the flow
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+	CreatedAt    time.Time
+}
+
+type oidcProvider struct {
+	id           string
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       string
+
+	mu        sync.Mutex
+	metadata  *oidcMetadata
+	fetchedAt time.Time
+	jwks      map[string]interface{} // kid -> public key
+	jwksAt    time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcProviders  map[string]*oidcProvider
+	oidcProviderID []string // Configured order, for the login page
+	oidcRedirect   string
+	oidcHTTP       = &http.Client{Timeout: 10 * time.Second}
+)
+
+func initOIDC() {
+	oidcProviders = make(map[string]*oidcProvider)
+	oidcProviderID = nil
+	oidcRedirect = envOr("OIDC_REDIRECT_URL", strings.TrimSuffix(envOr("APP_URL", "http://localhost:5173"), "/")+"/oidc/callback")
+
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		p := &oidcProvider{
+			id:           id,
+			name:         envOr(prefix+"NAME", id),
+			issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			clientID:     os.Getenv(prefix + "CLIENT_ID"),
+			clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			scopes:       envOr(prefix+"SCOPES", "openid email profile"),
+		}
+		if p.issuer == "" || p.clientID == "" {
+			log.Fatalf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", id, prefix, prefix)
+		}
+		oidcProviders[id] = p
+		oidcProviderID = append(oidcProviderID, id)
+	}
+	if len(oidcProviderID) > 0 {
+		log.Printf("OIDC login enabled for: %s", strings.Join(oidcProviderID, ", "))
+	}
+}
+
+func oidcGetJSON(rawURL string, out interface{}) error {
+	resp, err := oidcHTTP.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// discover fetches (and caches) the provider's openid-configuration
+func (p *oidcProvider) discover() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.fetchedAt) < oidcMetadataTTL {
+		return p.metadata, nil
+	}
+
+	var m oidcMetadata
+	if err := oidcGetJSON(p.issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	p.metadata, p.fetchedAt = &m, time.Now()
+	return p.metadata, nil
+}
+
+// key returns the provider's public key for kid, refetching the JWKS when the
+// kid is new (providers rotate keys) but not more than once a minute
+func (p *oidcProvider) key(kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.jwks[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.jwksAt) < oidcJWKSCooldown {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := oidcGetJSON(p.metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.jwks, p.jwksAt = make(map[string]interface{}), time.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var pub interface{}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := decodeB64URL(k.N)
+			e, err2 := decodeB64URL(k.E)
+			if err1 == nil && err2 == nil && len(e) <= 4 {
+				pub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := decodeB64URL(k.X)
+			y, err2 := decodeB64URL(k.Y)
+			if err1 == nil && err2 == nil {
+				pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			}
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			if x, err := decodeB64URL(k.X); err == nil && len(x) == ed25519.PublicKeySize {
+				pub = ed25519.PublicKey(x)
+			}
+		}
+		if pub != nil {
+			p.jwks[k.Kid] = pub
+		}
+	}
+	if k, ok := p.jwks[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// authURL stores a new state bound to the browser and returns where to send it
+func (p *oidcProvider) authURL(linkUsername, browser string) (string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&OIDCState{})
+	state, nonce, verifier := randomToken(32), randomToken(32), randomToken(48)
+	err = db.Create(&OIDCState{
+		StateHash:    hashToken(state),
+		Provider:     p.id,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUsername: linkUsername,
+		BrowserHash:  hashToken(browser),
+		ExpiresAt:    now.Add(oidcStateTTL),
+		CreatedAt:    now,
+	}).Error
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", oidcRedirect)
+	q.Set("scope", p.scopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", b64url(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// idClaims are the ID token claims we use
+type idClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// exchange trades the code for tokens and returns the verified ID token claims
+func (p *oidcProvider) exchange(code string, state OIDCState) (*idClaims, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcRedirect)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", state.CodeVerifier)
+	req, _ := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := oidcHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token endpoint returned %s %s", resp.Status, tokens.Error)
+	}
+
+	claims := &idClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	if claims.Nonce != state.Nonce {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID {
+		return nil, errors.New("invalid ID token: azp does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	return claims, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9_.]+`)
+
+// suggestUsername picks a free, valid username from the ID token for a new account
+func suggestUsername(claims *idClaims) string {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.TrimLeft(usernameUnsafe.ReplaceAllString(strings.ToLower(base), ""), "_.")
+	if len(base) > usernameMaxLength-5 {
+		base = base[:usernameMaxLength-5]
+	}
+	if _, err := normalizeUsername(base); err != nil {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 20; i++ {
+		if _, err := normalizeUsername(candidate); err == nil && !usernameTaken(candidate) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s%d", base, 1000+time.Now().UnixNano()%9000)
+	}
+	return fmt.Sprintf("%s%d", base, time.Now().UnixNano()%100000)
+}
+
+// --- Handlers ---
+
+// GetOIDCProviders lists the configured providers for the login page
+func GetOIDCProviders(c *gin.Context) {
+	list := []gin.H{}
+	for _, id := range oidcProviderID {
+		list = append(list, gin.H{"id": id, "name": oidcProviders[id].name})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func startOIDC(c *gin.Context, linkUsername string) {
+	p := oidcProviders[c.Param("provider")]
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+	browser := randomToken(32)
+	target, err := p.authURL(linkUsername, browser)
+	if err != nil {
+		log.Printf("OIDC provider %s is unavailable: %v", p.id, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return
+	}
+	setOIDCCookie(c, browser, oidcStateTTL)
+	c.JSON(http.StatusOK, gin.H{"url": target})
+}
+
+// setOIDCCookie sets (or with an empty value, clears) the cookie binding a flow to the browser.
+// Lax is enough: the callback is posted by the app itself, from the same site.
+func setOIDCCookie(c *gin.Context, value string, ttl time.Duration) {
+	maxAge := int(ttl.Seconds())
+	if value == "" {
+		maxAge = -1
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, value, maxAge, "/", "", strings.HasPrefix(oidcRedirect, "https://"), true)
+}
+
+// sameBrowser reports whether the request comes from the browser that started the flow
+func sameBrowser(c *gin.Context, state OIDCState) bool {
+	browser, err := c.Cookie(oidcCookie)
+	if err != nil || browser == "" || state.BrowserHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(browser)), []byte(state.BrowserHash)) == 1
+}
+
+// StartOIDCLogin returns the provider URL to log in or sign up with
+func StartOIDCLogin(c *gin.Context) {
+	startOIDC(c, "")
+}
+
+// StartOIDCLink returns the provider URL to link an identity to the current account
+func StartOIDCLink(c *gin.Context) {
+	startOIDC(c, c.GetString("username"))
+}
+
+// OIDCCallback finishes the flow with the code the provider sent back. It logs in
+// the linked account, links the identity when the flow was started by StartOIDCLink,
+// or creates a new account. Accounts are never matched by email, so an identity only
+// reaches an existing password account once its owner linked it.
+func OIDCCallback(c *gin.Context) {
+	var input struct {
+		State string `json:"state" binding:"required"`
+		Code  string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var state OIDCState
+	err := db.Where("state_hash = ?", hashToken(input.State)).First(&state).Error
+	if err != nil || state.UsedAt != nil || time.Now().After(state.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login request expired, please try again"})
+		return
+	}
+	// Checked before the state is used, so a forged request cannot spend it
+	if !sameBrowser(c, state) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was started in another browser, please try again"})
+		return
+	}
+	setOIDCCookie(c, "", 0)
+	result := db.Model(&OIDCState{}).Where("id = ? AND used_at IS NULL", state.ID).Update("used_at", time.Now())
+	if result.RowsAffected != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login request expired, please try again"})
+		return
+	}
+	p := oidcProviders[state.Provider]
+	if p == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	claims, err := p.exchange(input.Code, state)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", p.id, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login with provider failed"})
+		return
+	}
+
+	now := time.Now()
+	var identity Identity
+	found := db.Where("issuer = ? AND subject = ?", p.issuer, claims.Subject).First(&identity).Error == nil
+
+	if state.LinkUsername != "" {
+		if found {
+			if identity.Username == state.LinkUsername {
+				c.JSON(http.StatusOK, gin.H{"message": "Identity already linked", "identity": identity})
+			} else {
+				c.JSON(http.StatusConflict, gin.H{"error": "This identity is linked to another account"})
+			}
+			return
+		}
+		identity = Identity{Username: state.LinkUsername, Provider: p.id, Issuer: p.issuer, Subject: claims.Subject, Email: claims.Email, CreatedAt: now}
+		if err := db.Create(&identity).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Identity linked", "identity": identity})
+		return
+	}
+
+	var user User
+	if found {
+		if err := db.Where("username = ?", identity.Username).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login with provider failed"})
+			return
+		}
+	} else {
+		// First login with this identity: sign up. The account has no password
+		// until the user sets one; it logs in through the provider.
+		user = User{Username: suggestUsername(claims), DisplayName: claims.Name}
+		if email, err := normalizeEmail(claims.Email); err == nil && claims.EmailVerified && !emailTaken(email, "") {
+			user.Email, user.EmailVerifiedAt = email, &now
+		}
+		identity = Identity{Username: user.Username, Provider: p.id, Issuer: p.issuer, Subject: claims.Subject, Email: claims.Email, CreatedAt: now}
+		if err := db.Create(&user).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+		if err := db.Create(&identity).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+			return
+		}
+	}
+	db.Model(&identity).Update("last_login_at", now)
+
+	if user.TOTPEnabled {
+		challenge, err := startChallenge(user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+	loginResponse(c, user)
+}
+
+// GetIdentities lists the identities linked to the current account
+func GetIdentities(c *gin.Context) {
+	identities := []Identity{}
+	db.Where("username = ?", c.GetString("username")).Order("created_at").Find(&identities)
+	c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkIdentity removes a linked identity, unless it is the only way left to log in
+func UnlinkIdentity(c *gin.Context) {
+	username := c.GetString("username")
+	var identity Identity
+	if err := db.Where("id = ? AND username = ?", c.Param("id"), username).First(&identity).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+
+	var user User
+	db.Where("username = ?", username).First(&user)
+	var identities, passkeys int64
+	db.Model(&Identity{}).Where("username = ?", username).Count(&identities)
+	db.Model(&Passkey{}).Where("username = ?", username).Count(&passkeys)
+	if user.Password == "" && identities <= 1 && passkeys == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Set a password or add a passkey before unlinking your last identity"})
+		return
+	}
+
+	db.Delete(&identity)
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "diary-app"
+
+// mockOIDC is an OpenID provider whose token endpoint returns an ID token with
+// whatever claims the test put in next
+type mockOIDC struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	next   jwt.MapClaims
+	form   url.Values // Last token request
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(gin.H{"keys": []gin.H{{
+			"kty": "EC", "crv": "P-256", "kid": "k1", "use": "sig",
+			"x": b64url(key.X.FillBytes(make([]byte, 32))),
+			"y": b64url(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.form = r.PostForm
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, m.next)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(m.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(gin.H{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// claims returns valid ID token claims for subject, to be changed by the test
+func (m *mockOIDC) claims(subject, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                m.server.URL,
+		"aud":                testClientID,
+		"sub":                subject,
+		"nonce":              nonce,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"email":              subject + "@example.com",
+		"email_verified":     true,
+		"preferred_username": subject,
+	}
+}
+
+func setupOIDCTest(t *testing.T) *mockOIDC {
+	setupAuthTestDB(t)
+	m := newMockOIDC(t)
+	oidcProviders = map[string]*oidcProvider{
+		"mock": {id: "mock", name: "Mock", issuer: m.server.URL, clientID: testClientID, scopes: "openid email"},
+	}
+	oidcProviderID = []string{"mock"}
+	oidcRedirect = "https://diary.example/oidc/callback"
+	return m
+}
+
+// browser keeps the cookies the handler sets and sends them with later requests
+type browser struct {
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (b *browser) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, cookie := range b.cookies {
+		req.AddCookie(cookie)
+	}
+	b.handler.ServeHTTP(w, req)
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+}
+
+func oidcRouter(username string) *browser {
+	r := testRouter(username)
+	r.GET("/oidc/:provider/start", StartOIDCLogin)
+	r.POST("/oidc/:provider/link", StartOIDCLink)
+	r.POST("/oidc/callback", OIDCCallback)
+	return &browser{handler: r, cookies: make(map[string]*http.Cookie)}
+}
+
+// startFlow starts a login or link and returns the state and nonce sent to the provider
+func startFlow(t *testing.T, r http.Handler, method, path string) (string, string) {
+	t.Helper()
+	w := doJSON(r, method, path, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("start: status = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	decodeBody(t, w, &resp)
+	target, err := url.Parse(resp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := target.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("authorization URL = %s", resp.URL)
+	}
+	return q.Get("state"), q.Get("nonce")
+}
+
+func callback(r http.Handler, state string) *httptest.ResponseRecorder {
+	return doJSON(r, http.MethodPost, "/oidc/callback", gin.H{"state": state, "code": "code-1"})
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	m := setupOIDCTest(t)
+	r := oidcRouter("")
+
+	state, nonce := startFlow(t, r, http.MethodGet, "/oidc/mock/start")
+	m.next = m.claims("carol", nonce)
+	w := callback(r, state)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback: status = %d %s", w.Code, w.Body)
+	}
+	if m.form.Get("code") != "code-1" || m.form.Get("code_verifier") == "" {
+		t.Errorf("token request = %v", m.form)
+	}
+
+	var user User
+	if err := db.Where("username = ?", "carol").First(&user).Error; err != nil {
+		t.Fatalf("account not created: %v", err)
+	}
+	if user.Email != "carol@example.com" || user.EmailVerifiedAt == nil || user.Password != "" {
+		t.Errorf("new account = %+v", user)
+	}
+
+	// The state works once
+	if w := callback(r, state); w.Code != http.StatusBadRequest {
+		t.Errorf("reused state: status = %d", w.Code)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+	}{
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "another-nonce" }},
+		{"wrong audience", func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }},
+		{"multiple audiences without azp", func(claims jwt.MapClaims) { claims["aud"] = []string{testClientID, "someone-else"} }},
+		{"multiple audiences with another azp", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientID, "someone-else"}
+			claims["azp"] = "someone-else"
+		}},
+		{"wrong issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://issuer.example" }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setupOIDCTest(t)
+			r := oidcRouter("")
+
+			state, nonce := startFlow(t, r, http.MethodGet, "/oidc/mock/start")
+			m.next = m.claims("mallory", nonce)
+			tt.change(m.next)
+			if w := callback(r, state); w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d %s", w.Code, w.Body)
+			}
+
+			var users, identities, sessions int64
+			db.Model(&User{}).Count(&users)
+			db.Model(&Identity{}).Count(&identities)
+			db.Model(&Session{}).Count(&sessions)
+			if users != 0 || identities != 0 || sessions != 0 {
+				t.Errorf("users = %d, identities = %d, sessions = %d", users, identities, sessions)
+			}
+		})
+	}
+}
+
+func TestOIDCAcceptsMultipleAudiencesWithMatchingAzp(t *testing.T) {
+	m := setupOIDCTest(t)
+	r := oidcRouter("")
+
+	state, nonce := startFlow(t, r, http.MethodGet, "/oidc/mock/start")
+	m.next = m.claims("dave", nonce)
+	m.next["aud"] = []string{testClientID, "someone-else"}
+	m.next["azp"] = testClientID
+	if w := callback(r, state); w.Code != http.StatusOK {
+		t.Errorf("status = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCLinkRefusesIdentityOfAnotherAccount(t *testing.T) {
+	m := setupOIDCTest(t)
+	db.Create(&User{Username: "alice"})
+	db.Create(&User{Username: "bob", Password: "hash"})
+	db.Create(&Identity{Username: "alice", Provider: "mock", Issuer: m.server.URL, Subject: "shared-sub", CreatedAt: time.Now()})
+
+	r := oidcRouter("bob")
+	state, nonce := startFlow(t, r, http.MethodPost, "/oidc/mock/link")
+	m.next = m.claims("shared-sub", nonce)
+	if w := callback(r, state); w.Code != http.StatusConflict {
+		t.Fatalf("status = %d %s", w.Code, w.Body)
+	}
+
+	var identities []Identity
+	db.Find(&identities)
+	if len(identities) != 1 || identities[0].Username != "alice" {
+		t.Errorf("identities = %+v", identities)
+	}
+	var sessions int64
+	db.Model(&Session{}).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("%d sessions started", sessions)
+	}
+
+	// A fresh identity links to bob
+	state, nonce = startFlow(t, r, http.MethodPost, "/oidc/mock/link")
+	m.next = m.claims("bob-sub", nonce)
+	if w := callback(r, state); w.Code != http.StatusCreated {
+		t.Fatalf("link: status = %d %s", w.Code, w.Body)
+	}
+	var linked Identity
+	if err := db.Where("subject = ?", "bob-sub").First(&linked).Error; err != nil || linked.Username != "bob" {
+		t.Errorf("linked identity = %+v, %v", linked, err)
+	}
+}
+
+func TestOIDCCallbackNeedsTheStartingBrowser(t *testing.T) {
+	m := setupOIDCTest(t)
+	db.Create(&User{Username: "mallory", Password: "hash"})
+	db.Create(&User{Username: "alice", Password: "hash"})
+
+	// Mallory starts a link to her account and gets alice to finish it with alice's identity
+	mallory := oidcRouter("mallory")
+	state, nonce := startFlow(t, mallory, http.MethodPost, "/oidc/mock/link")
+	cookie := mallory.cookies[oidcCookie]
+	if cookie == nil || !cookie.HttpOnly || cookie.Value == "" {
+		t.Fatalf("flow cookie = %+v", cookie)
+	}
+	m.next = m.claims("alice-sub", nonce)
+
+	alice := oidcRouter("")
+	if w := callback(alice, state); w.Code != http.StatusBadRequest {
+		t.Errorf("callback without the cookie: status = %d %s", w.Code, w.Body)
+	}
+	// Alice's own flow gives her a cookie, but not the one for mallory's state
+	startFlow(t, alice, http.MethodGet, "/oidc/mock/start")
+	if w := callback(alice, state); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with another flow's cookie: status = %d %s", w.Code, w.Body)
+	}
+	var count int64
+	db.Model(&Identity{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d identities linked", count)
+	}
+
+	// The rejected attempts did not spend the state; the browser that started it can finish
+	if w := callback(mallory, state); w.Code != http.StatusCreated {
+		t.Fatalf("callback from the starting browser: status = %d %s", w.Code, w.Body)
+	}
+	if _, ok := mallory.cookies[oidcCookie]; ok {
+		t.Error("flow cookie kept after the callback")
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords map[string]bool
+
+// passwordPolicy is configured with PASSWORD_MIN_LENGTH (default 8),
+// PASSWORD_MIN_CLASSES, how many of lowercase/uppercase/digits/symbols must
+// appear (default 1), and PASSWORD_CHECK_COMMON=false to allow passwords
+// from the bundled common password list.
+var passwordPolicy = struct {
+	minLength   int
+	minClasses  int
+	checkCommon bool
+}{8, 1, true}
+
+// bcrypt ignores everything after 72 bytes
+const passwordMaxBytes = 72
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 30
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.]*$`)
+
+// Names that would be confusing or could impersonate the app. "anonymous" is
+// what public entries show instead of the author.
+var reservedUsernames = map[string]bool{
+	"anonymous": true, "admin": true, "administrator": true, "root": true, "system": true,
+	"support": true, "help": true, "api": true, "me": true, "null": true, "undefined": true,
+	"moderator": true, "staff": true, "yesterdaysme": true,
+}
+
+func initPasswordPolicy() {
+	commonPasswords = make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			commonPasswords[line] = true
+		}
+	}
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > passwordMaxBytes {
+			log.Fatalf("Invalid PASSWORD_MIN_LENGTH %q", v)
+		}
+		passwordPolicy.minLength = n
+	}
+	if v := os.Getenv("PASSWORD_MIN_CLASSES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4 {
+			log.Fatalf("Invalid PASSWORD_MIN_CLASSES %q", v)
+		}
+		passwordPolicy.minClasses = n
+	}
+	passwordPolicy.checkCommon = os.Getenv("PASSWORD_CHECK_COMMON") != "false"
+}
+
+// normalizeUsername trims and lowercases a username and checks the rules
+func normalizeUsername(raw string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(raw))
+	if n := utf8.RuneCountInString(name); n < usernameMinLength || n > usernameMaxLength {
+		return "", fmt.Errorf("Username must be %d-%d characters", usernameMinLength, usernameMaxLength)
+	}
+	if !usernamePattern.MatchString(name) {
+		return "", errors.New("Username may only contain letters, digits, _ and . and must start with a letter or digit")
+	}
+	if reservedUsernames[name] {
+		return "", errors.New("This username is reserved")
+	}
+	return name, nil
+}
+
+// usernameTaken compares case-insensitively, since accounts made before
+// normalization may still have uppercase letters
+func usernameTaken(name string) bool {
+	var count int64
+	db.Model(&User{}).Where("LOWER(username) = ?", strings.ToLower(name)).Count(&count)
+	return count > 0
+}
+
+// findUserByLogin looks a user up by the name typed at login. Exact matches win,
+// so older mixed-case accounts keep working.
+func findUserByLogin(login string, user *User) error {
+	login = strings.TrimSpace(login)
+	if err := db.Where("username = ?", login).First(user).Error; err == nil {
+		return nil
+	}
+	return db.Where("LOWER(username) = ?", strings.ToLower(login)).First(user).Error
+}
+
+// checkPassword applies the password policy
+func checkPassword(password, username string) error {
+	if utf8.RuneCountInString(password) < passwordPolicy.minLength {
+		return fmt.Errorf("Password must be at least %d characters", passwordPolicy.minLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return fmt.Errorf("Password must be at most %d bytes", passwordMaxBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < passwordPolicy.minClasses {
+		return fmt.Errorf("Password must mix at least %d of lowercase, uppercase, digits and symbols", passwordPolicy.minClasses)
+	}
+
+	folded := strings.ToLower(password)
+	if username != "" && strings.Contains(folded, strings.ToLower(username)) {
+		return errors.New("Password must not contain the username")
+	}
+	if passwordPolicy.checkCommon {
+		// "Password123!" is as guessable as "password"
+		base := strings.TrimRightFunc(folded, func(r rune) bool { return !unicode.IsLetter(r) })
+		if commonPasswords[folded] || (len(base) >= 4 && commonPasswords[base]) {
+			return errors.New("This password is too common, please choose another")
+		}
+	}
+	return nil
+}
+
+// ChangePassword sets a new password and logs out every other session. Accounts
+// created through single sign-on have no password yet; they set one from a session
+// that logged in within reauthWindow. With 2FA on, a code is needed either way.
+func ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !reauthenticate(c, &user, input.CurrentPassword, input.Code, input.RecoveryCode) {
+		return
+	}
+	if err := checkPassword(input.NewPassword, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	if err := db.Model(&user).Updates(map[string]interface{}{"password": string(hashedPassword), "failed_logins": 0, "locked_until": nil}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	revokeSessions("password_change", "username = ? AND sid <> ?", user.Username, c.GetString("sid"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, other devices have been logged out"})
+}
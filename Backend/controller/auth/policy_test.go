@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testNewPassword = "Tr1cky-Lantern-42"
+
+// passwordRouter serves ChangePassword for a session of username that started at created
+func passwordRouter(username string, created time.Time) *gin.Engine {
+	session := Session{SID: randomToken(16), Username: username, CreatedAt: created, LastUsedAt: created, ExpiresAt: created.Add(refreshTTL)}
+	db.Create(&session)
+
+	r := testRouter(username)
+	r.Use(func(c *gin.Context) {
+		c.Set("sid", session.SID)
+	})
+	r.POST("/profile/password", ChangePassword)
+	return r
+}
+
+func TestChangePasswordWithoutPasswordNeedsRecentLogin(t *testing.T) {
+	setupAuthTestDB(t)
+	db.Create(&User{Username: "sso"})
+
+	w := doJSON(passwordRouter("sso", time.Now().Add(-time.Hour)), http.MethodPost, "/profile/password", gin.H{"newPassword": testNewPassword})
+	var resp struct {
+		ReauthRequired bool `json:"reauthRequired"`
+	}
+	decodeBody(t, w, &resp)
+	if w.Code != http.StatusUnauthorized || !resp.ReauthRequired {
+		t.Errorf("old session: status = %d %s", w.Code, w.Body)
+	}
+
+	w = doJSON(passwordRouter("sso", time.Now()), http.MethodPost, "/profile/password", gin.H{"newPassword": testNewPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("fresh session: status = %d %s", w.Code, w.Body)
+	}
+	var user User
+	db.Where("username = ?", "sso").First(&user)
+	if user.Password == "" {
+		t.Error("password was not set")
+	}
+}
+
+func TestChangePasswordNeedsSecondFactor(t *testing.T) {
+	setupAuthTestDB(t)
+	secret := []byte("12345678901234567890")
+	db.Create(&User{Username: "sso", TOTPEnabled: true, TOTPSecret: b32.EncodeToString(secret)})
+	r := passwordRouter("sso", time.Now())
+
+	if w := doJSON(r, http.MethodPost, "/profile/password", gin.H{"newPassword": testNewPassword}); w.Code != http.StatusUnauthorized {
+		t.Errorf("without code: status = %d %s", w.Code, w.Body)
+	}
+	if w := doJSON(r, http.MethodPost, "/profile/password", gin.H{"newPassword": testNewPassword, "code": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: status = %d %s", w.Code, w.Body)
+	}
+
+	code := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if w := doJSON(r, http.MethodPost, "/profile/password", gin.H{"newPassword": testNewPassword, "code": code}); w.Code != http.StatusOK {
+		t.Errorf("with code: status = %d %s", w.Code, w.Body)
+	}
+}
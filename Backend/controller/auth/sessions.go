@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Session is one login. Access tokens carry its SID, so revoking the session
+// cuts off every token issued for it.
+type Session struct {
+	ID           uint       `json:"-" gorm:"primaryKey"`
+	SID          string     `json:"id" gorm:"column:sid;uniqueIndex"`
+	Username     string     `json:"-" gorm:"index"`
+	UserAgent    string     `json:"userAgent"`
+	IP           string     `json:"ip"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"` // Moves forward on every refresh
+	RevokedAt    *time.Time `json:"-"`
+	RevokeReason string     `json:"-"` // logout, logout_all, refresh_token_reuse, revoked, ...
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// lastUsedResolution limits how often requests write LastUsedAt back to auth.db
+const lastUsedResolution = time.Minute
+
+// RefreshToken is stored as a SHA-256 hash. Each one can be used once; a used
+// token that shows up again means it was stolen, so the session is revoked.
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	SessionID uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+var refreshTTL = 30 * 24 * time.Hour
+
+func initSessions() {
+	if v := os.Getenv("JWT_REFRESH_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid JWT_REFRESH_TTL %q", v)
+		}
+		refreshTTL = d
+	}
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Session) active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// issueTokens signs an access token for the session and stores a fresh refresh token
+func issueTokens(s *Session) (gin.H, error) {
+	now := time.Now()
+	access, err := signClaims(jwt.MapClaims{"username": s.Username, "sid": s.SID}, tokenTT)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := randomToken(32)
+	s.ExpiresAt = now.Add(refreshTTL)
+	if err := db.Model(s).Update("expires_at", s.ExpiresAt).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Create(&RefreshToken{SessionID: s.ID, TokenHash: hashToken(refresh), ExpiresAt: s.ExpiresAt, CreatedAt: now}).Error; err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":        access,
+		"refreshToken": refresh,
+		"expiresIn":    int(tokenTT.Seconds()),
+	}, nil
+}
+
+// startSession creates a session for a user who just proved who they are
+func startSession(c *gin.Context, username string) (gin.H, error) {
+	now := time.Now()
+	// Used tokens are kept until they expire so reuse can still be detected
+	db.Where("expires_at < ?", now).Delete(&RefreshToken{})
+
+	session := Session{
+		SID:        randomToken(16),
+		Username:   username,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTTL),
+		CreatedAt:  now,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return issueTokens(&session)
+}
+
+// revokeSessions revokes the matching active sessions
+func revokeSessions(reason string, query interface{}, args ...interface{}) error {
+	return db.Model(&Session{}).Where(query, args...).Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoke_reason": reason}).Error
+}
+
+// sessionActive reports whether the session behind an access token may still be used,
+// and records that it was just used from this client
+func sessionActive(c *gin.Context, sid, username string) bool {
+	var session Session
+	if err := db.Where("sid = ? AND username = ?", sid, username).First(&session).Error; err != nil {
+		return false
+	}
+	now := time.Now()
+	if !session.active(now) {
+		return false
+	}
+	if now.Sub(session.LastUsedAt) >= lastUsedResolution || session.IP != c.ClientIP() {
+		touchSession(c, &session, now)
+	}
+	return true
+}
+
+func touchSession(c *gin.Context, s *Session, now time.Time) {
+	db.Model(s).Updates(map[string]interface{}{
+		"last_used_at": now,
+		"ip":           c.ClientIP(),
+		"user_agent":   c.Request.UserAgent(),
+	})
+}
+
+// Refresh trades a refresh token for a new access token and a new refresh token
+func Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	var token RefreshToken
+	if err := db.Where("token_hash = ?", hashToken(input.RefreshToken)).First(&token).Error; err != nil || now.After(token.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var session Session
+	if err := db.First(&session, token.SessionID).Error; err != nil || !session.active(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+		return
+	}
+
+	// Claim the token atomically; losing the race counts as reuse too
+	result := db.Model(&RefreshToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Refresh token reuse detected for %s, revoking session %s", session.Username, session.SID)
+		revokeSessions("refresh_token_reuse", "id = ?", session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used; please log in again"})
+		return
+	}
+
+	touchSession(c, &session, now)
+	tokens, err := issueTokens(&session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the current session
+func Logout(c *gin.Context) {
+	if err := revokeSessions("logout", "sid = ? AND username = ?", c.GetString("sid"), c.GetString("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAll revokes every session of the user, this one included
+func LogoutAll(c *gin.Context) {
+	if err := revokeSessions("logout_all", "username = ?", c.GetString("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all devices"})
+}
+
+// describeDevice turns a user agent into a short label like "Chrome on Android"
+func describeDevice(ua string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"}, {"CriOS/", "Chrome"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			platform = o.name
+			break
+		}
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+// GetSessions lists the user's active sessions, newest use first
+func GetSessions(c *gin.Context) {
+	var sessions []Session
+	db.Where("username = ? AND revoked_at IS NULL AND expires_at > ?", c.GetString("username"), time.Now()).
+		Order("last_used_at desc").Find(&sessions)
+
+	current := c.GetString("sid")
+	list := []gin.H{}
+	for _, s := range sessions {
+		list = append(list, gin.H{
+			"id":         s.SID,
+			"device":     describeDevice(s.UserAgent),
+			"userAgent":  s.UserAgent,
+			"ip":         s.IP,
+			"lastUsedAt": s.LastUsedAt,
+			"createdAt":  s.CreatedAt,
+			"expiresAt":  s.ExpiresAt,
+			"current":    s.SID == current,
+		})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// RevokeSession ends one of the user's sessions, e.g. on a lost phone
+func RevokeSession(c *gin.Context) {
+	result := db.Model(&Session{}).
+		Where("sid = ? AND username = ? AND revoked_at IS NULL", c.Param("id"), c.GetString("username")).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoke_reason": "revoked"})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
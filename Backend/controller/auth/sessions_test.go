@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// login starts a session for username the way a successful login does
+func login(t *testing.T, username, userAgent string) sessionTokens {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	c.Request.Header.Set("User-Agent", userAgent)
+	tokens, err := startSession(c, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionTokens{Token: tokens["token"].(string), RefreshToken: tokens["refreshToken"].(string)}
+}
+
+// sessionRouter serves the session routes behind AuthMiddleware like main does
+func sessionRouter() *gin.Engine {
+	r := gin.New()
+	r.POST("/auth/refresh", Refresh)
+	protected := r.Group("/")
+	protected.Use(AuthMiddleware())
+	protected.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"username": c.GetString("username")})
+	})
+	protected.POST("/auth/logout", Logout)
+	protected.POST("/auth/logout-all", LogoutAll)
+	protected.GET("/auth/sessions", GetSessions)
+	protected.DELETE("/auth/sessions/:id", RevokeSession)
+	return r
+}
+
+func doAuth(r http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func refresh(t *testing.T, r http.Handler, refreshToken string) (sessionTokens, int) {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, "/auth/refresh", gin.H{"refreshToken": refreshToken})
+	var tokens sessionTokens
+	if w.Code == http.StatusOK {
+		decodeBody(t, w, &tokens)
+	}
+	return tokens, w.Code
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	first := login(t, "alice", "test")
+
+	second, code := refresh(t, r, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: %d %+v", code, second)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", second.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("new access token: %d %s", w.Code, w.Body)
+	}
+	// The rotated token keeps working for the next refresh
+	if _, code := refresh(t, r, second.RefreshToken); code != http.StatusOK {
+		t.Errorf("second refresh: %d", code)
+	}
+
+	if _, code := refresh(t, r, "made-up"); code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: %d", code)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	stolen := login(t, "alice", "test")
+	other := login(t, "alice", "other device")
+
+	// The thief refreshes first, then the real client presents the same token
+	thief, code := refresh(t, r, stolen.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("first refresh: %d", code)
+	}
+	if _, code := refresh(t, r, stolen.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: %d", code)
+	}
+
+	claims, _ := parseClaims(stolen.Token)
+	var session Session
+	db.Where("sid = ?", claims["sid"]).First(&session)
+	if session.RevokedAt == nil || session.RevokeReason != "refresh_token_reuse" {
+		t.Errorf("session after reuse = %+v", session)
+	}
+	// Everything issued for the session stops working, including the thief's tokens
+	if _, code := refresh(t, r, thief.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("thief's refresh token: %d", code)
+	}
+	for name, token := range map[string]string{"original": stolen.Token, "thief": thief.Token} {
+		if w := doAuth(r, http.MethodGet, "/me", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s access token after reuse: %d", name, w.Code)
+		}
+	}
+	// Other sessions of the user are left alone
+	if w := doAuth(r, http.MethodGet, "/me", other.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("other session: %d", w.Code)
+	}
+}
+
+func TestRefreshAfterExpiry(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	tokens := login(t, "alice", "test")
+	db.Model(&RefreshToken{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+
+	if _, code := refresh(t, r, tokens.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expired refresh token: %d", code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	current := login(t, "alice", "test")
+	other := login(t, "alice", "other device")
+
+	if w := doAuth(r, http.MethodPost, "/auth/logout", current.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout: %d %s", w.Code, w.Body)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", current.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: %d", w.Code)
+	}
+	if _, code := refresh(t, r, current.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: %d", code)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", other.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("other session after logout: %d", w.Code)
+	}
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	current := login(t, "alice", "test")
+	other := login(t, "alice", "other device")
+	bob := login(t, "bob", "test")
+
+	if w := doAuth(r, http.MethodPost, "/auth/logout-all", current.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout-all: %d %s", w.Code, w.Body)
+	}
+	for name, tokens := range map[string]sessionTokens{"current": current, "other": other} {
+		if w := doAuth(r, http.MethodGet, "/me", tokens.Token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s access token: %d", name, w.Code)
+		}
+		if _, code := refresh(t, r, tokens.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("%s refresh: %d", name, code)
+		}
+	}
+	if w := doAuth(r, http.MethodGet, "/me", bob.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("another user's session: %d", w.Code)
+	}
+}
+
+func TestGetSessionsListsActiveSessions(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	current := login(t, "alice", "Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/126.0 Mobile Safari/537.36")
+	login(t, "alice", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 Version/17.5 Mobile/15E148 Safari/604.1")
+	ended := login(t, "alice", "curl/8.5.0")
+	doAuth(r, http.MethodPost, "/auth/logout", ended.Token, nil)
+	login(t, "bob", "curl/8.5.0")
+
+	var sessions []struct {
+		ID      string `json:"id"`
+		Device  string `json:"device"`
+		Current bool   `json:"current"`
+	}
+	decodeBody(t, doAuth(r, http.MethodGet, "/auth/sessions", current.Token, nil), &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	devices := make(map[string]bool)
+	currents := 0
+	for _, s := range sessions {
+		devices[s.Device] = s.Current
+		if s.Current {
+			currents++
+		}
+	}
+	if currents != 1 || !devices["Chrome on Android"] || devices["Safari on iPhone"] {
+		t.Errorf("sessions = %+v", sessions)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	setupAuthTestDB(t)
+	r := sessionRouter()
+	current := login(t, "alice", "test")
+	lost := login(t, "alice", "lost phone")
+	bob := login(t, "bob", "test")
+	sid := func(tokens sessionTokens) string {
+		claims, _ := parseClaims(tokens.Token)
+		return claims["sid"].(string)
+	}
+
+	// Another user's session looks the same as one that doesn't exist
+	if w := doAuth(r, http.MethodDelete, "/auth/sessions/"+sid(bob), current.Token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session: %d", w.Code)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", bob.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("bob's session: %d", w.Code)
+	}
+
+	if w := doAuth(r, http.MethodDelete, "/auth/sessions/"+sid(lost), current.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", lost.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked access token: %d", w.Code)
+	}
+	if _, code := refresh(t, r, lost.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("revoked refresh token: %d", code)
+	}
+	if w := doAuth(r, http.MethodGet, "/me", current.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("current session: %d", w.Code)
+	}
+	// Revoking twice is not found
+	if w := doAuth(r, http.MethodDelete, "/auth/sessions/"+sid(lost), current.Token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoking again: %d", w.Code)
+	}
+}
+
+func TestDescribeDevice(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/126.0 Safari/537.36 Edg/126.0": "Edge on Windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) Gecko/20100101 Firefox/127.0":                         "Firefox on macOS",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) CriOS/126.0 Mobile/15E148 Safari/604.1":     "Chrome on iPhone",
+		"curl/8.5.0": "curl",
+		"":           "Unknown browser",
+	} {
+		if got := describeDevice(ua); got != want {
+			t.Errorf("describeDevice(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpPeriod         = 30
+	totpDigits         = 6
+	totpSkew           = 1 // Accept codes one step before or after, for clock drift
+	recoveryCodeCount  = 10
+	challengeTTL       = 5 * time.Minute
+	challengeAttempts  = 5
+	totpIssuer         = "Yesterday's Me"
+	recoveryCodeLength = 10
+)
+
+// RecoveryCode is a one-time fallback for a lost authenticator, stored hashed
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"index"`
+	CodeHash  string `gorm:"uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// LoginChallenge is the second step of a login for an account with 2FA.
+// The password was right; the session starts once a code is checked too.
+type LoginChallenge struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	Attempts  int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1) for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// checkTOTP returns the matching time step, refusing steps at or before lastStep (replays)
+func checkTOTP(secret string, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTP checks a code for an enrolled user and remembers the step so it cannot be reused
+func verifyTOTP(user *User, code string) bool {
+	step, ok := checkTOTP(user.TOTPSecret, code, user.TOTPLastStep, time.Now())
+	if !ok {
+		return false
+	}
+	result := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func provisioningURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the plain codes once
+func newRecoveryCodes(username string) ([]string, error) {
+	db.Where("username = ?", username).Delete(&RecoveryCode{})
+
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // No look-alike characters
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j, b := range buf {
+			buf[j] = alphabet[int(b)%len(alphabet)]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+		if err := db.Create(&RecoveryCode{Username: username, CodeHash: hashToken(codes[i]), CreatedAt: time.Now()}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// useRecoveryCode consumes one recovery code
+func useRecoveryCode(username, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	result := db.Model(&RecoveryCode{}).
+		Where("username = ? AND code_hash = ? AND used_at IS NULL", username, hashToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// checkSecondFactor accepts either a TOTP code or a recovery code
+func checkSecondFactor(user *User, code, recoveryCode string) bool {
+	if recoveryCode != "" {
+		return useRecoveryCode(user.Username, recoveryCode)
+	}
+	return code != "" && verifyTOTP(user, code)
+}
+
+// startChallenge is what Login returns instead of tokens when 2FA is on
+func startChallenge(username string) (gin.H, error) {
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&LoginChallenge{})
+
+	token := randomToken(32)
+	err := db.Create(&LoginChallenge{Username: username, TokenHash: hashToken(token), ExpiresAt: now.Add(challengeTTL), CreatedAt: now}).Error
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"twoFactorRequired": true,
+		"challengeToken":    token,
+		"methods":           []string{"totp", "recovery_code"},
+		"expiresIn":         int(challengeTTL.Seconds()),
+	}, nil
+}
+
+// --- Handlers ---
+
+// LoginSecondFactor finishes a 2FA login with a TOTP or recovery code
+func LoginSecondFactor(c *gin.Context) {
+	var input struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var challenge LoginChallenge
+	err := db.Where("token_hash = ?", hashToken(input.ChallengeToken)).First(&challenge).Error
+	if err != nil || challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= challengeAttempts {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, please log in again"})
+		return
+	}
+	db.Model(&challenge).Update("attempts", challenge.Attempts+1)
+
+	var user User
+	if err := db.Where("username = ?", challenge.Username).First(&user).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, please log in again"})
+		return
+	}
+	if wait, locked := loginLocked(&user); locked {
+		lockedResponse(c, wait)
+		return
+	}
+	if !checkSecondFactor(&user, input.Code, input.RecoveryCode) {
+		recordFailedLogin(&user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	result := db.Model(&LoginChallenge{}).Where("id = ? AND used_at IS NULL", challenge.ID).Update("used_at", time.Now())
+	if result.RowsAffected != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge expired, please log in again"})
+		return
+	}
+
+	loginResponse(c, user)
+}
+
+// EnrollTOTP creates a new secret to scan into an authenticator app. 2FA stays
+// off until ConfirmTOTP sees a valid code for it.
+func EnrollTOTP(c *gin.Context) {
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create secret"})
+		return
+	}
+	secret := b32.EncodeToString(key)
+	if err := db.Model(&user).Update("totp_pending", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": provisioningURI(user.Username, secret),
+	})
+}
+
+// ConfirmTOTP enables 2FA once the user proves their app produces valid codes,
+// and returns the recovery codes (shown only this once)
+func ConfirmTOTP(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TOTPPending == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+		return
+	}
+	step, ok := checkTOTP(user.TOTPPending, input.Code, 0, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	err := db.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    user.TOTPPending,
+		"totp_pending":   "",
+		"totp_enabled":   true,
+		"totp_last_step": step,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	codes, err := newRecoveryCodes(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recoveryCodes": codes})
+}
+
+// DisableTOTP turns 2FA off; needs the password and a current code (or recovery code)
+func DisableTOTP(c *gin.Context) {
+	var input struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)) != nil ||
+		!checkSecondFactor(&user, input.Code, input.RecoveryCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	db.Model(&user).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0})
+	db.Where("username = ?", user.Username).Delete(&RecoveryCode{})
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes; needs a current TOTP code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !user.TOTPEnabled || !verifyTOTP(&user, input.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := newRecoveryCodes(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// GetTwoFactorStatus tells whether 2FA is on and how many recovery codes are left
+func GetTwoFactorStatus(c *gin.Context) {
+	username := c.GetString("username")
+	var user User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var remaining int64
+	db.Model(&RecoveryCode{}).Where("username = ? AND used_at IS NULL", username).Count(&remaining)
+	c.JSON(http.StatusOK, gin.H{"enabled": user.TOTPEnabled, "recoveryCodesLeft": remaining})
+}
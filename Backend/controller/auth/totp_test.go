@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to six digits
+	secret := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if got := totpCode(secret, unix/totpPeriod); got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestCheckTOTPSkewAndReplay(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := b32.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+
+	for offset, ok := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		step, got := checkTOTP(secret, totpCode(key, current+offset), 0, now)
+		if got != ok || (ok && step != current+offset) {
+			t.Errorf("offset %d: step %d, %v", offset, step, got)
+		}
+	}
+	// Spaces are allowed, as apps show "123 456"
+	code := totpCode(key, current)
+	if _, ok := checkTOTP(secret, " "+code[:3]+" "+code[3:], 0, now); !ok {
+		t.Error("spaced code rejected")
+	}
+	// Steps at or before the last one used are replays
+	if _, ok := checkTOTP(secret, code, current, now); ok {
+		t.Error("replayed step accepted")
+	}
+	if _, ok := checkTOTP(secret, totpCode(key, current-1), current-1, now); ok {
+		t.Error("earlier step accepted after it was used")
+	}
+	if _, ok := checkTOTP("not base32!", code, 0, now); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+// totpUser creates a user with 2FA on and returns the raw secret
+func totpUser(t *testing.T, username string) (User, []byte) {
+	t.Helper()
+	key := []byte(strings.Repeat("s", 20))
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testNewPassword), bcrypt.MinCost)
+	user := User{Username: username, Password: string(hash), TOTPEnabled: true, TOTPSecret: b32.EncodeToString(key)}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user, key
+}
+
+func currentCode(key []byte) string {
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	setupAuthTestDB(t)
+	user, key := totpUser(t, "alice")
+	code := currentCode(key)
+
+	if !verifyTOTP(&user, code) {
+		t.Fatal("fresh code rejected")
+	}
+	// The in-memory user still has the old last step; the database decides
+	if verifyTOTP(&user, code) {
+		t.Error("code accepted twice")
+	}
+	db.First(&user, user.ID)
+	if verifyTOTP(&user, code) {
+		t.Error("code accepted after reload")
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	setupAuthTestDB(t)
+	user, _ := totpUser(t, "alice")
+	totpUser(t, "bob")
+
+	codes, err := newRecoveryCodes("alice")
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("codes = %v, %v", codes, err)
+	}
+	if useRecoveryCode("bob", codes[0]) {
+		t.Error("another user's recovery code accepted")
+	}
+	if !checkSecondFactor(&user, "", " "+strings.ToUpper(codes[0])+" ") {
+		t.Error("recovery code rejected")
+	}
+	if checkSecondFactor(&user, "", codes[0]) {
+		t.Error("recovery code accepted twice")
+	}
+
+	// New codes replace the old ones
+	fresh, _ := newRecoveryCodes("alice")
+	if useRecoveryCode("alice", codes[1]) {
+		t.Error("replaced recovery code accepted")
+	}
+	if !useRecoveryCode("alice", fresh[1]) {
+		t.Error("new recovery code rejected")
+	}
+}
+
+func loginRouter() *gin.Engine {
+	r := testRouter("")
+	r.POST("/auth/login", Login)
+	r.POST("/auth/login/2fa", LoginSecondFactor)
+	return r
+}
+
+// startTwoFactorLogin logs in with the password and returns the challenge token
+func startTwoFactorLogin(t *testing.T, r http.Handler, username string) string {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, "/auth/login", gin.H{"username": username, "password": testNewPassword})
+	var resp struct {
+		Token             string `json:"token"`
+		TwoFactorRequired bool   `json:"twoFactorRequired"`
+		ChallengeToken    string `json:"challengeToken"`
+	}
+	decodeBody(t, w, &resp)
+	if w.Code != http.StatusOK || !resp.TwoFactorRequired || resp.ChallengeToken == "" || resp.Token != "" {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	return resp.ChallengeToken
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	setupAuthTestDB(t)
+	_, key := totpUser(t, "alice")
+	r := loginRouter()
+	challenge := startTwoFactorLogin(t, r, "alice")
+
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": challenge, "code": "000000"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: %d", w.Code)
+	}
+	code := currentCode(key)
+	w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": challenge, "code": code})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	decodeBody(t, w, &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("second step: %d %s", w.Code, w.Body)
+	}
+	var user User
+	db.Where("username = ?", "alice").First(&user)
+	if user.FailedLogins != 0 {
+		t.Errorf("failed logins after success = %d", user.FailedLogins)
+	}
+
+	// Neither the challenge nor the code can be used again
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": challenge, "code": code}); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused challenge: %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": startTwoFactorLogin(t, r, "alice"), "code": code}); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: %d", w.Code)
+	}
+}
+
+func TestTwoFactorLoginWithRecoveryCode(t *testing.T) {
+	setupAuthTestDB(t)
+	totpUser(t, "alice")
+	codes, _ := newRecoveryCodes("alice")
+	r := loginRouter()
+
+	w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": startTwoFactorLogin(t, r, "alice"), "recoveryCode": codes[0]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("recovery code: %d %s", w.Code, w.Body)
+	}
+	w = doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": startTwoFactorLogin(t, r, "alice"), "recoveryCode": codes[0]})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: %d", w.Code)
+	}
+}
+
+func TestTwoFactorChallengeLimits(t *testing.T) {
+	setupAuthTestDB(t)
+	_, key := totpUser(t, "alice")
+	r := loginRouter()
+
+	challenge := startTwoFactorLogin(t, r, "alice")
+	for i := 0; i < challengeAttempts; i++ {
+		doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": challenge, "code": "000000"})
+	}
+	// Out of attempts; the right code no longer helps (the account may be locked by now too)
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": challenge, "code": currentCode(key)}); w.Code == http.StatusOK {
+		t.Error("challenge accepted after running out of attempts")
+	}
+
+	db.Model(&User{}).Where("username = ?", "alice").Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+	expired := startTwoFactorLogin(t, r, "alice")
+	db.Model(&LoginChallenge{}).Where("token_hash = ?", hashToken(expired)).Update("expires_at", time.Now().Add(-time.Second))
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": expired, "code": currentCode(key)}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired challenge: %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodPost, "/auth/login/2fa", gin.H{"challengeToken": "made-up", "code": currentCode(key)}); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown challenge: %d", w.Code)
+	}
+}
+
+func TestEnrollAndConfirmTOTP(t *testing.T) {
+	setupAuthTestDB(t)
+	db.Create(&User{Username: "alice"})
+	r := testRouter("alice")
+	r.POST("/2fa/enroll", EnrollTOTP)
+	r.POST("/2fa/confirm", ConfirmTOTP)
+	r.GET("/2fa", GetTwoFactorStatus)
+
+	if w := doJSON(r, http.MethodPost, "/2fa/confirm", gin.H{"code": "123456"}); w.Code != http.StatusBadRequest {
+		t.Errorf("confirm before enrolling: %d", w.Code)
+	}
+
+	var enroll struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioningUri"`
+	}
+	decodeBody(t, doJSON(r, http.MethodPost, "/2fa/enroll", nil), &enroll)
+	if !strings.HasPrefix(enroll.ProvisioningURI, "otpauth://totp/") || !strings.Contains(enroll.ProvisioningURI, "secret="+enroll.Secret) {
+		t.Errorf("provisioning URI = %q", enroll.ProvisioningURI)
+	}
+	key, err := b32.DecodeString(enroll.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not enabled until a code is confirmed
+	var status struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/2fa", nil), &status)
+	if status.Enabled {
+		t.Error("2FA enabled before confirming")
+	}
+
+	code := currentCode(key)
+	if w := doJSON(r, http.MethodPost, "/2fa/confirm", gin.H{"code": "000000"}); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong confirmation code: %d", w.Code)
+	}
+	var confirm struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	decodeBody(t, doJSON(r, http.MethodPost, "/2fa/confirm", gin.H{"code": code}), &confirm)
+	if len(confirm.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %v", confirm.RecoveryCodes)
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/2fa", nil), &status)
+	if !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount {
+		t.Errorf("status = %+v", status)
+	}
+
+	// The confirmation code cannot be replayed at login
+	var user User
+	db.Where("username = ?", "alice").First(&user)
+	if verifyTOTP(&user, code) {
+		t.Error("confirmation code accepted again")
+	}
+	if w := doJSON(r, http.MethodPost, "/2fa/enroll", nil); w.Code != http.StatusConflict {
+		t.Errorf("enrolling twice: %d", w.Code)
+	}
+}
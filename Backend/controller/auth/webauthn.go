@@ -0,0 +1,561 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Passkeys (WebAuthn). The relying party is configured with WEBAUTHN_RP_ID
+// (default: the host of APP_URL), WEBAUTHN_RP_NAME and WEBAUTHN_ORIGINS, a comma
+// separated list of origins allowed to run the ceremonies (default: APP_URL).
+// Attestation is not requested, so a new passkey is trusted on first use.
+
+const webauthnTimeout = 5 * time.Minute
+
+// COSE algorithm identifiers
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Passkey is a WebAuthn credential that can log a user in
+type Passkey struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Username     string     `json:"-" gorm:"index"`
+	CredentialID string     `json:"-" gorm:"uniqueIndex"` // base64url
+	PublicKey    []byte     `json:"-"`                    // COSE_Key as sent by the authenticator
+	Algorithm    int64      `json:"algorithm"`
+	SignCount    uint32     `json:"-"`
+	Name         string     `json:"name"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// WebAuthnChallenge is a challenge handed to the browser for one ceremony
+type WebAuthnChallenge struct {
+	ID        uint   `gorm:"primaryKey"`
+	Challenge string `gorm:"uniqueIndex"` // base64url
+	Username  string // Empty for a login without a username (discoverable passkeys)
+	Purpose   string // register or login
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+var (
+	rpID      string
+	rpName    string
+	rpOrigins []string
+)
+
+func initWebAuthn() {
+	app := envOr("APP_URL", "http://localhost:5173")
+	host := "localhost"
+	if u, err := url.Parse(app); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	rpID = envOr("WEBAUTHN_RP_ID", host)
+	rpName = envOr("WEBAUTHN_RP_NAME", "Yesterday's Me")
+
+	rpOrigins = nil
+	for _, o := range strings.Split(envOr("WEBAUTHN_ORIGINS", app), ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			rpOrigins = append(rpOrigins, o)
+		}
+	}
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeB64URL accepts base64url with or without padding, as browsers differ
+func decodeB64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func userHandle(user User) []byte {
+	return []byte(fmt.Sprint(user.ID))
+}
+
+func newWebAuthnChallenge(username, purpose string) (string, error) {
+	now := time.Now()
+	db.Where("expires_at < ?", now).Delete(&WebAuthnChallenge{})
+
+	challenge := randomToken(32)
+	err := db.Create(&WebAuthnChallenge{
+		Challenge: challenge,
+		Username:  username,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(webauthnTimeout),
+		CreatedAt: now,
+	}).Error
+	return challenge, err
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// checkClientData verifies clientDataJSON and consumes the challenge it answers
+func checkClientData(raw []byte, ceremony, purpose string) (WebAuthnChallenge, error) {
+	var record WebAuthnChallenge
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return record, errors.New("invalid client data")
+	}
+	if data.Type != ceremony {
+		return record, errors.New("wrong ceremony type")
+	}
+	originOK := false
+	for _, o := range rpOrigins {
+		originOK = originOK || data.Origin == o
+	}
+	if !originOK {
+		return record, fmt.Errorf("origin %q is not allowed", data.Origin)
+	}
+
+	challenge := strings.TrimRight(data.Challenge, "=")
+	if err := db.Where("challenge = ? AND purpose = ?", challenge, purpose).First(&record).Error; err != nil {
+		return record, errors.New("unknown challenge")
+	}
+	if record.UsedAt != nil || time.Now().After(record.ExpiresAt) {
+		return record, errors.New("challenge expired")
+	}
+	result := db.Model(&WebAuthnChallenge{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		return record, errors.New("challenge expired")
+	}
+	return record, nil
+}
+
+type authenticatorData struct {
+	raw          []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte // Raw COSE_Key, only present during registration
+}
+
+// parseAuthData reads authenticator data and checks the RP ID hash and the
+// user present and verified flags
+func parseAuthData(data []byte) (authenticatorData, error) {
+	ad := authenticatorData{raw: data}
+	if len(data) < 37 {
+		return ad, errors.New("authenticator data too short")
+	}
+	rpHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(data[:32], rpHash[:]) {
+		return ad, errors.New("credential is for another site")
+	}
+	ad.flags = data[32]
+	ad.signCount = binary.BigEndian.Uint32(data[33:37])
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return ad, errors.New("user was not verified by the authenticator")
+	}
+
+	if ad.flags&flagAttestedData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 { // AAGUID + credential ID length
+			return ad, errors.New("attested credential data too short")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || len(rest) < idLen {
+			return ad, errors.New("invalid credential ID")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return ad, errors.New("invalid credential public key")
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+	}
+	return ad, nil
+}
+
+// parseCOSEKey turns a COSE_Key into a Go public key and its algorithm
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	item, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("COSE key is not a map")
+	}
+	kty, _ := cborInt(m[int64(1)])
+	alg, _ := cborInt(m[int64(3)])
+	crv, _ := cborInt(m[int64(-1)])
+	x, _ := m[int64(-2)].([]byte)
+	y, _ := m[int64(-3)].([]byte)
+
+	switch {
+	case kty == 2 && alg == coseES256 && crv == 1:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, alg, nil
+	case kty == 1 && alg == coseEdDSA && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == coseRS256:
+		// For RSA keys -1 and -2 are the modulus and exponent
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported key type %d with algorithm %d", kty, alg)
+}
+
+// verifyAssertion checks a signature over authenticatorData || SHA-256(clientDataJSON)
+func verifyAssertion(coseKey, authData, clientDataJSON, signature []byte) error {
+	key, _, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+	clientHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	ok := false
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, signed, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// credentialJSON is PublicKeyCredential as serialized by the browser, binary fields in base64url
+type credentialJSON struct {
+	ID       string `json:"id" binding:"required"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+func webauthnError(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey verification failed: " + err.Error()})
+}
+
+// --- Handlers ---
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create().
+// A passkey is a new way to log in, so it needs the same confirmation as changing
+// the password; FinishPasskeyRegistration only accepts a challenge issued here.
+func BeginPasskeyRegistration(c *gin.Context) {
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !reauthenticate(c, &user, input.Password, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	challenge, err := newWebAuthnChallenge(user.Username, "register")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	var existing []Passkey
+	db.Where("username = ?", user.Username).Find(&existing)
+	exclude := []gin.H{}
+	for _, p := range existing {
+		exclude = append(exclude, gin.H{"type": "public-key", "id": p.CredentialID})
+	}
+
+	displayName := user.DisplayName
+	if displayName == "" {
+		displayName = user.Username
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": gin.H{
+		"challenge": challenge,
+		"rp":        gin.H{"id": rpID, "name": rpName},
+		"user":      gin.H{"id": b64url(userHandle(user)), "name": user.Username, "displayName": displayName},
+		"pubKeyCredParams": []gin.H{
+			{"type": "public-key", "alg": coseES256},
+			{"type": "public-key", "alg": coseEdDSA},
+			{"type": "public-key", "alg": coseRS256},
+		},
+		"timeout":            webauthnTimeout.Milliseconds(),
+		"attestation":        "none",
+		"excludeCredentials": exclude,
+		"authenticatorSelection": gin.H{
+			"residentKey":      "preferred",
+			"userVerification": "required",
+		},
+	}})
+}
+
+// FinishPasskeyRegistration stores the credential created by the authenticator
+func FinishPasskeyRegistration(c *gin.Context) {
+	var input struct {
+		Name       string         `json:"name"`
+		Credential credentialJSON `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	username := c.GetString("username")
+
+	clientDataJSON, err := decodeB64URL(input.Credential.Response.ClientDataJSON)
+	if err != nil {
+		webauthnError(c, errors.New("invalid client data"))
+		return
+	}
+	challenge, err := checkClientData(clientDataJSON, "webauthn.create", "register")
+	if err != nil {
+		webauthnError(c, err)
+		return
+	}
+	if challenge.Username != username {
+		webauthnError(c, errors.New("challenge belongs to another user"))
+		return
+	}
+
+	attestation, err := decodeB64URL(input.Credential.Response.AttestationObject)
+	if err != nil {
+		webauthnError(c, errors.New("invalid attestation object"))
+		return
+	}
+	item, _, err := decodeCBOR(attestation)
+	object, ok := item.(map[interface{}]interface{})
+	if err != nil || !ok {
+		webauthnError(c, errors.New("invalid attestation object"))
+		return
+	}
+	// Attestation is not requested, so any statement the authenticator still sends is ignored
+	rawAuthData, _ := object["authData"].([]byte)
+	authData, err := parseAuthData(rawAuthData)
+	if err != nil {
+		webauthnError(c, err)
+		return
+	}
+	if authData.publicKey == nil {
+		webauthnError(c, errors.New("no credential in authenticator data"))
+		return
+	}
+	_, alg, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		webauthnError(c, err)
+		return
+	}
+
+	credentialID := b64url(authData.credentialID)
+	var count int64
+	db.Model(&Passkey{}).Where("credential_id = ?", credentialID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = describeDevice(c.Request.UserAgent())
+	}
+	passkey := Passkey{
+		Username:     username,
+		CredentialID: credentialID,
+		PublicKey:    authData.publicKey,
+		Algorithm:    alg,
+		SignCount:    authData.signCount,
+		Name:         name,
+		CreatedAt:    time.Now(),
+	}
+	if err := db.Create(&passkey).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+		return
+	}
+	c.JSON(http.StatusCreated, passkey)
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get(). Without a
+// username the browser offers the discoverable passkeys it has for this site.
+func BeginPasskeyLogin(c *gin.Context) {
+	var input struct {
+		Username string `json:"username"`
+	}
+	c.ShouldBindJSON(&input)
+	username := strings.TrimSpace(input.Username)
+	// Resolve the name like a password login does, so "Alice " finds alice's passkeys
+	var user User
+	if username != "" && findUserByLogin(username, &user) == nil {
+		username = user.Username
+	}
+
+	challenge, err := newWebAuthnChallenge(username, "login")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	allow := []gin.H{}
+	if username != "" {
+		var passkeys []Passkey
+		db.Where("username = ?", username).Find(&passkeys)
+		for _, p := range passkeys {
+			allow = append(allow, gin.H{"type": "public-key", "id": p.CredentialID})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": gin.H{
+		"challenge":        challenge,
+		"rpId":             rpID,
+		"timeout":          webauthnTimeout.Milliseconds(),
+		"userVerification": "required",
+		"allowCredentials": allow,
+	}})
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session. A passkey with
+// user verification is already two factors, so TOTP is not asked for.
+func FinishPasskeyLogin(c *gin.Context) {
+	var input credentialJSON
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var passkey Passkey
+	if err := db.Where("credential_id = ?", strings.TrimRight(input.ID, "=")).First(&passkey).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown passkey"})
+		return
+	}
+
+	clientDataJSON, err1 := decodeB64URL(input.Response.ClientDataJSON)
+	rawAuthData, err2 := decodeB64URL(input.Response.AuthenticatorData)
+	signature, err3 := decodeB64URL(input.Response.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		webauthnError(c, errors.New("invalid encoding"))
+		return
+	}
+
+	challenge, err := checkClientData(clientDataJSON, "webauthn.get", "login")
+	if err != nil {
+		webauthnError(c, err)
+		return
+	}
+	if challenge.Username != "" && challenge.Username != passkey.Username {
+		webauthnError(c, errors.New("passkey belongs to another user"))
+		return
+	}
+	authData, err := parseAuthData(rawAuthData)
+	if err != nil {
+		webauthnError(c, err)
+		return
+	}
+	if err := verifyAssertion(passkey.PublicKey, rawAuthData, clientDataJSON, signature); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed: " + err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.Where("username = ?", passkey.Username).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown passkey"})
+		return
+	}
+	if input.Response.UserHandle != "" {
+		if handle, err := decodeB64URL(input.Response.UserHandle); err != nil || !bytes.Equal(handle, userHandle(user)) {
+			webauthnError(c, errors.New("user handle does not match"))
+			return
+		}
+	}
+
+	// A counter that does not move forward means the credential may have been cloned.
+	// Authenticators that do not count always send zero.
+	if (authData.signCount != 0 || passkey.SignCount != 0) && authData.signCount <= passkey.SignCount {
+		log.Printf("Passkey %d of %s sent sign count %d after %d, possible clone", passkey.ID, passkey.Username, authData.signCount, passkey.SignCount)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed: signature counter went backwards"})
+		return
+	}
+	now := time.Now()
+	db.Model(&passkey).Updates(map[string]interface{}{"sign_count": authData.signCount, "last_used_at": now})
+
+	loginResponse(c, user)
+}
+
+// GetPasskeys lists the user's passkeys
+func GetPasskeys(c *gin.Context) {
+	passkeys := []Passkey{}
+	db.Where("username = ?", c.GetString("username")).Order("created_at desc").Find(&passkeys)
+	c.JSON(http.StatusOK, passkeys)
+}
+
+// DeletePasskey removes one of the user's passkeys
+func DeletePasskey(c *gin.Context) {
+	result := db.Where("id = ? AND username = ?", c.Param("id"), c.GetString("username")).Delete(&Passkey{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
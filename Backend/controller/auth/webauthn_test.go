@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testOrigin = "https://diary.example"
+
+// cborRaw is already encoded CBOR, written as is by cborEncode
+type cborRaw []byte
+
+func cborHeader(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+}
+
+// cborEncode writes the few CBOR types WebAuthn needs; maps are given as
+// key, value pairs so their order is fixed
+func cborEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHeader(1, uint64(-1-v))
+		}
+		return cborHeader(0, uint64(v))
+	case []byte:
+		return append(cborHeader(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHeader(3, uint64(len(v))), v...)
+	case cborRaw:
+		return v
+	}
+	panic("unsupported CBOR value")
+}
+
+func cborMap(pairs ...interface{}) cborRaw {
+	out := cborHeader(5, uint64(len(pairs)/2))
+	for _, p := range pairs {
+		out = append(out, cborEncode(p)...)
+	}
+	return out
+}
+
+// softAuthenticator is a platform authenticator with one ES256 credential
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	origin       string
+	rpID         string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id, origin: testOrigin, rpID: "diary.example"}
+}
+
+func (a *softAuthenticator) coseKey() cborRaw {
+	x := a.key.X.FillBytes(make([]byte, 32))
+	y := a.key.Y.FillBytes(make([]byte, 32))
+	return cborMap(1, 2, 3, coseES256, -1, 1, -2, x, -3, y)
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	return data
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *softAuthenticator) create(challenge string) credentialJSON {
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), a.coseKey()...)
+	object := cborMap("fmt", "none", "attStmt", cborMap(), "authData", a.authData(flagUserPresent|flagUserVerified|flagAttestedData, attested))
+
+	var cred credentialJSON
+	cred.ID = b64url(a.credentialID)
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64url(a.clientData("webauthn.create", challenge))
+	cred.Response.AttestationObject = b64url(object)
+	return cred
+}
+
+// get answers navigator.credentials.get(), counting the signature
+func (a *softAuthenticator) get(t *testing.T, challenge string, handle []byte) credentialJSON {
+	a.signCount++
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+	clientHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cred credentialJSON
+	cred.ID = b64url(a.credentialID)
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = b64url(clientDataJSON)
+	cred.Response.AuthenticatorData = b64url(authData)
+	cred.Response.Signature = b64url(signature)
+	cred.Response.UserHandle = b64url(handle)
+	return cred
+}
+
+type passkeyOptions struct {
+	PublicKey struct {
+		Challenge        string `json:"challenge"`
+		AllowCredentials []struct {
+			ID string `json:"id"`
+		} `json:"allowCredentials"`
+	} `json:"publicKey"`
+}
+
+func setupWebAuthnTest(t *testing.T) (User, *gin.Engine, *gin.Engine) {
+	setupAuthTestDB(t)
+	rpID, rpName, rpOrigins = "diary.example", "Test", []string{testOrigin}
+
+	user := User{Username: "alice"}
+	db.Create(&user)
+
+	// A session that just logged in, so an account without a password may add a passkey
+	session := Session{SID: randomToken(16), Username: user.Username, CreatedAt: time.Now(), LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(refreshTTL)}
+	db.Create(&session)
+
+	protected := testRouter(user.Username)
+	protected.Use(func(c *gin.Context) {
+		c.Set("sid", session.SID)
+	})
+	protected.POST("/passkeys/register/begin", BeginPasskeyRegistration)
+	protected.POST("/passkeys/register/finish", FinishPasskeyRegistration)
+	public := testRouter("")
+	public.POST("/login/passkey/begin", BeginPasskeyLogin)
+	public.POST("/login/passkey/finish", FinishPasskeyLogin)
+	return user, protected, public
+}
+
+func registerPasskey(t *testing.T, r *gin.Engine, a *softAuthenticator) *http.Response {
+	t.Helper()
+	var options passkeyOptions
+	decodeBody(t, doJSON(r, http.MethodPost, "/passkeys/register/begin", gin.H{}), &options)
+	w := doJSON(r, http.MethodPost, "/passkeys/register/finish", gin.H{"name": "test", "credential": a.create(options.PublicKey.Challenge)})
+	return w.Result()
+}
+
+func beginPasskeyLogin(t *testing.T, r *gin.Engine, username string) passkeyOptions {
+	t.Helper()
+	var options passkeyOptions
+	decodeBody(t, doJSON(r, http.MethodPost, "/login/passkey/begin", gin.H{"username": username}), &options)
+	return options
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	user, protected, public := setupWebAuthnTest(t)
+	a := newSoftAuthenticator(t)
+
+	if res := registerPasskey(t, protected, a); res.StatusCode != http.StatusCreated {
+		t.Fatalf("register: status = %d", res.StatusCode)
+	}
+	var passkey Passkey
+	if err := db.Where("username = ?", user.Username).First(&passkey).Error; err != nil || passkey.Algorithm != coseES256 {
+		t.Fatalf("stored passkey = %+v, %v", passkey, err)
+	}
+
+	// The typed name is resolved like a password login
+	options := beginPasskeyLogin(t, public, "  Alice ")
+	if len(options.PublicKey.AllowCredentials) != 1 || options.PublicKey.AllowCredentials[0].ID != b64url(a.credentialID) {
+		t.Fatalf("allowCredentials = %+v", options.PublicKey.AllowCredentials)
+	}
+
+	w := doJSON(public, http.MethodPost, "/login/passkey/finish", a.get(t, options.PublicKey.Challenge, userHandle(user)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+	}
+	decodeBody(t, w, &resp)
+	if resp.Token == "" || resp.Username != user.Username {
+		t.Errorf("login response = %+v", resp)
+	}
+
+	db.First(&passkey, passkey.ID)
+	if passkey.SignCount != a.signCount || passkey.LastUsedAt == nil {
+		t.Errorf("after login: sign count = %d, last used = %v", passkey.SignCount, passkey.LastUsedAt)
+	}
+
+	// A challenge answers one ceremony only
+	w = doJSON(public, http.MethodPost, "/login/passkey/finish", a.get(t, options.PublicKey.Challenge, userHandle(user)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("replayed challenge: status = %d", w.Code)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	user, protected, public := setupWebAuthnTest(t)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, protected, a)
+
+	a.signCount = 9
+	options := beginPasskeyLogin(t, public, user.Username)
+	if w := doJSON(public, http.MethodPost, "/login/passkey/finish", a.get(t, options.PublicKey.Challenge, userHandle(user))); w.Code != http.StatusOK {
+		t.Fatalf("first login: status = %d %s", w.Code, w.Body)
+	}
+
+	// A clone of the authenticator would still be on an older count
+	a.signCount = 5
+	options = beginPasskeyLogin(t, public, user.Username)
+	w := doJSON(public, http.MethodPost, "/login/passkey/finish", a.get(t, options.PublicKey.Challenge, userHandle(user)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("regressed count: status = %d %s", w.Code, w.Body)
+	}
+	var passkey Passkey
+	db.Where("username = ?", user.Username).First(&passkey)
+	if passkey.SignCount != 10 {
+		t.Errorf("stored sign count = %d, want 10", passkey.SignCount)
+	}
+}
+
+func TestPasskeyCeremoniesRejectWrongOrigin(t *testing.T) {
+	user, protected, public := setupWebAuthnTest(t)
+	a := newSoftAuthenticator(t)
+
+	a.origin = "https://diary.example.evil.com"
+	if res := registerPasskey(t, protected, a); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("register from another origin: status = %d", res.StatusCode)
+	}
+
+	a.origin = testOrigin
+	if res := registerPasskey(t, protected, a); res.StatusCode != http.StatusCreated {
+		t.Fatalf("register: status = %d", res.StatusCode)
+	}
+
+	var before, after int64
+	db.Model(&Session{}).Count(&before)
+	a.origin = "http://diary.example"
+	options := beginPasskeyLogin(t, public, user.Username)
+	w := doJSON(public, http.MethodPost, "/login/passkey/finish", a.get(t, options.PublicKey.Challenge, userHandle(user)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("login from another origin: status = %d %s", w.Code, w.Body)
+	}
+	db.Model(&Session{}).Count(&after)
+	if after != before {
+		t.Errorf("%d sessions started", after-before)
+	}
+}
+
+func TestPasskeyRegistrationNeedsReauthentication(t *testing.T) {
+	user, protected, _ := setupWebAuthnTest(t)
+	a := newSoftAuthenticator(t)
+	begin := func(r *gin.Engine, body gin.H) int {
+		return doJSON(r, http.MethodPost, "/passkeys/register/begin", body).Code
+	}
+
+	// Without a password, the session must be recent
+	stale := passwordRouter(user.Username, time.Now().Add(-time.Hour))
+	stale.POST("/passkeys/register/begin", BeginPasskeyRegistration)
+	if code := begin(stale, gin.H{}); code != http.StatusUnauthorized {
+		t.Errorf("old session: status = %d", code)
+	}
+
+	// With a password, it must be given
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testNewPassword), bcrypt.MinCost)
+	db.Model(&user).Update("password", string(hash))
+	if code := begin(protected, gin.H{}); code != http.StatusUnauthorized {
+		t.Errorf("no password: status = %d", code)
+	}
+	if code := begin(protected, gin.H{"password": "wrong"}); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d", code)
+	}
+
+	// With 2FA on, a code is needed too
+	key := []byte(strings.Repeat("s", 20))
+	db.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_secret": b32.EncodeToString(key)})
+	if code := begin(protected, gin.H{"password": testNewPassword}); code != http.StatusUnauthorized {
+		t.Errorf("no code: status = %d", code)
+	}
+	db.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+
+	var options passkeyOptions
+	w := doJSON(protected, http.MethodPost, "/passkeys/register/begin", gin.H{"password": testNewPassword, "code": currentCode(key)})
+	decodeBody(t, w, &options)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin: status = %d %s", w.Code, w.Body)
+	}
+	w = doJSON(protected, http.MethodPost, "/passkeys/register/finish", gin.H{"name": "test", "credential": a.create(options.PublicKey.Challenge)})
+	if w.Code != http.StatusCreated {
+		t.Errorf("finish: status = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"dt-backend/controller/auth"
+	"dt-backend/mailer"
+)
+
+// DigestSchedule is when and how a user wants to receive digests
+type DigestSchedule struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"uniqueIndex"`
+	Enabled      bool      `json:"enabled"`
+	Weekday      int       `json:"weekday"` // 0 = Sunday, for weekly digests
+	Hour         int       `json:"hour"`
+	Minute       int       `json:"minute"`
+	Timezone     string    `json:"timezone"`
+	Weekly       bool      `json:"weekly"`
+	Monthly      bool      `json:"monthly"` // Sent on the 1st for the previous month
+	Yearly       bool      `json:"yearly"`  // Sent on January 1st for the previous year
+	DeliverInApp bool      `json:"deliverInApp"`
+	DeliverEmail bool      `json:"deliverEmail"` // Sent to the account's verified email
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Digest is a stored weekly, monthly or yearly summary
+type Digest struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Username    string         `json:"username" gorm:"uniqueIndex:idx_digest_period"`
+	Period      string         `json:"period" gorm:"uniqueIndex:idx_digest_period"` // weekly, monthly, yearly
+	PeriodStart time.Time      `json:"periodStart" gorm:"uniqueIndex:idx_digest_period"`
+	PeriodEnd   time.Time      `json:"periodEnd"`
+	EntryCount  int            `json:"entryCount"`
+	Moods       map[string]int `json:"moods" gorm:"serializer:json"`
+	Statuses    map[string]int `json:"statuses" gorm:"serializer:json"`
+	Text        string         `json:"digest"`
+	DeliveredAt *time.Time     `json:"deliveredAt"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+// Notification is an in-app message shown to the user
+type Notification struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	Username  string     `json:"username" gorm:"index"`
+	Kind      string     `json:"kind"` // digest, reminder, ...
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	Link      string     `json:"link"`
+	ReadAt    *time.Time `json:"readAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+var mailSender mailer.Sender
+
+var periodLabels = map[string]string{
+	"weekly":  "ประจำสัปดาห์",
+	"monthly": "ประจำเดือน",
+	"yearly":  "ประจำปี",
+}
+
+// digestFallback is used when the AI cannot write the digest
+const digestFallback = "ยังสรุปด้วย AI ไม่ได้ในตอนนี้ แต่ทุกบันทึกที่คุณเขียนคือการดูแลใจตัวเองนะ 💛"
+
+// buildDigest collects the entries of a period (with reflections) and asks the AI to summarize them
+func buildDigest(username, period string, start, end time.Time) (Digest, []DiaryEntry) {
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).Where("username = ? AND is_draft = ? AND created_at >= ? AND created_at < ?", username, false, start, end).Find(&entries)
+
+	digest := Digest{
+		Username:    username,
+		Period:      period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		EntryCount:  len(entries),
+		Moods:       map[string]int{},
+		Statuses:    map[string]int{},
+	}
+	if len(entries) == 0 {
+		digest.Text = "ช่วงนี้ยังไม่มีบันทึก ลองเขียนอะไรสักอย่างสิ!"
+		return digest, entries
+	}
+
+	var content strings.Builder
+	for _, e := range entries {
+		content.WriteString(e.Title + ": " + e.Content[:min(100, len(e.Content))])
+		if e.MoodDetail.Intensity > 0 {
+			content.WriteString(fmt.Sprintf(" (อารมณ์: %s ระดับ %d/10)", moodTaxonomy[e.MoodDetail.Primary].Label, e.MoodDetail.Intensity))
+		}
+		content.WriteString("\n")
+		if e.Mood != "" {
+			digest.Moods[e.Mood]++
+		}
+		if e.Status != "" {
+			digest.Statuses[e.Status]++
+		}
+	}
+
+	prompt := fmt.Sprintf(`สรุปสุขภาพจิต%s จากบันทึก %d รายการ:
+%s
+
+เขียนสรุปสั้นๆ 2-3 ประโยค เป็นภาษาไทย บอกแนวโน้มอารมณ์และคำแนะนำ`, periodLabels[period], len(entries), content.String())
+
+	text, err := generateContent(context.Background(), prompt)
+	if err != nil {
+		log.Printf("Failed to generate %s digest for %s: %v", period, username, err)
+		text = digestFallback
+	}
+	digest.Text = text
+	return digest, entries
+}
+
+// duePeriods returns the periods (with their start and end) a schedule should produce at now
+func duePeriods(s DigestSchedule, now time.Time) map[string][2]time.Time {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil || s.Timezone == "" {
+		loc = appLocation()
+	}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	sendAt := today.Add(time.Duration(s.Hour)*time.Hour + time.Duration(s.Minute)*time.Minute)
+	if local.Before(sendAt) {
+		return nil
+	}
+
+	due := make(map[string][2]time.Time)
+	if s.Weekly && int(local.Weekday()) == s.Weekday {
+		due["weekly"] = [2]time.Time{today.AddDate(0, 0, -7), today}
+	}
+	if s.Monthly && local.Day() == 1 {
+		due["monthly"] = [2]time.Time{today.AddDate(0, -1, 0), today}
+	}
+	if s.Yearly && local.Month() == time.January && local.Day() == 1 {
+		due["yearly"] = [2]time.Time{today.AddDate(-1, 0, 0), today}
+	}
+	return due
+}
+
+// deliverDigest sends a stored digest to the channels the user picked
+func deliverDigest(s DigestSchedule, d *Digest) {
+	title := "สรุปสุขภาพจิต" + periodLabels[d.Period]
+	delivered := false
+
+	if s.DeliverInApp {
+		DB.Create(&Notification{
+			Username:  d.Username,
+			Kind:      "digest",
+			Title:     title,
+			Body:      d.Text,
+			Link:      fmt.Sprintf("/digests/%d", d.ID),
+			CreatedAt: time.Now(),
+		})
+		delivered = true
+	}
+
+	// Looked up at send time, so changing or unverifying the address takes effect immediately
+	if email, verified := auth.VerifiedEmail(s.Username); s.DeliverEmail && verified {
+		body := fmt.Sprintf("%s\n%s - %s\n\n%s\n\nบันทึกทั้งหมด: %d รายการ",
+			title, d.PeriodStart.Format("02/01/2006"), d.PeriodEnd.AddDate(0, 0, -1).Format("02/01/2006"), d.Text, d.EntryCount)
+		if err := mailSender.Send(email, title, body); err != nil {
+			log.Printf("Failed to email digest %d: %v", d.ID, err)
+		} else {
+			delivered = true
+		}
+	}
+
+	if delivered {
+		now := time.Now()
+		d.DeliveredAt = &now
+		DB.Model(d).Update("delivered_at", now)
+	}
+}
+
+// runDigestSchedules generates and delivers every digest that is due
+func runDigestSchedules(now time.Time) {
+	var schedules []DigestSchedule
+	DB.Where("enabled = ?", true).Find(&schedules)
+
+	for _, s := range schedules {
+		for period, span := range duePeriods(s, now) {
+			var count int64
+			DB.Model(&Digest{}).Where("username = ? AND period = ? AND period_start = ?", s.Username, period, span[0]).Count(&count)
+			if count > 0 {
+				continue
+			}
+
+			digest, _ := buildDigest(s.Username, period, span[0], span[1])
+			digest.CreatedAt = now
+			if err := DB.Create(&digest).Error; err != nil {
+				log.Printf("Failed to save %s digest for %s: %v", period, s.Username, err)
+				continue
+			}
+			deliverDigest(s, &digest)
+		}
+	}
+}
+
+// startDigestScheduler checks for due digests and writing reminders every minute until ctx is done
+func startDigestScheduler(ctx context.Context) {
+	mailSender = mailer.FromEnv()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runDigestSchedules(now)
+				runWritingReminders(now)
+			}
+		}
+	}()
+}
+
+// --- Handlers ---
+
+// GetDigestSchedule returns the user's schedule (disabled defaults if none is saved)
+func GetDigestSchedule(c *gin.Context) {
+	username := c.GetString("username")
+
+	schedule := DigestSchedule{Username: username, Weekday: 0, Hour: 20, Timezone: appLocation().String(), Weekly: true, DeliverInApp: true}
+	DB.Where("username = ?", username).First(&schedule)
+	c.JSON(http.StatusOK, schedule)
+}
+
+// SaveDigestSchedule creates or updates the user's schedule
+func SaveDigestSchedule(c *gin.Context) {
+	username := c.GetString("username")
+
+	var input DigestSchedule
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Weekday < 0 || input.Weekday > 6 || input.Hour < 0 || input.Hour > 23 || input.Minute < 0 || input.Minute > 59 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid weekday or time"})
+		return
+	}
+	if input.Timezone == "" {
+		input.Timezone = appLocation().String()
+	}
+	if _, err := time.LoadLocation(input.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+		return
+	}
+	if _, verified := auth.VerifiedEmail(username); input.DeliverEmail && !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verify your email in your profile before enabling email delivery"})
+		return
+	}
+
+	var schedule DigestSchedule
+	DB.Where("username = ?", username).First(&schedule)
+	input.ID = schedule.ID
+	input.Username = username
+	if err := DB.Save(&input).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, input)
+}
+
+// GetDigests lists stored digests, newest first (?period= filters)
+func GetDigests(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	username := c.GetString("username")
+	query := DB.Where("username = ?", username)
+	if period := c.Query("period"); period != "" {
+		query = query.Where("period = ?", period)
+	}
+
+	var digests []Digest
+	if err := paginate(query, cursor, limit, true).Find(&digests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	n, next := nextPage(c, len(digests), limit, func(i int) (time.Time, uint) {
+		return digests[i].CreatedAt, digests[i].ID
+	})
+	digests = digests[:n]
+
+	c.JSON(http.StatusOK, pageBody(c, digests, next, limit))
+}
+
+// GetDigest returns one stored digest
+func GetDigest(c *gin.Context) {
+	var digest Digest
+	if err := DB.Where("id = ? AND username = ?", c.Param("id"), c.GetString("username")).First(&digest).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Digest not found"})
+		return
+	}
+	c.JSON(http.StatusOK, digest)
+}
+
+// GetNotifications lists in-app notifications, newest first
+func GetNotifications(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	query := DB.Where("username = ?", c.GetString("username"))
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []Notification
+	if err := paginate(query, cursor, limit, true).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	n, next := nextPage(c, len(notifications), limit, func(i int) (time.Time, uint) {
+		return notifications[i].CreatedAt, notifications[i].ID
+	})
+	notifications = notifications[:n]
+
+	c.JSON(http.StatusOK, pageBody(c, notifications, next, limit))
+}
+
+// MarkNotificationRead marks one notification as read
+func MarkNotificationRead(c *gin.Context) {
+	result := DB.Model(&Notification{}).
+		Where("id = ? AND username = ? AND read_at IS NULL", c.Param("id"), c.GetString("username")).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
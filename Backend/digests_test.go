@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWeeklyDigestIsStoredPerPeriod(t *testing.T) {
+	setupTestDB(t)
+	r := testRouter("alice")
+	r.GET("/ai/weekly-digest", GetWeeklyDigest)
+
+	DB.Create(&DiaryEntry{Username: "alice", Title: "งาน", Content: "เหนื่อย", Mood: "😢", CreatedAt: time.Now().AddDate(0, 0, -2)})
+
+	var first struct {
+		Digest     string `json:"digest"`
+		HasData    bool   `json:"hasData"`
+		EntryCount int    `json:"entryCount"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/ai/weekly-digest", nil), &first)
+	if !first.HasData || first.EntryCount != 1 || first.Digest == "" {
+		t.Fatalf("first digest = %+v", first)
+	}
+
+	var stored []Digest
+	DB.Find(&stored)
+	if len(stored) != 1 || stored[0].Period != "weekly" {
+		t.Fatalf("stored digests = %+v", stored)
+	}
+
+	// The second request serves the stored text instead of asking the AI again
+	DB.Model(&stored[0]).Update("text", "สรุปที่เก็บไว้")
+	var second struct {
+		Digest string `json:"digest"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/ai/weekly-digest", nil), &second)
+	if second.Digest != "สรุปที่เก็บไว้" {
+		t.Errorf("second digest = %q", second.Digest)
+	}
+	var count int64
+	DB.Model(&Digest{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d digests stored", count)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genai"
+	"gorm.io/gorm/clause"
+
+	"dt-backend/controller/auth"
+)
+
+// Embedder turns text into a vector so entries can be compared by meaning
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// EntryEmbedding stores the vector of an entry or one of its reflections
+type EntryEmbedding struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"index"`
+	DiaryEntryID uint      `json:"diaryEntryId" gorm:"index;uniqueIndex:idx_embedding_target"`
+	ReflectionID uint      `json:"reflectionId" gorm:"uniqueIndex:idx_embedding_target"` // 0 = the entry itself
+	Model        string    `json:"model" gorm:"uniqueIndex:idx_embedding_target"`
+	Vector       []byte    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// VectorIndex stores embeddings and finds the nearest ones for a user
+type VectorIndex interface {
+	Upsert(rec EntryEmbedding) error
+	Search(username, model string, vector []float32, limit int, exclude uint) ([]VectorMatch, error)
+}
+
+// VectorMatch is a single search hit, one per diary entry
+type VectorMatch struct {
+	DiaryEntryID uint
+	ReflectionID uint
+	Score        float64
+}
+
+var embedder Embedder
+var vectorIndex VectorIndex
+
+// initEmbeddings picks the embedder from EMBEDDER (local or gemini)
+func initEmbeddings() {
+	switch strings.ToLower(os.Getenv("EMBEDDER")) {
+	case "gemini":
+		embedder = &geminiEmbedder{model: "text-embedding-004"}
+	default:
+		embedder = &localEmbedder{dims: 512}
+	}
+	vectorIndex = &sqliteVectorIndex{}
+	log.Printf("Embedder: %s", embedder.Name())
+}
+
+// --- Gemini embedder ---
+type geminiEmbedder struct {
+	model string
+}
+
+func (g *geminiEmbedder) Name() string { return "gemini/" + g.model }
+
+func (g *geminiEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var lastErr error
+
+	for _, key := range geminiKeys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		client, err := genai.NewClient(ctx, &genai.ClientConfig{
+			APIKey:  key,
+			Backend: genai.BackendGeminiAPI,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		result, err := client.Models.EmbedContent(ctx, g.model, genai.Text(text), nil)
+		if err != nil {
+			lastErr = err
+			log.Printf("Gemini embed error with key ...%s: %v", key[len(key)-4:], err)
+			continue
+		}
+		if len(result.Embeddings) == 0 {
+			lastErr = fmt.Errorf("empty embedding response")
+			continue
+		}
+
+		return normalize(result.Embeddings[0].Values), nil
+	}
+
+	return nil, fmt.Errorf("all API keys failed. Last error: %v", lastErr)
+}
+
+// --- Local offline embedder ---
+// Hashes character trigrams into a fixed-size vector. Thai has no spaces
+// between words, so character n-grams work better than splitting on words.
+type localEmbedder struct {
+	dims int
+}
+
+func (l *localEmbedder) Name() string { return fmt.Sprintf("local/trigram-%d", l.dims) }
+
+func (l *localEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	vec := make([]float32, l.dims)
+
+	var runes []rune
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) {
+			runes = append(runes, r)
+		} else if len(runes) > 0 && runes[len(runes)-1] != ' ' {
+			runes = append(runes, ' ')
+		}
+	}
+
+	for i := 0; i+3 <= len(runes); i++ {
+		h := fnv.New32a()
+		h.Write([]byte(string(runes[i : i+3])))
+		sum := h.Sum32()
+		sign := float32(1)
+		if sum&1 == 1 {
+			sign = -1
+		}
+		vec[int(sum>>1)%l.dims] += sign
+	}
+
+	return normalize(vec), nil
+}
+
+// --- SQLite-backed vector index (brute-force cosine per user) ---
+type sqliteVectorIndex struct{}
+
+func (s *sqliteVectorIndex) Upsert(rec EntryEmbedding) error {
+	rec.CreatedAt = time.Now()
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "diary_entry_id"}, {Name: "reflection_id"}, {Name: "model"}},
+		DoUpdates: clause.AssignmentColumns([]string{"username", "vector", "created_at"}),
+	}).Create(&rec).Error
+}
+
+// dedupeEmbeddings keeps the newest row per entry, reflection and model so the
+// unique index can be created on databases written before it existed
+func dedupeEmbeddings() {
+	if !DB.Migrator().HasTable(&EntryEmbedding{}) || DB.Migrator().HasIndex(&EntryEmbedding{}, "idx_embedding_target") {
+		return
+	}
+	err := DB.Where("id NOT IN (?)", DB.Model(&EntryEmbedding{}).Select("MAX(id)").
+		Group("diary_entry_id, reflection_id, model")).Delete(&EntryEmbedding{}).Error
+	if err != nil {
+		log.Printf("Failed to remove duplicate embeddings: %v", err)
+	}
+}
+
+func (s *sqliteVectorIndex) Search(username, model string, vector []float32, limit int, exclude uint) ([]VectorMatch, error) {
+	var rows []EntryEmbedding
+	if err := DB.Where("username = ? AND model = ? AND diary_entry_id <> ?", username, model, exclude).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// Keep the best-scoring vector for each entry
+	best := make(map[uint]VectorMatch)
+	for _, row := range rows {
+		score := cosine(vector, decodeVector(row.Vector))
+		if m, ok := best[row.DiaryEntryID]; !ok || score > m.Score {
+			best[row.DiaryEntryID] = VectorMatch{DiaryEntryID: row.DiaryEntryID, ReflectionID: row.ReflectionID, Score: score}
+		}
+	}
+
+	matches := make([]VectorMatch, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// --- Indexing helpers ---
+
+// indexEntry embeds an entry and stores it in the vector index
+func indexEntry(entry DiaryEntry) {
+	indexText(entry.Username, entry.ID, 0, entry.Title+"\n"+entry.Content)
+}
+
+// indexReflection embeds a reflection so later searches can match it too
+func indexReflection(username string, h ReflectionHistory) {
+	if strings.TrimSpace(h.Content) == "" {
+		return
+	}
+	indexText(username, h.DiaryEntryID, h.ID, h.Content)
+}
+
+func indexText(username string, entryID, reflectionID uint, text string) []float32 {
+	if !auth.UserExists(username) {
+		return nil
+	}
+	vec, err := embedder.Embed(context.Background(), text)
+	if err != nil {
+		log.Printf("Failed to embed entry %d: %v", entryID, err)
+		return nil
+	}
+	rec := EntryEmbedding{
+		Username:     username,
+		DiaryEntryID: entryID,
+		ReflectionID: reflectionID,
+		Model:        embedder.Name(),
+		Vector:       encodeVector(vec),
+	}
+	if err := vectorIndex.Upsert(rec); err != nil {
+		log.Printf("Failed to index entry %d: %v", entryID, err)
+	} else if entryGone(username, entryID) {
+		DB.Where("diary_entry_id = ? AND username = ?", entryID, username).Delete(&EntryEmbedding{})
+	}
+	return vec
+}
+
+// entryGone reports whether an entry was deleted, on its own or with its account,
+// while background work on it was running. Writers check it after writing and undo
+// the write: whichever of the write and the delete lands second sees the other.
+func entryGone(username string, entryID uint) bool {
+	var count int64
+	DB.Model(&DiaryEntry{}).Where("id = ? AND username = ?", entryID, username).Count(&count)
+	return count == 0
+}
+
+// backfillEmbeddings indexes entries written before the embedder was enabled
+// (or before it was switched to another model)
+func backfillEmbeddings(username string) {
+	var entries []DiaryEntry
+	// Drafts are indexed once they are confirmed
+	DB.Where("username = ? AND is_draft = ? AND id NOT IN (?)", username, false,
+		DB.Model(&EntryEmbedding{}).Select("diary_entry_id").Where("model = ? AND reflection_id = 0", embedder.Name())).
+		Limit(50).Find(&entries)
+	for _, e := range entries {
+		indexEntry(e)
+	}
+}
+
+// background tracks the indexing work handlers start after responding, so it
+// can be waited for (tests swap the database between runs)
+var background sync.WaitGroup
+
+func goBackground(f func()) {
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		f()
+	}()
+}
+
+// One background backfill per user at a time
+var backfilling sync.Map
+
+// scheduleBackfill starts backfillEmbeddings in the background so searches never
+// wait on embedding calls for old entries
+func scheduleBackfill(username string) {
+	if _, running := backfilling.LoadOrStore(username, true); running {
+		return
+	}
+	goBackground(func() {
+		defer backfilling.Delete(username)
+		backfillEmbeddings(username)
+	})
+}
+
+// storedVector returns the embedding of the entry itself if it has been indexed
+func storedVector(entry DiaryEntry) ([]float32, bool) {
+	var rec EntryEmbedding
+	err := DB.Where("diary_entry_id = ? AND reflection_id = 0 AND model = ?", entry.ID, embedder.Name()).First(&rec).Error
+	if err != nil {
+		return nil, false
+	}
+	return decodeVector(rec.Vector), true
+}
+
+// findSimilarEntries returns the user's past entries closest to vec. Only entries
+// that are already indexed are searched; missing ones are indexed in the background.
+// With unlockedOnly, entries still inside their lock are left out: results shown to
+// the user must not hint at what a locked entry is about through its ranking.
+func findSimilarEntries(username string, vec []float32, exclude uint, limit int, unlockedOnly bool) ([]DiaryEntry, []float64, error) {
+	scheduleBackfill(username)
+
+	now := time.Now()
+	searchLimit := limit
+	if unlockedOnly {
+		// Ask for enough matches that skipping every locked entry still fills the limit
+		var locked int64
+		DB.Model(&DiaryEntry{}).Where("username = ? AND unlock_at > ?", username, now).Count(&locked)
+		searchLimit += int(locked)
+	}
+	matches, err := vectorIndex.Search(username, embedder.Name(), vec, searchLimit, exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []DiaryEntry
+	var scores []float64
+	for _, m := range matches {
+		var entry DiaryEntry
+		if err := DB.Where("id = ? AND username = ?", m.DiaryEntryID, username).First(&entry).Error; err != nil {
+			continue
+		}
+		if unlockedOnly && now.Before(entry.UnlockAt) {
+			continue
+		}
+		entries = append(entries, entry)
+		scores = append(scores, m.Score)
+		if len(entries) == limit {
+			break
+		}
+	}
+	return entries, scores, nil
+}
+
+// findSimilarToEntry searches with the entry's stored embedding and makes no
+// embedding calls. An entry that is not indexed yet has no matches.
+func findSimilarToEntry(entry DiaryEntry, limit int) ([]DiaryEntry, []float64, error) {
+	vec, ok := storedVector(entry)
+	if !ok {
+		scheduleBackfill(entry.Username)
+		return nil, nil, nil
+	}
+	return findSimilarEntries(entry.Username, vec, entry.ID, limit, false)
+}
+
+// pastGrowthContext collects growth summaries of resolved entries similar to this one
+func pastGrowthContext(entry DiaryEntry) string {
+	similar, scores, err := findSimilarToEntry(entry, 5)
+	if err != nil {
+		log.Printf("Similar entry lookup failed: %v", err)
+		return ""
+	}
+
+	var sb strings.Builder
+
+	// The topic this entry was linked to comes first, even if it is not among the closest matches
+	if entry.RelapseOfID != nil {
+		var original DiaryEntry
+		if DB.Where("id = ? AND username = ?", *entry.RelapseOfID, entry.Username).First(&original).Error == nil && original.AIResponse != "" {
+			sb.WriteString(fmt.Sprintf("- เรื่อง \"%s\" (%s): %s\n", original.Title, original.CreatedAt.Format("2006-01-02"), original.AIResponse))
+		}
+	}
+
+	for i, e := range similar {
+		if entry.RelapseOfID != nil && e.ID == *entry.RelapseOfID {
+			continue
+		}
+		if !e.IsFinished || e.Status != "over_it" || e.AIResponse == "" || scores[i] < 0.35 {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("- เรื่อง \"%s\" (%s): %s\n", e.Title, e.CreatedAt.Format("2006-01-02"), e.AIResponse))
+	}
+	return sb.String()
+}
+
+// --- Handlers ---
+
+// GetSimilarEntries returns past entries that feel like the given one
+func GetSimilarEntries(c *gin.Context) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+
+	if time.Now().Before(entry.UnlockAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Entry is locked"})
+		return
+	}
+
+	// Entries written before the embedder was enabled are indexed on first use
+	vec, ok := storedVector(entry)
+	if !ok {
+		if vec = indexText(username, entry.ID, 0, entry.Title+"\n"+entry.Content); vec == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search similar entries"})
+			return
+		}
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	respondWithSimilar(c, username, vec, entry.ID, limit)
+}
+
+// SearchSimilar answers "have I felt this before?" for free text
+func SearchSimilar(c *gin.Context) {
+	username := c.GetString("username")
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	vec, err := embedder.Embed(context.Background(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search similar entries"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	respondWithSimilar(c, username, vec, 0, limit)
+}
+
+func respondWithSimilar(c *gin.Context, username string, vec []float32, exclude uint, limit int) {
+	if limit <= 0 || limit > 20 {
+		limit = 5
+	}
+
+	entries, scores, err := findSimilarEntries(username, vec, exclude, limit, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search similar entries"})
+		return
+	}
+
+	now := time.Now()
+	results := []gin.H{}
+	for i := range entries {
+		applyLockState(&entries[i], now)
+		results = append(results, gin.H{
+			"entry":      entries[i],
+			"similarity": scores[i],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "model": embedder.Name()})
+}
+
+// --- Vector math ---
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(x))
+	}
+	return buf
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return v
+}
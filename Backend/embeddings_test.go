@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLocalEmbedder(t *testing.T) {
+	e := &localEmbedder{dims: 256}
+	ctx := context.Background()
+
+	a, _ := e.Embed(ctx, "เครียดเรื่องงาน หัวหน้าดุอีกแล้ว")
+	again, _ := e.Embed(ctx, "เครียดเรื่องงาน หัวหน้าดุอีกแล้ว")
+	near, _ := e.Embed(ctx, "วันนี้เครียดเรื่องงานมาก หัวหน้าดุ")
+	far, _ := e.Embed(ctx, "ไปเที่ยวทะเลกับครอบครัว สนุกมาก")
+
+	if len(a) != 256 {
+		t.Fatalf("dims = %d", len(a))
+	}
+	var norm float64
+	for _, x := range a {
+		norm += float64(x) * float64(x)
+	}
+	if math.Abs(norm-1) > 1e-4 {
+		t.Errorf("vector is not normalized: |v|² = %f", norm)
+	}
+	if cosine(a, again) < 0.9999 {
+		t.Errorf("same text scores %f", cosine(a, again))
+	}
+	if cosine(a, near) <= cosine(a, far) {
+		t.Errorf("related text scores %f, unrelated %f", cosine(a, near), cosine(a, far))
+	}
+
+	empty, err := e.Embed(ctx, "  ")
+	if err != nil || cosine(a, empty) != 0 {
+		t.Errorf("empty text: %v, score %f", err, cosine(a, empty))
+	}
+}
+
+func TestVectorEncodingRoundTrip(t *testing.T) {
+	v := []float32{0.5, -0.25, 1e-7, 0}
+	got := decodeVector(encodeVector(v))
+	if len(got) != len(v) {
+		t.Fatalf("decoded %d values", len(got))
+	}
+	for i := range v {
+		if got[i] != v[i] {
+			t.Errorf("value %d = %v, want %v", i, got[i], v[i])
+		}
+	}
+}
+
+func TestVectorIndexRanksByCosine(t *testing.T) {
+	setupTestDB(t)
+	model := embedder.Name()
+	rows := []EntryEmbedding{
+		{Username: "alice", DiaryEntryID: 1, Model: model, Vector: encodeVector([]float32{1, 0})},
+		{Username: "alice", DiaryEntryID: 2, Model: model, Vector: encodeVector([]float32{0.6, 0.8})},
+		// A reflection on entry 2 matches better than the entry itself
+		{Username: "alice", DiaryEntryID: 2, ReflectionID: 7, Model: model, Vector: encodeVector([]float32{0.8, 0.6})},
+		{Username: "alice", DiaryEntryID: 3, Model: model, Vector: encodeVector([]float32{0, 1})},
+		{Username: "alice", DiaryEntryID: 4, Model: "other", Vector: encodeVector([]float32{1, 0})},
+		{Username: "bob", DiaryEntryID: 5, Model: model, Vector: encodeVector([]float32{1, 0})},
+	}
+	for _, r := range rows {
+		if err := vectorIndex.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matches, err := vectorIndex.Search("alice", model, []float32{1, 0}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VectorMatch{{1, 0, 1}, {2, 7, 0.8}, {3, 0, 0}}
+	if len(matches) != len(want) {
+		t.Fatalf("matches = %+v", matches)
+	}
+	for i, m := range matches {
+		if m.DiaryEntryID != want[i].DiaryEntryID || m.ReflectionID != want[i].ReflectionID || math.Abs(m.Score-want[i].Score) > 1e-6 {
+			t.Errorf("match %d = %+v, want %+v", i, m, want[i])
+		}
+	}
+
+	matches, _ = vectorIndex.Search("alice", model, []float32{1, 0}, 1, 1)
+	if len(matches) != 1 || matches[0].DiaryEntryID != 2 {
+		t.Errorf("limit 1 excluding entry 1: %+v", matches)
+	}
+}
+
+func TestSearchSimilarSkipsLockedEntries(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+	now := time.Now()
+	locked := DiaryEntry{Username: "alice", Title: "งาน", Content: "เครียดเรื่องงาน หัวหน้าดุ", UnlockAt: now.Add(time.Hour)}
+	open := DiaryEntry{Username: "alice", Title: "งาน", Content: "เครียดเรื่องงานนิดหน่อย", UnlockAt: now.Add(-time.Hour)}
+	other := DiaryEntry{Username: "alice", Title: "ทะเล", Content: "ไปเที่ยวทะเล", UnlockAt: now.Add(-time.Hour)}
+	for _, e := range []*DiaryEntry{&locked, &open, &other} {
+		DB.Create(e)
+		indexEntry(*e)
+	}
+
+	r := testRouter("alice")
+	r.GET("/search/similar", SearchSimilar)
+	var resp struct {
+		Results []struct {
+			Entry      DiaryEntry `json:"entry"`
+			Similarity float64    `json:"similarity"`
+		} `json:"results"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/search/similar?limit=2&q="+url.QueryEscape("เครียดเรื่องงาน หัวหน้าดุ"), nil), &resp)
+
+	if len(resp.Results) != 2 || resp.Results[0].Entry.ID != open.ID || resp.Results[1].Entry.ID != other.ID {
+		t.Fatalf("results = %+v", resp.Results)
+	}
+	if resp.Results[0].Similarity <= resp.Results[1].Similarity {
+		t.Errorf("results are not ranked: %+v", resp.Results)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/gin-gonic/gin v1.11.0
 	github.com/joho/godotenv v1.5.1
 	golang.org/x/crypto v0.46.0
+	golang.org/x/image v0.25.0
 	google.golang.org/genai v1.45.0
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.1
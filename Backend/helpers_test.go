@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"dt-backend/controller/auth"
+)
+
+var registerHooks sync.Once
+
+// setupTestDB points DB at a fresh in-memory database, the auth package at a fresh
+// auth.db and the other globals at local implementations, so handlers run without
+// network access
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	var err error
+	DB, err = gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One connection avoids "table is locked" errors from background goroutines
+	sqlDB, _ := DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		background.Wait()
+		sqlDB.Close()
+	})
+	migrateDB()
+
+	t.Setenv("AUTH_DB_PATH", filepath.Join(t.TempDir(), "auth.db"))
+	t.Setenv("APP_URL", "http://localhost:5173")
+	auth.InitAuthDB()
+	registerHooks.Do(registerAccountHooks)
+
+	storage = &LocalStorage{Root: t.TempDir()}
+	embedder = &localEmbedder{dims: 64}
+	vectorIndex = &sqliteVectorIndex{}
+	summaryCache = newMemorySummaryCache(10)
+	scorer = &timeWeightedScorer{halfLife: 14 * 24 * time.Hour}
+}
+
+const testPassword = "Tr1cky-Lantern-42"
+
+// createAccount registers username in the auth database; background work skips
+// users without an account
+func createAccount(t *testing.T, username string) {
+	t.Helper()
+	r := gin.New()
+	r.POST("/register", auth.Register)
+	if w := doJSON(r, http.MethodPost, "/register", gin.H{"username": username, "password": testPassword}); w.Code != http.StatusCreated {
+		t.Fatalf("register %s: status = %d %s", username, w.Code, w.Body)
+	}
+}
+
+// testRouter returns an engine where every request is made as username
+func testRouter(username string) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("username", username)
+	})
+	return r
+}
+
+func doRequest(r http.Handler, method, path, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func doJSON(r http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	if body == nil {
+		return doRequest(r, method, path, "", nil)
+	}
+	data, _ := json.Marshal(body)
+	return doRequest(r, method, path, "application/json", bytes.NewReader(data))
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sender delivers a plain-text email
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// FromEnv returns an SMTP sender when SMTP_HOST is set, otherwise a sender
+// that only logs. For local development point SMTP_HOST at MailHog
+// (localhost, port 1025, no auth).
+func FromEnv() Sender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &LogSender{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "Yesterday's Me <no-reply@localhost>"
+	}
+
+	return &SMTPSender{
+		Addr:     host + ":" + port,
+		Host:     host,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// SMTPSender sends mail through an SMTP server (STARTTLS when the server offers it)
+type SMTPSender struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	msg := buildMessage(s.From, to, subject, body)
+	if err := smtp.SendMail(s.Addr, auth, envelopeAddress(s.From), []string{to}, msg); err != nil {
+		return fmt.Errorf("send mail to %s: %w", to, err)
+	}
+	return nil
+}
+
+// LogSender writes emails to the log instead of sending them
+type LogSender struct{}
+
+func (l *LogSender) Send(to, subject, body string) error {
+	log.Printf("[mail] to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+func buildMessage(from, to, subject, body string) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: " + from + "\r\n")
+	sb.WriteString("To: " + to + "\r\n")
+	sb.WriteString("Subject: " + encodeHeader(subject) + "\r\n")
+	sb.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(sb.String())
+}
+
+// encodeHeader uses RFC 2047 for non-ASCII (Thai) subjects
+func encodeHeader(s string) string {
+	for _, r := range s {
+		if r > 127 {
+			return mime.BEncoding.Encode("UTF-8", s)
+		}
+	}
+	return s
+}
+
+// envelopeAddress extracts "a@b" from "Name <a@b>"
+func envelopeAddress(addr string) string {
+	if i := strings.LastIndex(addr, "<"); i >= 0 {
+		return strings.TrimSuffix(addr[i+1:], ">")
+	}
+	return addr
+}
@@ -8,9 +8,11 @@ import (
 	"math/rand"
 	"net/http"
 	"strings"
+	"syscall"
 	"time"
 
 	"os"
+	"os/signal"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -20,6 +22,7 @@ import (
 	"gorm.io/gorm"
 
 	"dt-backend/controller/auth"
+	"dt-backend/ratelimit"
 )
 
 // Gemini API Key
@@ -41,6 +44,11 @@ func loadAPIKeys() {
 }
 
 func generateContent(ctx context.Context, prompt string) (string, error) {
+	return generateFromContents(ctx, genai.Text(prompt))
+}
+
+// generateFromContents sends multi-part contents (text, audio, ...) with key rotation
+func generateFromContents(ctx context.Context, contents []*genai.Content) (string, error) {
 	var lastErr error
 
 	for _, key := range geminiKeys {
@@ -62,7 +70,7 @@ func generateContent(ctx context.Context, prompt string) (string, error) {
 		result, err := client.Models.GenerateContent(
 			ctx,
 			"gemini-2.0-flash", // Updated to latest model, or use existing
-			genai.Text(prompt),
+			contents,
 			nil,
 		)
 		if err != nil {
@@ -83,7 +91,8 @@ type DiaryEntry struct {
 	Username      string              `json:"username"` // Link to auth user
 	Title         string              `json:"title"`
 	Content       string              `json:"content"`
-	Mood          string              `json:"mood"` // Emoji mood when writing
+	Mood          string              `json:"mood"` // Emoji mood when writing, derived from MoodDetail when set
+	MoodDetail    MoodState           `json:"moodDetail" gorm:"embedded;embeddedPrefix:mood_"`
 	Reflection    string              `json:"reflection"`
 	AIResponse    string              `json:"aiResponse"`
 	Status        string              `json:"status"`
@@ -95,6 +104,10 @@ type DiaryEntry struct {
 	IsPublic      bool                `json:"isPublic"`
 	IsAnonymous   bool                `json:"isAnonymous"`
 	IsFinished    bool                `json:"isFinished"`
+	IsDraft       bool                `json:"isDraft"`    // Voice entry waiting for transcript review
+	Transcript    string              `json:"transcript"` // Original speech-to-text output
+	Tags          []string            `json:"tags" gorm:"serializer:json"`
+	RelapseOfID   *uint               `json:"relapseOfId"` // Finished entry this one brings back
 	Reflections   []ReflectionHistory `json:"reflections" gorm:"foreignKey:DiaryEntryID"`
 }
 
@@ -104,6 +117,7 @@ type ReflectionHistory struct {
 	Content      string    `json:"content"`
 	Status       string    `json:"status"`
 	AIResponse   string    `json:"aiResponse"`
+	MoodDetail   MoodState `json:"moodDetail" gorm:"embedded;embeddedPrefix:mood_"` // Mood when reflecting
 	CreatedAt    time.Time `json:"createdAt"`
 }
 
@@ -119,21 +133,26 @@ type UserPreference struct {
 
 var DB *gorm.DB
 
-// Summary cache
-var cachedSummary gin.H
-var cachedDataHash string
-
 func InitDB() {
 	var err error
 	DB, err = gorm.Open(sqlite.Open("diary.db"), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	DB.AutoMigrate(&DiaryEntry{}, &UserPreference{}, &Comment{}, &ReflectionHistory{})
+	migrateDB()
+}
+
+// migrateDB creates or updates the diary.db tables
+func migrateDB() {
+	dedupeEmbeddings()
+	DB.AutoMigrate(&DiaryEntry{}, &UserPreference{}, &Comment{}, &ReflectionHistory{},
+		&EntryEmbedding{}, &Attachment{}, &ScoreSnapshot{}, &SummarySnapshot{},
+		&DigestSchedule{}, &Digest{}, &Notification{}, &AlertDismissal{},
+		&WritingGoal{})
 }
 
 // --- Gemini API using official SDK ---
-func callGeminiAPI(originalContent, reflection, status string, needHelpCount int) (string, error) {
+func callGeminiAPI(originalContent, reflection, status string, needHelpCount int, pastGrowth string) (string, error) {
 	ctx := context.Background()
 
 	var statusContext string
@@ -153,6 +172,13 @@ func callGeminiAPI(originalContent, reflection, status string, needHelpCount int
 		}
 	}
 
+	// Remind the user how they got through something similar before
+	var pastNote string
+	if pastGrowth != "" {
+		pastNote = "\n\n🌱 เรื่องคล้ายๆ กันที่เขาเคยผ่านมาได้แล้ว (สรุปการเติบโตของเขาเอง):\n" + pastGrowth +
+			"ถ้าเกี่ยวข้อง ให้เตือนความจำอย่างอ่อนโยนว่าเขาเคยผ่านเรื่องแบบนี้มาได้อย่างไร"
+	}
+
 	prompt := fmt.Sprintf(`คุณคือนักจิตวิทยาที่อบอุ่นและเข้าใจ กำลังช่วยผู้ใช้ที่ไตร่ตรองความรู้สึกของตัวเอง
 
 📝 ข้อความที่เขาเขียนไว้เมื่อวาน (ตอนอารมณ์ร้อน):
@@ -161,7 +187,7 @@ func callGeminiAPI(originalContent, reflection, status string, needHelpCount int
 💭 สิ่งที่เขาเขียนไตร่ตรองวันนี้ (ต้องอ่านและตอบเนื้อหานี้โดยเฉพาะ):
 "%s"
 
-📊 สถานะที่เลือก: %s%s
+📊 สถานะที่เลือก: %s%s%s
 
 ⚠️ สำคัญมาก: 
 - ตอบกลับโดยอ้างอิงถึงสิ่งที่เขาเขียนไว้ในส่วน "ไตร่ตรองวันนี้" โดยเฉพาะ
@@ -173,7 +199,7 @@ func callGeminiAPI(originalContent, reflection, status string, needHelpCount int
 - ถ้าข้อความที่เขียนบอกว่ายังรู้สึกไม่ดี/เครียด/กังวล แต่เลือก "เรื่องจิ๊บจ๊อย" ให้ถามเขาอย่างอ่อนโยนว่า "ดูเหมือนยังมีบางอย่างค้างคาอยู่นะ ไม่เป็นไรถ้ายังไม่โอเค"
 - ถ้าข้อความบอกว่าโอเคแล้ว แต่เลือก "ไม่ไหว" ให้ถามว่า "ดูเหมือนคุณแข็งแกร่งขึ้นนะ ต้องการความช่วยเหลือจริงๆ ไหม?"
 
-ตอบกลับ 2-3 ประโยค เป็นภาษาไทย อบอุ่น และเฉพาะเจาะจงกับสิ่งที่เขาเขียน`, originalContent, reflection, statusContext, urgencyNote)
+ตอบกลับ 2-3 ประโยค เป็นภาษาไทย อบอุ่น และเฉพาะเจาะจงกับสิ่งที่เขาเขียน`, originalContent, reflection, statusContext, urgencyNote, pastNote)
 
 	// Call standardized helper
 	return generateContent(ctx, prompt)
@@ -181,40 +207,58 @@ func callGeminiAPI(originalContent, reflection, status string, needHelpCount int
 
 // --- Controllers ---
 func GetEntries(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
 	username := c.GetString("username")
 	var entries []DiaryEntry
-	result := DB.Where("username = ? AND is_public = ?", username, false).Order("created_at desc").Find(&entries)
+	query := DB.Where("username = ? AND is_public = ?", username, false)
+	result := paginate(query, cursor, limit, true).Find(&entries)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	n, next := nextPage(c, len(entries), limit, func(i int) (time.Time, uint) {
+		return entries[i].CreatedAt, entries[i].ID
+	})
+	entries = entries[:n]
 
 	now := time.Now()
 	for i := range entries {
-		if now.Before(entries[i].UnlockAt) {
-			entries[i].IsLocked = true
-			entries[i].Content = ""
-			entries[i].Preview = "Locked content..."
+		applyLockState(&entries[i], now)
+	}
+
+	c.JSON(http.StatusOK, pageBody(c, entries, next, limit))
+}
+
+// applyLockState hides the content of entries that are still locked
+func applyLockState(entry *DiaryEntry, now time.Time) {
+	if now.Before(entry.UnlockAt) {
+		entry.IsLocked = true
+		entry.Content = ""
+		entry.Transcript = ""
+		entry.Preview = "Locked content..."
+	} else {
+		entry.IsLocked = false
+		if len(entry.Content) > 50 {
+			entry.Preview = entry.Content[:50] + "..."
 		} else {
-			entries[i].IsLocked = false
-			if len(entries[i].Content) > 50 {
-				entries[i].Preview = entries[i].Content[:50] + "..."
-			} else {
-				entries[i].Preview = entries[i].Content
-			}
+			entry.Preview = entry.Content
 		}
 	}
-
-	c.JSON(http.StatusOK, entries)
 }
 
 func CreateEntry(c *gin.Context) {
 	var input struct {
-		Title       string `json:"title" binding:"required"`
-		Content     string `json:"content" binding:"required"`
-		Mood        string `json:"mood"`
-		IsPublic    bool   `json:"isPublic"`
-		IsAnonymous bool   `json:"isAnonymous"`
+		Title       string     `json:"title" binding:"required"`
+		Content     string     `json:"content" binding:"required"`
+		Mood        string     `json:"mood"`
+		MoodDetail  *moodInput `json:"moodDetail"`
+		Tags        []string   `json:"tags"`
+		IsPublic    bool       `json:"isPublic"`
+		IsAnonymous bool       `json:"isAnonymous"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -222,6 +266,21 @@ func CreateEntry(c *gin.Context) {
 		return
 	}
 
+	tags, err := normalizeTags(input.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var mood MoodState
+	if input.MoodDetail != nil {
+		if mood, err = input.MoodDetail.toState(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		input.Mood = mood.Emoji()
+	}
+
 	username := c.GetString("username")
 	unlockTime := time.Now().Add(24 * time.Hour)
 	// If it's public, it should be available immediately (no lock)
@@ -234,6 +293,8 @@ func CreateEntry(c *gin.Context) {
 		Title:       input.Title,
 		Content:     input.Content,
 		Mood:        input.Mood,
+		MoodDetail:  mood,
+		Tags:        tags,
 		UnlockAt:    unlockTime,
 		IsPublic:    input.IsPublic,
 		IsAnonymous: input.IsAnonymous,
@@ -245,6 +306,13 @@ func CreateEntry(c *gin.Context) {
 		return
 	}
 
+	// The relapse check searches with the entry's embedding, so index it first
+	goBackground(func() {
+		indexEntry(entry)
+		checkRelapse(entry)
+	})
+	summaryCache.Invalidate(username)
+
 	c.JSON(http.StatusCreated, entry)
 }
 
@@ -252,18 +320,58 @@ func main() {
 	loadAPIKeys()
 	InitDB()
 	auth.InitAuthDB()
+	auth.InitKeys()
+	registerAccountHooks()
+	initEmbeddings()
+	initStorage()
+	initTranscriber()
+	initSummaryCache()
+	initScorer()
+
+	// Schedulers and the server stop on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	startScoreSnapshots(ctx)
+	startDigestScheduler(ctx)
 	fmt.Println("Database initialized.")
 
 	r := gin.Default()
 
+	// Rate limits key on the client IP, so only trust X-Forwarded-For from our own reverse proxy
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		proxies = "127.0.0.1,::1"
+	}
+	if err := r.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
+	// Per-IP limits for the login endpoints; accounts also lock after repeated failures (see auth/lockout.go)
+	loginLimit := ratelimit.Middleware(ratelimit.New(ratelimit.FromEnv("RATE_LIMIT_LOGIN", "10/m")), ratelimit.ByIP)
+	registerLimit := ratelimit.Middleware(ratelimit.New(ratelimit.FromEnv("RATE_LIMIT_REGISTER", "5/h")), ratelimit.ByIP)
+	// Per-user limit for endpoints that call Gemini
+	aiLimit := ratelimit.Middleware(ratelimit.New(ratelimit.FromEnv("RATE_LIMIT_AI", "30/m")), ratelimit.ByUser)
+
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	config.ExposeHeaders = []string{"X-Next-Cursor", "Link"}
 	r.Use(cors.New(config))
 
 	// Public Auth routes
-	r.POST("/register", auth.Register)
-	r.POST("/login", auth.Login)
+	r.POST("/register", registerLimit, auth.Register)
+	r.POST("/login", loginLimit, auth.Login)
+	r.POST("/login/2fa", loginLimit, auth.LoginSecondFactor)
+	r.POST("/login/passkey/begin", loginLimit, auth.BeginPasskeyLogin)
+	r.POST("/login/passkey/finish", loginLimit, auth.FinishPasskeyLogin)
+	r.GET("/oidc/providers", auth.GetOIDCProviders)
+	r.GET("/oidc/:provider/start", loginLimit, auth.StartOIDCLogin)
+	r.POST("/oidc/callback", loginLimit, auth.OIDCCallback)
+	r.POST("/refresh", auth.Refresh)
+	r.POST("/verify-email", loginLimit, auth.VerifyEmail)
+	r.POST("/password/forgot", loginLimit, auth.ForgotPassword)
+	r.POST("/password/reset", loginLimit, auth.ResetPassword)
+	r.GET("/.well-known/jwks.json", auth.JWKS)
 	r.GET("/public/entries", GetPublicEntries)
 	r.GET("/entries/:id/comments", GetComments)
 
@@ -273,22 +381,76 @@ func main() {
 	{
 		protected.GET("/entries", GetEntries)
 		protected.GET("/entries/:id", GetEntry)
-		protected.GET("/summary", GetSummary)
-		protected.GET("/ai/prompts", GetAIPrompts)
-		protected.GET("/ai/weekly-digest", GetWeeklyDigest)
-		protected.GET("/ai/alerts", GetPatternAlerts)
+		protected.GET("/entries/:id/similar", GetSimilarEntries)
+		protected.GET("/entries/:id/relapse", GetRelapse)
+		protected.GET("/search/similar", aiLimit, SearchSimilar)
+		protected.GET("/summary", aiLimit, GetSummary)
+		protected.GET("/summary/snapshots", GetSummarySnapshots)
+		protected.GET("/summary/snapshots/compare", CompareSummarySnapshots)
+		protected.GET("/summary/snapshots/:id", GetSummarySnapshot)
+		protected.GET("/ai/prompts", aiLimit, GetAIPrompts)
+		protected.GET("/ai/weekly-digest", aiLimit, GetWeeklyDigest)
+		protected.GET("/digests", GetDigests)
+		protected.GET("/digests/schedule", GetDigestSchedule)
+		protected.PUT("/digests/schedule", SaveDigestSchedule)
+		protected.GET("/digests/:id", GetDigest)
+		protected.GET("/notifications", GetNotifications)
+		protected.POST("/notifications/:id/read", MarkNotificationRead)
+		protected.GET("/ai/alerts", aiLimit, GetPatternAlerts)
+		protected.POST("/ai/alerts/dismiss", DismissAlert)
+		protected.POST("/ai/alerts/snooze", SnoozeAlert)
+		protected.POST("/ai/alerts/restore", RestoreAlert)
+		protected.GET("/moods/taxonomy", GetMoodTaxonomy)
+		protected.GET("/stats/timeseries", GetTimeseries)
+		protected.GET("/stats/score", GetScore)
+		protected.GET("/stats/score/history", GetScoreHistory)
+		protected.GET("/stats/resolution", GetResolutionStats)
+		protected.GET("/stats/consistency", GetConsistency)
+		protected.GET("/goals/writing", GetWritingGoal)
+		protected.PUT("/goals/writing", SaveWritingGoal)
+		protected.GET("/reports/year/:year", aiLimit, GetYearReview)
 		protected.POST("/entries", CreateEntry)
+		protected.POST("/entries/voice", aiLimit, CreateVoiceEntry)
+		protected.PUT("/entries/:id/transcript", UpdateTranscript)
+		protected.POST("/entries/:id/confirm", ConfirmEntry)
 		protected.POST("/entries/:id/unlock", UnlockEntry)
-		protected.POST("/entries/:id/respond", Respond)
+		protected.POST("/entries/:id/respond", aiLimit, Respond)
 		protected.DELETE("/entries/:id", DeleteEntry)
 
+		// Attachments
+		protected.POST("/entries/:id/attachments", UploadAttachment)
+		protected.GET("/entries/:id/attachments", GetAttachments)
+		protected.GET("/attachments/:id", DownloadAttachment)
+		protected.GET("/attachments/:id/thumbnail", DownloadAttachment)
+		protected.DELETE("/attachments/:id", DeleteAttachment)
+
 		// User Preferences
 		protected.GET("/preferences", GetPreferences)
 		protected.POST("/preferences", SavePreference)
-		protected.GET("/ai/questions", GetAIQuestions)
+		protected.GET("/ai/questions", aiLimit, GetAIQuestions)
 
 		// Profile Routes
 		protected.GET("/profile", auth.GetProfile)
+		protected.PUT("/profile/email", auth.UpdateEmail)
+		protected.POST("/profile/password", auth.ChangePassword)
+		protected.GET("/account/export", auth.ExportAccount)
+		protected.DELETE("/account", auth.DeleteAccount)
+		protected.POST("/logout", auth.Logout)
+		protected.POST("/logout-all", auth.LogoutAll)
+		protected.GET("/sessions", auth.GetSessions)
+		protected.DELETE("/sessions/:id", auth.RevokeSession)
+		protected.GET("/2fa", auth.GetTwoFactorStatus)
+		protected.POST("/2fa/totp/enroll", auth.EnrollTOTP)
+		protected.POST("/2fa/totp/verify", auth.ConfirmTOTP)
+		protected.POST("/2fa/totp/disable", auth.DisableTOTP)
+		protected.POST("/2fa/recovery-codes", auth.RegenerateRecoveryCodes)
+		protected.GET("/passkeys", auth.GetPasskeys)
+		protected.POST("/passkeys/register/begin", auth.BeginPasskeyRegistration)
+		protected.POST("/passkeys/register/finish", auth.FinishPasskeyRegistration)
+		protected.DELETE("/passkeys/:id", auth.DeletePasskey)
+		protected.GET("/identities", auth.GetIdentities)
+		protected.POST("/oidc/:provider/link", auth.StartOIDCLink)
+		protected.DELETE("/identities/:id", auth.UnlinkIdentity)
 		protected.POST("/profile", auth.UpdateProfile)
 
 		// Public Mode Routes
@@ -296,14 +458,25 @@ func main() {
 		protected.POST("/entries/:id/comments", PostComment)
 	}
 
-	r.Run(":8080")
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// Let indexing started by the last requests finish
+	background.Wait()
 }
 
 func GetEntry(c *gin.Context) {
 	id := c.Param("id")
 	username := c.GetString("username")
 	var entry DiaryEntry
-	result := DB.Preload("Reflections").Where("id = ? AND username = ?", id, username).First(&entry)
+	result := DB.Preload("Reflections", orderedReflections).Where("id = ? AND username = ?", id, username).First(&entry)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
 		return
@@ -312,6 +485,7 @@ func GetEntry(c *gin.Context) {
 	if time.Now().Before(entry.UnlockAt) {
 		entry.IsLocked = true
 		entry.Content = ""
+		entry.Transcript = ""
 		entry.Reflection = ""
 	} else {
 		entry.IsLocked = false
@@ -324,8 +498,9 @@ func Respond(c *gin.Context) {
 	id := c.Param("id")
 	username := c.GetString("username")
 	var input struct {
-		Status     string `json:"status" binding:"required"`
-		Reflection string `json:"reflection"`
+		Status     string     `json:"status" binding:"required"`
+		Reflection string     `json:"reflection"`
+		MoodDetail *moodInput `json:"moodDetail"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -333,14 +508,28 @@ func Respond(c *gin.Context) {
 		return
 	}
 
+	var mood MoodState
+	if input.MoodDetail != nil {
+		var err error
+		if mood, err = input.MoodDetail.toState(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Load existing reflections
 	var entry DiaryEntry
-	result := DB.Preload("Reflections").Where("id = ? AND username = ?", id, username).First(&entry)
+	result := DB.Preload("Reflections", orderedReflections).Where("id = ? AND username = ?", id, username).First(&entry)
 	if result.Error != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
 		return
 	}
 
+	if entry.IsDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Confirm the transcript before reflecting"})
+		return
+	}
+
 	// Update NeedHelpCount based on status
 	if input.Status == "need_help" {
 		entry.NeedHelpCount++
@@ -378,7 +567,7 @@ func Respond(c *gin.Context) {
 		entry.UnlockAt = time.Now() // Unlock immediately
 	} else {
 		// Normal Response
-		aiResponse, err = callGeminiAPI(entry.Content, input.Reflection, input.Status, entry.NeedHelpCount)
+		aiResponse, err = callGeminiAPI(entry.Content, input.Reflection, input.Status, entry.NeedHelpCount, pastGrowthContext(entry))
 		if err != nil {
 			log.Printf("Gemini API error: %v", err)
 
@@ -435,9 +624,11 @@ func Respond(c *gin.Context) {
 		Content:      input.Reflection,
 		Status:       input.Status,
 		AIResponse:   aiResponse,
+		MoodDetail:   mood,
 		CreatedAt:    time.Now(),
 	}
 	DB.Create(&newHistory)
+	goBackground(func() { indexReflection(username, newHistory) })
 
 	// Update Main Entry
 	entry.Status = input.Status
@@ -445,7 +636,10 @@ func Respond(c *gin.Context) {
 	entry.AIResponse = aiResponse       // Latest AI response
 
 	DB.Save(&entry)
+	summaryCache.Invalidate(username)
 
+	// Files for the new reflection can be attached with its id for a short while
+	entry.Reflections = append(entry.Reflections, newHistory)
 	c.JSON(http.StatusOK, gin.H{
 		"entry":      entry,
 		"aiResponse": aiResponse,
@@ -465,6 +659,7 @@ func UnlockEntry(c *gin.Context) {
 
 	entry.UnlockAt = time.Now()
 	DB.Save(&entry)
+	summaryCache.Invalidate(username)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Entry unlocked", "id": entry.ID})
 }
@@ -482,27 +677,44 @@ func DeleteEntry(c *gin.Context) {
 	}
 
 	DB.Delete(&entry)
+	DB.Where("diary_entry_id = ?", entry.ID).Delete(&EntryEmbedding{})
+
+	var attachments []Attachment
+	DB.Where("diary_entry_id = ?", entry.ID).Find(&attachments)
+	DB.Where("diary_entry_id = ?", entry.ID).Delete(&Attachment{})
+	deleteAttachmentFiles(attachments)
+	summaryCache.Invalidate(username)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Entry deleted", "id": entry.ID})
 }
 
+// summaryPromptEntries caps how many entries are sent to the AI in one summary prompt
+const summaryPromptEntries = 30
+
 // GetSummary returns mental health statistics and AI analysis
 func GetSummary(c *gin.Context) {
 	val, _ := c.Get("username")
 	username := val.(string)
 
 	var entries []DiaryEntry
-	DB.Where("username = ?", username).Find(&entries)
+	DB.Preload("Reflections", orderedReflections).Where("username = ? AND is_draft = ?", username, false).Order("created_at desc").Find(&entries)
 
-	// Generate hash of current data to detect changes
-	var dataForHash strings.Builder
-	for _, e := range entries {
-		dataForHash.WriteString(fmt.Sprintf("%d:%s:%s:%s:%s:%d|", e.ID, e.Content, e.Reflection, e.Status, e.AIResponse, e.NeedHelpCount))
-	}
-	currentHash := fmt.Sprintf("%x", len(dataForHash.String())) + dataForHash.String()[:min(100, len(dataForHash.String()))]
+	// Digest of current data to detect changes
+	now := time.Now()
+	currentHash := summaryDigest(entries, snapshotDate(now))
 
 	// Return cached result if data hasn't changed
-	if cachedDataHash == currentHash && cachedSummary != nil {
-		c.JSON(http.StatusOK, cachedSummary)
+	if digest, cached, ok := summaryCache.Get(username); ok && digest == currentHash {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
+
+	// The latest stored snapshot is still valid after a restart or cache eviction,
+	// but only on the day it was made since the score changes with time
+	if latest, ok := latestSummarySnapshot(username); ok && latest.Digest == currentHash && snapshotDate(latest.CreatedAt) == snapshotDate(now) {
+		result := latest.response()
+		summaryCache.Set(username, currentHash, result)
+		c.JSON(http.StatusOK, result)
 		return
 	}
 
@@ -519,7 +731,7 @@ func GetSummary(c *gin.Context) {
 	var allAIResponses strings.Builder
 	var allStatuses strings.Builder
 
-	for _, e := range entries {
+	for i, e := range entries {
 		// Count by status
 		switch e.Status {
 		case "over_it":
@@ -540,6 +752,11 @@ func GetSummary(c *gin.Context) {
 			totalNeedHelpStreak = e.NeedHelpCount
 		}
 
+		// Only the most recent entries go into the prompt; counts above cover everything
+		if i >= summaryPromptEntries {
+			continue
+		}
+
 		// Collect all content
 		allContent.WriteString("บันทึก: " + e.Title + "\nเนื้อหา: " + e.Content + "\n\n")
 
@@ -555,19 +772,9 @@ func GetSummary(c *gin.Context) {
 	}
 
 	// Calculate mental state score (0-100, higher = better)
-	var mentalScore int
-	if totalEntries > 0 {
-		resolved := overItCount + stillDealingCount
-		mentalScore = (resolved * 100) / totalEntries
-		if needHelpCount > 0 {
-			mentalScore -= needHelpCount * 10
-		}
-		if mentalScore < 0 {
-			mentalScore = 0
-		}
-	} else {
-		mentalScore = 50 // neutral
-	}
+	scoreResult := scorer.Score(entries, now)
+	mentalScore := scoreResult.Score
+	saveScoreSnapshot(username, scoreResult, now)
 
 	// Determine mental state
 	var mentalState string
@@ -603,7 +810,7 @@ func GetSummary(c *gin.Context) {
 - ยังไม่ได้ไตร่ตรอง: %d รายการ
 - คะแนนสุขภาพจิต: %d/100
 
-📝 เนื้อหาบันทึกทั้งหมด:
+📝 เนื้อหาบันทึกล่าสุด:
 %s
 
 💭 การไตร่ตรองทั้งหมด:
@@ -626,9 +833,11 @@ func GetSummary(c *gin.Context) {
 		}
 	}
 
-	// Build result and cache it
-	result := gin.H{
-		"stats": gin.H{
+	// Build result, keep it as a dated snapshot and cache it
+	snapshot := SummarySnapshot{
+		Username: username,
+		Digest:   currentHash,
+		Stats: map[string]int{
 			"total":          totalEntries,
 			"overIt":         overItCount,
 			"stillDealing":   stillDealingCount,
@@ -636,15 +845,24 @@ func GetSummary(c *gin.Context) {
 			"pending":        pendingCount,
 			"needHelpStreak": totalNeedHelpStreak,
 		},
-		"mentalScore": mentalScore,
-		"mentalState": mentalState,
-		"mentalEmoji": mentalEmoji,
-		"aiSummary":   aiSummary,
+		MentalScore: mentalScore,
+		Factors:     scoreResult.Factors,
+		MentalState: mentalState,
+		MentalEmoji: mentalEmoji,
+		AISummary:   aiSummary,
+		CreatedAt:   now,
+	}
+
+	// A failed AI call is not worth remembering as history
+	if aiSummary != "" || totalEntries == 0 {
+		if err := DB.Create(&snapshot).Error; err != nil {
+			log.Printf("Failed to save summary snapshot: %v", err)
+		}
 	}
+	result := snapshot.response()
 
 	// Save to cache
-	cachedSummary = result
-	cachedDataHash = currentHash
+	summaryCache.Set(username, currentHash, result)
 
 	c.JSON(http.StatusOK, result)
 }
@@ -679,109 +897,62 @@ Generate 3 short prompts (1 sentence each) that would help the user explore thei
 	c.JSON(http.StatusOK, gin.H{"prompts": resultText})
 }
 
-// GetWeeklyDigest generates a weekly mental health summary
+// GetWeeklyDigest returns the mental health summary of the last 7 full days.
+// The digest is stored per period, so the AI writes it once a day at most and
+// a digest the scheduler already made for the same week is reused.
 func GetWeeklyDigest(c *gin.Context) {
-	weekAgo := time.Now().AddDate(0, 0, -7)
 	username := c.GetString("username")
-	var entries []DiaryEntry
-	DB.Where("username = ? AND created_at >= ?", username, weekAgo).Find(&entries)
+	local := time.Now().In(appLocation())
+	end := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	start := end.AddDate(0, 0, -7)
 
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).Where("username = ? AND is_draft = ? AND created_at >= ? AND created_at < ?", username, false, start, end).Find(&entries)
 	if len(entries) == 0 {
 		c.JSON(http.StatusOK, gin.H{"digest": "สัปดาห์นี้ยังไม่มีบันทึก ลองเขียนอะไรสักอย่างสิ!", "hasData": false})
 		return
 	}
 
-	var weekContent strings.Builder
-	moodCounts := make(map[string]int)
-	statusCounts := make(map[string]int)
-
-	for _, e := range entries {
-		weekContent.WriteString(e.Title + ": " + e.Content[:min(100, len(e.Content))] + "\n")
-		if e.Mood != "" {
-			moodCounts[e.Mood]++
-		}
-		if e.Status != "" {
-			statusCounts[e.Status]++
+	var digest Digest
+	err := DB.Where("username = ? AND period = ? AND period_start = ?", username, "weekly", start).Order("created_at desc").First(&digest).Error
+	if err != nil {
+		digest, _ = buildDigest(username, "weekly", start, end)
+		if err := DB.Create(&digest).Error; err != nil {
+			log.Printf("Failed to save weekly digest for %s: %v", username, err)
 		}
 	}
 
-	ctx := context.Background()
-
-	prompt := fmt.Sprintf(`สรุปสุขภาพจิตประจำสัปดาห์ จากบันทึก %d รายการ:
-%s
-
-เขียนสรุปสั้นๆ 2-3 ประโยค เป็นภาษาไทย บอกแนวโน้มอารมณ์และคำแนะนำ`, len(entries), weekContent.String())
-
-	resultText, _ := generateContent(ctx, prompt)
-
 	c.JSON(http.StatusOK, gin.H{
-		"digest":     resultText,
-		"hasData":    true,
-		"entryCount": len(entries),
-		"moods":      moodCounts,
-		"statuses":   statusCounts,
+		"digest":      digest.Text,
+		"hasData":     true,
+		"entryCount":  digest.EntryCount,
+		"moods":       digest.Moods,
+		"statuses":    digest.Statuses,
+		"moodTrend":   moodTrend(entries),
+		"periodStart": digest.PeriodStart,
+		"periodEnd":   digest.PeriodEnd,
 	})
 }
 
-// GetPatternAlerts detects negative patterns and provides support
-func GetPatternAlerts(c *gin.Context) {
-	username := c.GetString("username")
-	var entries []DiaryEntry
-	DB.Where("username = ?", username).Order("created_at desc").Limit(10).Find(&entries)
-
-	needHelpCount := 0
-	consecutiveNeedHelp := 0
-	maxStreak := 0
-
-	for _, e := range entries {
-		if e.Status == "need_help" {
-			needHelpCount++
-			consecutiveNeedHelp++
-			if consecutiveNeedHelp > maxStreak {
-				maxStreak = consecutiveNeedHelp
-			}
-		} else {
-			consecutiveNeedHelp = 0
-		}
-	}
-
-	alerts := []gin.H{}
-
-	if maxStreak >= 3 {
-		alerts = append(alerts, gin.H{
-			"type":    "critical",
-			"title":   "🆘 ต้องการความช่วยเหลือ",
-			"message": fmt.Sprintf("คุณเลือก 'ไม่ไหว ช่วยด้วย' %d ครั้งติดต่อกัน สายด่วนสุขภาพจิต 1323 พร้อมรับฟังคุณ", maxStreak),
-		})
-	} else if maxStreak >= 2 {
-		alerts = append(alerts, gin.H{
-			"type":    "warning",
-			"title":   "💛 เราห่วงใยคุณ",
-			"message": "ดูเหมือนคุณกำลังเผชิญช่วงเวลาที่ยากลำบาก อย่าลืมดูแลตัวเองนะ",
-		})
+// GetPreferences returns stored user preferences, newest first, one page at a time
+func GetPreferences(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
 	}
 
-	if needHelpCount > 5 {
-		alerts = append(alerts, gin.H{
-			"type":    "info",
-			"title":   "📊 สังเกตแนวโน้ม",
-			"message": fmt.Sprintf("จาก 10 รายการล่าสุด คุณรู้สึกต้องการช่วยเหลือ %d ครั้ง พิจารณาพูดคุยกับคนใกล้ชิด", needHelpCount),
-		})
+	username := c.GetString("username")
+	var prefs []UserPreference
+	if err := paginate(DB.Where("username = ?", username), cursor, limit, true).Find(&prefs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"alerts":       alerts,
-		"needHelpRate": float64(needHelpCount) / float64(max(len(entries), 1)) * 100,
-		"maxStreak":    maxStreak,
+	n, next := nextPage(c, len(prefs), limit, func(i int) (time.Time, uint) {
+		return prefs[i].CreatedAt, prefs[i].ID
 	})
-}
+	prefs = prefs[:n]
 
-// GetPreferences returns all stored user preferences
-func GetPreferences(c *gin.Context) {
-	username := c.GetString("username")
-	var prefs []UserPreference
-	DB.Where("username = ?", username).Find(&prefs)
-	c.JSON(http.StatusOK, prefs)
+	c.JSON(http.StatusOK, pageBody(c, prefs, next, limit))
 }
 
 // SavePreference stores a user's Q&A answer for AI learning
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MoodState is a structured mood recorded when writing or reflecting
+type MoodState struct {
+	Primary   string   `json:"primary"`
+	Secondary []string `json:"secondary" gorm:"serializer:json"`
+	Valence   float64  `json:"valence"`   // -1 (unpleasant) .. 1 (pleasant)
+	Arousal   float64  `json:"arousal"`   // 0 (calm) .. 1 (activated)
+	Intensity int      `json:"intensity"` // 1..10, 0 = not recorded
+}
+
+// Emotion is one entry of the mood taxonomy
+type Emotion struct {
+	Key         string  `json:"key"`
+	Label       string  `json:"label"`
+	Emoji       string  `json:"emoji"`
+	StrongEmoji string  `json:"strongEmoji"` // Used when intensity is 8 or more
+	Valence     float64 `json:"valence"`
+	Arousal     float64 `json:"arousal"`
+}
+
+// moodTaxonomy is the fixed list of primary emotions users can pick from
+var moodTaxonomy = map[string]Emotion{
+	"joy":          {"joy", "มีความสุข", "😊", "🤩", 0.8, 0.6},
+	"calm":         {"calm", "สงบ", "😌", "😌", 0.6, 0.15},
+	"grateful":     {"grateful", "ซาบซึ้ง", "🙏", "🥹", 0.7, 0.3},
+	"hopeful":      {"hopeful", "มีความหวัง", "🌱", "🌈", 0.6, 0.5},
+	"surprised":    {"surprised", "ประหลาดใจ", "😲", "🤯", 0.1, 0.8},
+	"tired":        {"tired", "เหนื่อย", "😴", "😩", -0.3, 0.1},
+	"confused":     {"confused", "สับสน", "😕", "😵‍💫", -0.3, 0.5},
+	"sad":          {"sad", "เศร้า", "😢", "😭", -0.7, 0.3},
+	"lonely":       {"lonely", "เหงา", "🥺", "😞", -0.6, 0.2},
+	"disappointed": {"disappointed", "ผิดหวัง", "😞", "💔", -0.6, 0.35},
+	"anxious":      {"anxious", "กังวล", "😰", "😱", -0.6, 0.8},
+	"stressed":     {"stressed", "เครียด", "😫", "🤯", -0.6, 0.75},
+	"angry":        {"angry", "โกรธ", "😠", "😡", -0.7, 0.9},
+	"ashamed":      {"ashamed", "อาย/รู้สึกผิด", "😳", "🫣", -0.6, 0.5},
+}
+
+// moodInput is what clients send; valence and arousal default to the taxonomy values
+type moodInput struct {
+	Primary   string   `json:"primary"`
+	Secondary []string `json:"secondary"`
+	Valence   *float64 `json:"valence"`
+	Arousal   *float64 `json:"arousal"`
+	Intensity int      `json:"intensity"`
+}
+
+// toState validates the input against the taxonomy and fills in defaults
+func (in *moodInput) toState() (MoodState, error) {
+	emotion, ok := moodTaxonomy[in.Primary]
+	if !ok {
+		return MoodState{}, fmt.Errorf("unknown primary emotion: %q", in.Primary)
+	}
+	if in.Intensity < 1 || in.Intensity > 10 {
+		return MoodState{}, fmt.Errorf("intensity must be between 1 and 10")
+	}
+
+	state := MoodState{
+		Primary:   in.Primary,
+		Valence:   emotion.Valence,
+		Arousal:   emotion.Arousal,
+		Intensity: in.Intensity,
+	}
+	for _, s := range in.Secondary {
+		if _, ok := moodTaxonomy[s]; !ok {
+			return MoodState{}, fmt.Errorf("unknown secondary emotion: %q", s)
+		}
+		if s != in.Primary {
+			state.Secondary = append(state.Secondary, s)
+		}
+	}
+	if in.Valence != nil {
+		if *in.Valence < -1 || *in.Valence > 1 {
+			return MoodState{}, fmt.Errorf("valence must be between -1 and 1")
+		}
+		state.Valence = *in.Valence
+	}
+	if in.Arousal != nil {
+		if *in.Arousal < 0 || *in.Arousal > 1 {
+			return MoodState{}, fmt.Errorf("arousal must be between 0 and 1")
+		}
+		state.Arousal = *in.Arousal
+	}
+	return state, nil
+}
+
+// Emoji derives the display emoji from the structured mood
+func (m MoodState) Emoji() string {
+	emotion, ok := moodTaxonomy[m.Primary]
+	if !ok {
+		return ""
+	}
+	if m.Intensity >= 8 {
+		return emotion.StrongEmoji
+	}
+	return emotion.Emoji
+}
+
+// moodTrend computes intensity trends from entries (with Reflections preloaded)
+func moodTrend(entries []DiaryEntry) gin.H {
+	emotions := make(map[string]int)
+	var writeSum, writeN, reflectSum, reflectN int
+	var valenceSum float64
+
+	// Oldest first so the change per entry reads forward in time
+	sorted := append([]DiaryEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+
+	points := []gin.H{}
+	for _, e := range sorted {
+		if e.MoodDetail.Intensity == 0 {
+			continue
+		}
+		emotions[e.MoodDetail.Primary]++
+		writeSum += e.MoodDetail.Intensity
+		valenceSum += e.MoodDetail.Valence
+		writeN++
+
+		point := gin.H{
+			"entryId":   e.ID,
+			"date":      e.CreatedAt.Format("2006-01-02"),
+			"primary":   e.MoodDetail.Primary,
+			"intensity": e.MoodDetail.Intensity,
+		}
+		// Latest reflection with an intensity shows how the feeling changed
+		for i := len(e.Reflections) - 1; i >= 0; i-- {
+			if r := e.Reflections[i].MoodDetail.Intensity; r > 0 {
+				reflectSum += r
+				reflectN++
+				point["reflectionIntensity"] = r
+				point["change"] = r - e.MoodDetail.Intensity
+				break
+			}
+		}
+		points = append(points, point)
+	}
+
+	result := gin.H{
+		"emotions":         emotions,
+		"points":           points,
+		"averageIntensity": 0.0,
+		"averageValence":   0.0,
+	}
+	if writeN > 0 {
+		result["averageIntensity"] = float64(writeSum) / float64(writeN)
+		result["averageValence"] = valenceSum / float64(writeN)
+	}
+	if reflectN > 0 {
+		result["averageReflectionIntensity"] = float64(reflectSum) / float64(reflectN)
+	}
+	return result
+}
+
+// GetMoodTaxonomy lists the emotions a mood can be built from
+func GetMoodTaxonomy(c *gin.Context) {
+	emotions := make([]Emotion, 0, len(moodTaxonomy))
+	for _, e := range moodTaxonomy {
+		emotions = append(emotions, e)
+	}
+	sort.Slice(emotions, func(i, j int) bool { return emotions[i].Valence > emotions[j].Valence })
+	c.JSON(http.StatusOK, gin.H{"emotions": emotions})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// pageCursor points at the last row of the previous page (created_at + id)
+type pageCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+func encodeCursor(createdAt time.Time, id uint) string {
+	raw := fmt.Sprintf("%d:%d", createdAt.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &pageCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: uint(id)}, nil
+}
+
+// parsePage reads ?limit= and ?cursor= and writes a 400 response if they are invalid
+func parsePage(c *gin.Context) (int, *pageCursor, bool) {
+	limit := defaultPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return 0, nil, false
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	var cursor *pageCursor
+	if v := c.Query("cursor"); v != "" {
+		var err error
+		cursor, err = decodeCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return 0, nil, false
+		}
+	}
+
+	return limit, cursor, true
+}
+
+// createdAtKey is created_at as a UTC timestamp with millisecond precision.
+// Rows keep the offset they were written with, so created_at strings from
+// different zones don't compare correctly; both sides go through this form.
+const createdAtKey = "strftime('%Y-%m-%d %H:%M:%f', created_at)"
+
+// paginate orders the query by (created_at, id) and continues after the cursor.
+// One extra row is fetched so nextPage can tell whether more pages exist.
+func paginate(query *gorm.DB, cursor *pageCursor, limit int, desc bool) *gorm.DB {
+	dir, cmp := "asc", ">"
+	if desc {
+		dir, cmp = "desc", "<"
+	}
+	query = query.Order(createdAtKey + " " + dir).Order("id " + dir)
+	if cursor != nil {
+		at := "strftime('%Y-%m-%d %H:%M:%f', ?)"
+		query = query.Where(createdAtKey+" "+cmp+" "+at+" OR ("+createdAtKey+" = "+at+" AND id "+cmp+" ?)",
+			cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+	return query.Limit(limit + 1)
+}
+
+// nextPage trims the extra row and returns the cursor of the next page ("" on
+// the last one). The cursor is also sent in X-Next-Cursor and a Link header.
+func nextPage(c *gin.Context, fetched, limit int, last func(i int) (time.Time, uint)) (int, string) {
+	if fetched <= limit {
+		return fetched, ""
+	}
+
+	createdAt, id := last(limit - 1)
+	next := encodeCursor(createdAt, id)
+	c.Header("X-Next-Cursor", next)
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, nextLink(c, next, limit)))
+	return limit, next
+}
+
+func nextLink(c *gin.Context, cursor string, limit int) string {
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set("cursor", cursor)
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// pageBody is the body of every list endpoint
+func pageBody(c *gin.Context, items interface{}, next string, limit int) gin.H {
+	body := gin.H{"items": items, "next_cursor": nil, "next": nil}
+	if next != "" {
+		body["next_cursor"] = next
+		body["next"] = nextLink(c, next, limit)
+	}
+	return body
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTripIsUTC(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 30, 0, 123456789, time.FixedZone("ICT", 7*3600))
+	cursor, err := decodeCursor(encodeCursor(at, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.CreatedAt.Equal(at) || cursor.CreatedAt.Location() != time.UTC || cursor.ID != 42 {
+		t.Errorf("cursor = %+v", cursor)
+	}
+
+	for _, bad := range []string{"!!", "MTIz", "eDox"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPaginationAcrossTimeZones(t *testing.T) {
+	setupTestDB(t)
+	bangkok := time.FixedZone("ICT", 7*3600)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Rows written with different offsets; as strings "20:00+07:00" sorts after "14:00+00:00"
+	// although it is an hour earlier
+	for i, at := range []time.Time{
+		base.Add(-3 * time.Hour).In(bangkok),
+		base.Add(-2 * time.Hour),
+		base.Add(-time.Hour).In(bangkok),
+		base,
+		base.Add(time.Hour).In(bangkok),
+	} {
+		DB.Create(&UserPreference{Username: "alice", Question: string(rune('a' + i)), CreatedAt: at})
+	}
+
+	r := testRouter("alice")
+	r.GET("/preferences", GetPreferences)
+	var got []string
+	url := "/preferences?limit=2"
+	for pages := 0; url != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination does not end")
+		}
+		w := doJSON(r, http.MethodGet, url, nil)
+		var body struct {
+			Items      []UserPreference `json:"items"`
+			NextCursor *string          `json:"next_cursor"`
+			Next       *string          `json:"next"`
+		}
+		decodeBody(t, w, &body)
+		for _, p := range body.Items {
+			got = append(got, p.Question)
+		}
+		url = ""
+		if body.Next != nil {
+			if body.NextCursor == nil || w.Header().Get("X-Next-Cursor") != *body.NextCursor {
+				t.Errorf("next_cursor = %v, header = %q", body.NextCursor, w.Header().Get("X-Next-Cursor"))
+			}
+			url = *body.Next
+		}
+	}
+
+	if want := []string{"e", "d", "c", "b", "a"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	patternWindow      = 90 * 24 * time.Hour // Cluster rules look this far back
+	topicReturnWindow  = 30 * 24 * time.Hour // Only recent entries can "bring a topic back"
+	topicSimilarity    = 0.5                 // Minimum cosine score to call two entries the same topic
+	criticalSnoozeDays = 3                   // Critical alerts come back after this even when dismissed
+)
+
+// PatternAlert is one detected pattern. Key is stable for the same underlying
+// pattern so a dismissal keeps hiding it until the pattern itself changes.
+type PatternAlert struct {
+	Key      string `json:"key"`
+	Rule     string `json:"rule"`
+	Type     string `json:"type"` // critical, warning or info
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Evidence []uint `json:"evidence"` // IDs of the entries that triggered the alert
+}
+
+// AlertDismissal hides an alert for a user, forever or until SnoozedUntil
+type AlertDismissal struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Username     string     `json:"username" gorm:"uniqueIndex:idx_dismissal_user_key"`
+	AlertKey     string     `json:"alertKey" gorm:"uniqueIndex:idx_dismissal_user_key"`
+	SnoozedUntil *time.Time `json:"snoozedUntil"` // nil = dismissed for good
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// patternInput is what every rule gets to look at
+type patternInput struct {
+	recent []DiaryEntry // Last 10 entries, newest first
+	window []DiaryEntry // Entries of the last patternWindow, oldest first
+	loc    *time.Location
+	now    time.Time
+}
+
+type patternRule struct {
+	name   string
+	detect func(in patternInput) []PatternAlert
+}
+
+var patternRules = []patternRule{
+	{"need_help_streak", detectNeedHelpStreak},
+	{"need_help_rate", detectNeedHelpRate},
+	{"day_of_week", detectDayOfWeek},
+	{"time_of_day", detectTimeOfDay},
+	{"trigger", detectTriggers},
+	{"mood_drop", detectMoodDrops},
+	{"topic_return", detectTopicReturns},
+}
+
+// isStruggling is the negative signal the cluster rules count
+func isStruggling(e DiaryEntry) bool {
+	return e.Status == "need_help" || e.NeedHelpCount > 0 ||
+		(e.MoodDetail.Valence < 0 && e.MoodDetail.Intensity >= 6)
+}
+
+// moodValue maps a structured mood to -1..1, weighted by intensity
+func moodValue(e DiaryEntry) (float64, bool) {
+	if e.MoodDetail.Intensity == 0 {
+		return 0, false
+	}
+	return e.MoodDetail.Valence * float64(e.MoodDetail.Intensity) / 10, true
+}
+
+// needHelpStats counts need_help entries and the longest streak among recent entries (newest first)
+func needHelpStats(recent []DiaryEntry) (count int, streak []DiaryEntry) {
+	var current []DiaryEntry
+	for _, e := range recent {
+		if e.Status == "need_help" {
+			count++
+			current = append(current, e)
+			if len(current) > len(streak) {
+				streak = current
+			}
+		} else {
+			current = nil
+		}
+	}
+	return count, streak
+}
+
+func entryIDs(entries []DiaryEntry) []uint {
+	ids := make([]uint, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// --- Rules ---
+
+func detectNeedHelpStreak(in patternInput) []PatternAlert {
+	_, streak := needHelpStats(in.recent)
+	if len(streak) < 2 {
+		return nil
+	}
+
+	// The oldest entry identifies the streak; a new streak gets a new key, and so does
+	// a streak that grows critical, so hiding the warning does not hide the escalation
+	alert := PatternAlert{
+		Key:      fmt.Sprintf("need_help_streak:%d", streak[len(streak)-1].ID),
+		Rule:     "need_help_streak",
+		Evidence: entryIDs(streak),
+	}
+	if len(streak) >= 3 {
+		alert.Key += ":critical"
+		alert.Type = "critical"
+		alert.Title = "🆘 ต้องการความช่วยเหลือ"
+		alert.Message = fmt.Sprintf("คุณเลือก 'ไม่ไหว ช่วยด้วย' %d ครั้งติดต่อกัน สายด่วนสุขภาพจิต 1323 พร้อมรับฟังคุณ", len(streak))
+	} else {
+		alert.Type = "warning"
+		alert.Title = "💛 เราห่วงใยคุณ"
+		alert.Message = "ดูเหมือนคุณกำลังเผชิญช่วงเวลาที่ยากลำบาก อย่าลืมดูแลตัวเองนะ"
+	}
+	return []PatternAlert{alert}
+}
+
+func detectNeedHelpRate(in patternInput) []PatternAlert {
+	count, _ := needHelpStats(in.recent)
+	if count <= 5 {
+		return nil
+	}
+
+	var evidence []DiaryEntry
+	for _, e := range in.recent {
+		if e.Status == "need_help" {
+			evidence = append(evidence, e)
+		}
+	}
+	year, week := in.now.In(in.loc).ISOWeek()
+	return []PatternAlert{{
+		Key:      fmt.Sprintf("need_help_rate:%d-W%02d", year, week),
+		Rule:     "need_help_rate",
+		Type:     "info",
+		Title:    "📊 สังเกตแนวโน้ม",
+		Message:  fmt.Sprintf("จาก 10 รายการล่าสุด คุณรู้สึกต้องการช่วยเหลือ %d ครั้ง พิจารณาพูดคุยกับคนใกล้ชิด", count),
+		Evidence: entryIDs(evidence),
+	}}
+}
+
+var thaiWeekdays = [...]string{"อาทิตย์", "จันทร์", "อังคาร", "พุธ", "พฤหัสบดี", "ศุกร์", "เสาร์"}
+
+// clusterOf finds the group holding an unusual share of struggling entries
+func clusterOf(entries []DiaryEntry, group func(DiaryEntry) string, minCount int, minShare float64) (string, []DiaryEntry) {
+	buckets := make(map[string][]DiaryEntry)
+	total := 0
+	for _, e := range entries {
+		if !isStruggling(e) {
+			continue
+		}
+		total++
+		key := group(e)
+		buckets[key] = append(buckets[key], e)
+	}
+	if total < 5 {
+		return "", nil
+	}
+
+	best := ""
+	for key, list := range buckets {
+		if best == "" || len(list) > len(buckets[best]) || (len(list) == len(buckets[best]) && key < best) {
+			best = key
+		}
+	}
+	list := buckets[best]
+	if len(list) < minCount || float64(len(list))/float64(total) < minShare {
+		return "", nil
+	}
+	return best, list
+}
+
+func detectDayOfWeek(in patternInput) []PatternAlert {
+	// Twice the 1-in-7 share a day would get by chance
+	day, list := clusterOf(in.window, func(e DiaryEntry) string {
+		return fmt.Sprint(int(e.CreatedAt.In(in.loc).Weekday()))
+	}, 3, 2.0/7)
+	if list == nil {
+		return nil
+	}
+
+	name := thaiWeekdays[list[0].CreatedAt.In(in.loc).Weekday()]
+	return []PatternAlert{{
+		Key:      "day_of_week:" + day,
+		Rule:     "day_of_week",
+		Type:     "info",
+		Title:    "📅 วันที่มักหนักใจ",
+		Message:  fmt.Sprintf("ช่วง 90 วันที่ผ่านมา วัน%sมักเป็นวันที่คุณรู้สึกหนักใจ (%d ครั้ง) ลองวางแผนดูแลตัวเองล่วงหน้าในวันนั้นดูนะ", name, len(list)),
+		Evidence: entryIDs(list),
+	}}
+}
+
+// dayPart splits the day into night (0-5), morning (6-11), afternoon (12-17) and evening (18-23)
+func dayPart(t time.Time) string {
+	switch h := t.Hour(); {
+	case h < 6:
+		return "night"
+	case h < 12:
+		return "morning"
+	case h < 18:
+		return "afternoon"
+	default:
+		return "evening"
+	}
+}
+
+var dayPartLabels = map[string]string{
+	"night":     "ช่วงดึก",
+	"morning":   "ช่วงเช้า",
+	"afternoon": "ช่วงบ่าย",
+	"evening":   "ช่วงเย็น",
+}
+
+func detectTimeOfDay(in patternInput) []PatternAlert {
+	// Twice the 1-in-4 share a day part would get by chance
+	part, list := clusterOf(in.window, func(e DiaryEntry) string {
+		return dayPart(e.CreatedAt.In(in.loc))
+	}, 3, 0.5)
+	if list == nil {
+		return nil
+	}
+
+	message := fmt.Sprintf("ความรู้สึกหนักใจมักเกิดขึ้น%s (%d ครั้ง)", dayPartLabels[part], len(list))
+	if part == "night" {
+		message += " การพักผ่อนให้เพียงพออาจช่วยได้มากนะ"
+	}
+	return []PatternAlert{{
+		Key:      "time_of_day:" + part,
+		Rule:     "time_of_day",
+		Type:     "info",
+		Title:    "🕰️ ช่วงเวลาที่มักหนักใจ",
+		Message:  message,
+		Evidence: entryIDs(list),
+	}}
+}
+
+var triggerStopwords = map[string]bool{
+	"the": true, "and": true, "that": true, "this": true, "with": true, "was": true, "for": true,
+	"have": true, "not": true, "but": true, "are": true, "you": true, "just": true, "today": true,
+	"วันนี้": true, "รู้สึก": true, "อะไร": true, "เพราะ": true, "แล้ว": true, "ไม่ได้": true,
+}
+
+// entryTerms returns the distinct words and tags of an entry. Thai has no spaces between
+// words, so Thai terms are whatever the writer separated with spaces.
+func entryTerms(e DiaryEntry) map[string]bool {
+	terms := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(e.Title+" "+e.Content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		n := utf8.RuneCountInString(w)
+		if n < 3 || n > 30 || triggerStopwords[w] {
+			continue
+		}
+		terms[w] = true
+	}
+	for _, t := range e.Tags {
+		terms["#"+t] = true
+	}
+	return terms
+}
+
+func detectTriggers(in patternInput) []PatternAlert {
+	total := make(map[string]int)
+	hits := make(map[string][]DiaryEntry)
+	for _, e := range in.window {
+		// The alert quotes the word, so words from locked entries must not leak
+		if in.now.Before(e.UnlockAt) {
+			continue
+		}
+		struggling := isStruggling(e)
+		for term := range entryTerms(e) {
+			total[term]++
+			if struggling {
+				hits[term] = append(hits[term], e)
+			}
+		}
+	}
+
+	// A trigger keeps showing up in hard entries and rarely anywhere else
+	var terms []string
+	for term, list := range hits {
+		if len(list) >= 3 && float64(len(list))/float64(total[term]) >= 0.6 {
+			terms = append(terms, term)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if len(hits[terms[i]]) != len(hits[terms[j]]) {
+			return len(hits[terms[i]]) > len(hits[terms[j]])
+		}
+		return terms[i] < terms[j]
+	})
+
+	var alerts []PatternAlert
+	for _, term := range terms[:min(3, len(terms))] {
+		alerts = append(alerts, PatternAlert{
+			Key:      "trigger:" + term,
+			Rule:     "trigger",
+			Type:     "info",
+			Title:    "🔁 เรื่องที่วนกลับมา",
+			Message:  fmt.Sprintf("\"%s\" ปรากฏในบันทึกที่คุณรู้สึกหนักใจถึง %d ครั้ง อาจเป็นสิ่งกระตุ้นที่ควรใส่ใจ", strings.TrimPrefix(term, "#"), len(hits[term])),
+			Evidence: entryIDs(hits[term]),
+		})
+	}
+	return alerts
+}
+
+func detectMoodDrops(in patternInput) []PatternAlert {
+	tagged := make(map[string][]DiaryEntry)
+	var allSum float64
+	allN := 0
+	for _, e := range in.window {
+		v, ok := moodValue(e)
+		if !ok {
+			continue
+		}
+		allSum += v
+		allN++
+		for _, t := range e.Tags {
+			tagged[t] = append(tagged[t], e)
+		}
+	}
+
+	var tags []string
+	drops := make(map[string]float64)
+	for tag, list := range tagged {
+		if len(list) < 3 || allN-len(list) < 3 {
+			continue
+		}
+		var sum float64
+		for _, e := range list {
+			v, _ := moodValue(e)
+			sum += v
+		}
+		// Compare with the entries that do not carry the tag
+		baseline := (allSum - sum) / float64(allN-len(list))
+		if drop := baseline - sum/float64(len(list)); drop >= 0.25 {
+			drops[tag] = drop
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return drops[tags[i]] > drops[tags[j]] })
+
+	var alerts []PatternAlert
+	for _, tag := range tags[:min(3, len(tags))] {
+		alerts = append(alerts, PatternAlert{
+			Key:      "mood_drop:" + tag,
+			Rule:     "mood_drop",
+			Type:     "info",
+			Title:    "📉 อารมณ์ที่ลดลง",
+			Message:  fmt.Sprintf("บันทึกที่มีแท็ก #%s มักมาพร้อมอารมณ์ที่แย่กว่าปกติ ลองสังเกตดูว่าเรื่องนี้ส่งผลกับคุณอย่างไร", tag),
+			Evidence: entryIDs(tagged[tag]),
+		})
+	}
+	return alerts
+}
+
+// findReturningTopic looks for an earlier finished entry about the same topic:
+// the one it was already linked to, one sharing a tag, or one close enough in meaning
+func findReturningTopic(e DiaryEntry) (DiaryEntry, bool) {
+	if e.RelapseOfID != nil {
+		var original DiaryEntry
+		if DB.Where("id = ? AND username = ?", *e.RelapseOfID, e.Username).First(&original).Error == nil {
+			return original, true
+		}
+	}
+
+	if len(e.Tags) > 0 {
+		var finished []DiaryEntry
+		DB.Where("username = ? AND is_finished = ? AND id <> ? AND created_at < ?", e.Username, true, e.ID, e.CreatedAt).
+			Order("created_at desc").Limit(200).Find(&finished)
+		for _, f := range finished {
+			for _, t := range f.Tags {
+				for _, own := range e.Tags {
+					if t == own {
+						return f, true
+					}
+				}
+			}
+		}
+	}
+
+	similar, scores, err := findSimilarToEntry(e, 5)
+	if err != nil {
+		log.Printf("Similar entry lookup failed: %v", err)
+		return DiaryEntry{}, false
+	}
+	for i, f := range similar {
+		if f.IsFinished && f.CreatedAt.Before(e.CreatedAt) && scores[i] >= topicSimilarity {
+			return f, true
+		}
+	}
+	return DiaryEntry{}, false
+}
+
+func detectTopicReturns(in patternInput) []PatternAlert {
+	returned := make(map[uint][]DiaryEntry)
+	originals := make(map[uint]DiaryEntry)
+	var order []uint
+
+	// Newest entries only
+	checked := 0
+	for i := len(in.window) - 1; i >= 0 && checked < 5; i-- {
+		e := in.window[i]
+		if in.now.Sub(e.CreatedAt) > topicReturnWindow {
+			break
+		}
+		if e.IsFinished || e.IsDraft {
+			continue
+		}
+		checked++
+		original, ok := findReturningTopic(e)
+		if !ok {
+			continue
+		}
+		if _, seen := originals[original.ID]; !seen {
+			originals[original.ID] = original
+			order = append(order, original.ID)
+		}
+		returned[original.ID] = append(returned[original.ID], e)
+	}
+
+	var alerts []PatternAlert
+	for _, id := range order {
+		original := originals[id]
+		alerts = append(alerts, PatternAlert{
+			Key:      fmt.Sprintf("topic_return:%d", id),
+			Rule:     "topic_return",
+			Type:     "info",
+			Title:    "🔄 เรื่องเดิมกลับมาอีกครั้ง",
+			Message:  fmt.Sprintf("เรื่องนี้คล้ายกับ \"%s\" ที่คุณเคยผ่านมาได้แล้ว ไม่เป็นไรเลยที่มันกลับมา คุณเคยทำได้มาแล้วครั้งหนึ่ง", original.Title),
+			Evidence: append([]uint{id}, entryIDs(returned[id])...),
+		})
+	}
+	return alerts
+}
+
+// --- Dismissals ---
+
+// activeDismissals returns the alert keys the user is currently hiding
+func activeDismissals(username string, now time.Time) map[string]bool {
+	var dismissals []AlertDismissal
+	DB.Where("username = ?", username).Find(&dismissals)
+
+	hidden := make(map[string]bool)
+	for _, d := range dismissals {
+		if d.SnoozedUntil == nil || now.Before(*d.SnoozedUntil) {
+			hidden[d.AlertKey] = true
+		}
+	}
+	return hidden
+}
+
+// limitedSnooze reports whether an alert key may only be hidden for criticalSnoozeDays
+func limitedSnooze(key string) bool {
+	return strings.HasPrefix(key, "need_help_streak:")
+}
+
+func saveDismissal(c *gin.Context, key string, until *time.Time) {
+	dismissal := AlertDismissal{Username: c.GetString("username"), AlertKey: key}
+	err := DB.Where(dismissal).Assign(map[string]interface{}{"snoozed_until": until}).FirstOrCreate(&dismissal).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, dismissal)
+}
+
+// --- Handlers ---
+
+// GetPatternAlerts runs every pattern rule over the user's entries and returns the
+// alerts that are not dismissed or snoozed
+func GetPatternAlerts(c *gin.Context) {
+	username := c.GetString("username")
+	now := time.Now()
+
+	loc, err := userLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	in := patternInput{loc: loc, now: now}
+	DB.Where("username = ? AND is_draft = ?", username, false).Order("created_at desc").Limit(10).Find(&in.recent)
+	DB.Where("username = ? AND is_draft = ? AND created_at >= ?", username, false, now.Add(-patternWindow)).
+		Order("created_at asc").Find(&in.window)
+
+	hidden := activeDismissals(username, now)
+	alerts := []PatternAlert{}
+	dismissed := 0
+	for _, rule := range patternRules {
+		for _, alert := range rule.detect(in) {
+			if hidden[alert.Key] {
+				dismissed++
+				continue
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+
+	needHelpCount, streak := needHelpStats(in.recent)
+	c.JSON(http.StatusOK, gin.H{
+		"alerts":       alerts,
+		"dismissed":    dismissed,
+		"needHelpRate": float64(needHelpCount) / float64(max(len(in.recent), 1)) * 100,
+		"maxStreak":    len(streak),
+	})
+}
+
+// DismissAlert hides an alert for good. Need-help streak alerts are only snoozed:
+// if the streak is still there after a few days, the user should see it again.
+func DismissAlert(c *gin.Context) {
+	var input struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var until *time.Time
+	if limitedSnooze(input.Key) {
+		t := time.Now().AddDate(0, 0, criticalSnoozeDays)
+		until = &t
+	}
+	saveDismissal(c, input.Key, until)
+}
+
+// SnoozeAlert hides an alert for a number of days (1-30, default 7). Need-help streak
+// alerts are snoozed for at most criticalSnoozeDays.
+func SnoozeAlert(c *gin.Context) {
+	var input struct {
+		Key  string `json:"key" binding:"required"`
+		Days int    `json:"days"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Days == 0 {
+		input.Days = 7
+	}
+	if input.Days < 1 || input.Days > 30 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 30"})
+		return
+	}
+
+	if limitedSnooze(input.Key) {
+		input.Days = min(input.Days, criticalSnoozeDays)
+	}
+	until := time.Now().AddDate(0, 0, input.Days)
+	saveDismissal(c, input.Key, &until)
+}
+
+// RestoreAlert removes a dismissal or snooze so the alert can show again
+func RestoreAlert(c *gin.Context) {
+	var input struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	DB.Where("username = ? AND alert_key = ?", c.GetString("username"), input.Key).Delete(&AlertDismissal{})
+	c.JSON(http.StatusOK, gin.H{"message": "Alert restored"})
+}
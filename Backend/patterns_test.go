@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTriggersIgnoreLockedEntries(t *testing.T) {
+	now := time.Now()
+	hard := func(content string, unlockAt time.Time) DiaryEntry {
+		return DiaryEntry{Content: content, Status: "need_help", UnlockAt: unlockAt}
+	}
+	open := now.Add(-time.Hour)
+	locked := now.Add(12 * time.Hour)
+
+	in := patternInput{now: now, loc: time.UTC, window: []DiaryEntry{
+		hard("ประชุม deadline", open),
+		hard("ประชุม deadline", open),
+		hard("ประชุม deadline", open),
+		// Only the locked entries name the secret
+		hard("ความลับ", locked),
+		hard("ความลับ", locked),
+		hard("ความลับ", locked),
+		// A locked entry does not count toward a word of the open ones either
+		hard("ประชุม", locked),
+	}}
+	for i := range in.window {
+		in.window[i].ID = uint(i + 1)
+	}
+
+	alerts := detectTriggers(in)
+	keys := make([]string, len(alerts))
+	for i, a := range alerts {
+		keys[i] = a.Key
+		if strings.Contains(a.Message, "ความลับ") {
+			t.Errorf("alert quotes a locked entry: %q", a.Message)
+		}
+		for _, id := range a.Evidence {
+			if id > 3 {
+				t.Errorf("%s cites locked entry %d", a.Key, id)
+			}
+		}
+	}
+	if len(alerts) != 2 || keys[0] != "trigger:deadline" || keys[1] != "trigger:ประชุม" {
+		t.Errorf("alerts = %v", keys)
+	}
+}
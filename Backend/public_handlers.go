@@ -7,16 +7,26 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GetPublicEntries returns all public diary entries (unlocked)
+// GetPublicEntries returns public diary entries (unlocked), newest first, one page at a time
 func GetPublicEntries(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
 	var entries []DiaryEntry
-	// Only get public entries that are not locked
+	// Only get public entries that are not locked; drafts are never public
 	now := time.Now()
-	result := DB.Where("is_public = ? AND unlock_at <= ?", true, now).Order("created_at desc").Find(&entries)
+	query := DB.Where("is_public = ? AND is_draft = ? AND unlock_at <= ?", true, false, now)
+	result := paginate(query, cursor, limit, true).Find(&entries)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	n, next := nextPage(c, len(entries), limit, func(i int) (time.Time, uint) {
+		return entries[i].CreatedAt, entries[i].ID
+	})
+	entries = entries[:n]
 
 	// Filter out sensitive data for anonymous posts
 	for i := range entries {
@@ -25,7 +35,7 @@ func GetPublicEntries(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, entries)
+	c.JSON(http.StatusOK, pageBody(c, entries, next, limit))
 }
 
 // TogglePublic handles making a diary public or private
@@ -50,6 +60,11 @@ func TogglePublic(c *gin.Context) {
 		return
 	}
 
+	if entry.IsDraft && input.IsPublic {
+		c.JSON(http.StatusConflict, gin.H{"error": "Confirm the transcript before publishing"})
+		return
+	}
+
 	entry.IsPublic = input.IsPublic
 	entry.IsAnonymous = input.IsAnonymous
 	DB.Save(&entry)
@@ -121,16 +136,25 @@ func PostComment(c *gin.Context) {
 	c.JSON(http.StatusCreated, comment)
 }
 
-// GetComments returns all comments for a specific diary
+// GetComments returns comments for a specific diary, oldest first, one page at a time
 func GetComments(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
 	diaryID := c.Param("id")
 
 	var comments []Comment
-	result := DB.Where("diary_id = ?", diaryID).Order("created_at asc").Find(&comments)
+	result := paginate(DB.Where("diary_id = ?", diaryID), cursor, limit, false).Find(&comments)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	n, next := nextPage(c, len(comments), limit, func(i int) (time.Time, uint) {
+		return comments[i].CreatedAt, comments[i].ID
+	})
+	comments = comments[:n]
 
 	// Handle anonymity
 	for i := range comments {
@@ -139,5 +163,5 @@ func GetComments(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, comments)
+	c.JSON(http.StatusOK, pageBody(c, comments, next, limit))
 }
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate is a number of events allowed per period, e.g. 10 per minute
+type Rate struct {
+	Events int
+	Per    time.Duration
+}
+
+// ParseRate reads rates like "10/m", "5/h" or "2/s"
+func ParseRate(s string) (Rate, error) {
+	n, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	events, err := strconv.Atoi(n)
+	if !ok || err != nil || events <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected something like 10/m", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[unit]
+	if per == 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, the unit must be s, m, h or d", s)
+	}
+	return Rate{Events: events, Per: per}, nil
+}
+
+// FromEnv returns the rate in the named env var, or fallback when it is unset
+func FromEnv(name, fallback string) Rate {
+	value := os.Getenv(name)
+	if value == "" {
+		value = fallback
+	}
+	r, err := ParseRate(value)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return r
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is an in-memory token bucket per key. A key can burst up to
+// Rate.Events and then gets one more event every Per/Events.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      Rate
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(r Rate) *Limiter {
+	return &Limiter{rate: r, buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// Allow takes one event for key. When the bucket is empty it returns false
+// and how long until the next event is allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	perToken := l.rate.Per / time.Duration(l.rate.Events)
+	l.sweep(now)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(l.rate.Events), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.rate.Events), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely, so idle keys do not pile up
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.rate.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.rate.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware rejects requests over the limit with 429 and a Retry-After header
+func Middleware(l *Limiter, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.Allow(key(c))
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please slow down", "retryAfter": seconds})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ByIP keys requests by client IP
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser keys requests by the logged in user, falling back to the client IP
+func ByUser(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return "user:" + username
+	}
+	return ByIP(c)
+}
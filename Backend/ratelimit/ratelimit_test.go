@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]Rate{
+		"10/m":   {10, time.Minute},
+		" 5/h ":  {5, time.Hour},
+		"2/s":    {2, time.Second},
+		"100/d":  {100, 24 * time.Hour},
+		"1/m":    {1, time.Minute},
+		"10/min": {},
+		"10":     {},
+		"0/m":    {},
+		"-1/m":   {},
+		"x/m":    {},
+		"":       {},
+	} {
+		got, err := ParseRate(s)
+		if want.Events == 0 {
+			if err == nil {
+				t.Errorf("ParseRate(%q) = %+v, want an error", s, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %+v, %v; want %+v", s, got, err, want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TEST_RATE", "")
+	if r := FromEnv("TEST_RATE", "10/m"); r != (Rate{10, time.Minute}) {
+		t.Errorf("fallback = %+v", r)
+	}
+	t.Setenv("TEST_RATE", "3/s")
+	if r := FromEnv("TEST_RATE", "10/m"); r != (Rate{3, time.Second}) {
+		t.Errorf("from env = %+v", r)
+	}
+}
+
+// rewind moves a bucket's last update back, as if d had passed
+func rewind(l *Limiter, key string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets[key].last = l.buckets[key].last.Add(-d)
+}
+
+func TestLimiterBurstsThenRefills(t *testing.T) {
+	l := New(Rate{Events: 3, Per: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("event %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 19*time.Second || wait > 20*time.Second {
+		t.Fatalf("after the burst: allowed %v, wait %s; want refused for about 20s", ok, wait)
+	}
+	// Other keys have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another key refused")
+	}
+
+	// One token comes back every Per/Events
+	rewind(l, "a", 20*time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refused after a token refilled")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("allowed more than refilled")
+	}
+
+	// Refilling stops at a full burst
+	rewind(l, "a", time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("event %d after idling refused", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("burst grew past Rate.Events")
+	}
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	l := New(Rate{Events: 2, Per: time.Minute})
+	l.Allow("idle")
+	l.Allow("busy")
+	rewind(l, "idle", time.Minute)
+	l.lastSweep = l.lastSweep.Add(-time.Minute)
+
+	l.Allow("busy")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("idle bucket kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("busy bucket dropped")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", Middleware(New(Rate{Events: 1, Per: time.Minute}), ByIP), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := send("192.0.2.1"); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w := send("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("second request: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := send("192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("another IP: %d", w.Code)
+	}
+}
+
+func TestByUser(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	if key := ByUser(c); key != "ip:192.0.2.1" {
+		t.Errorf("anonymous key = %q", key)
+	}
+	c.Set("username", "alice")
+	if key := ByUser(c); key != "user:alice" {
+		t.Errorf("user key = %q", key)
+	}
+}
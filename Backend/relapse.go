@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"dt-backend/controller/auth"
+)
+
+// checkRelapse links a new entry to a finished one about the same topic and leaves the
+// user a gentle note pointing back to how they got through it last time.
+// The similarity match reads the entry's stored embedding, so callers run it after
+// indexEntry in the same background job.
+func checkRelapse(entry DiaryEntry) {
+	if !auth.UserExists(entry.Username) {
+		return
+	}
+	original, ok := findReturningTopic(entry)
+	if !ok {
+		return
+	}
+
+	if err := DB.Model(&DiaryEntry{}).Where("id = ?", entry.ID).Update("relapse_of_id", original.ID).Error; err != nil {
+		log.Printf("Failed to link entry %d to %d: %v", entry.ID, original.ID, err)
+		return
+	}
+
+	body := fmt.Sprintf("เรื่อง \"%s\" ดูคล้ายกับเรื่อง \"%s\" ที่คุณเคยก้าวผ่านมาได้เมื่อ %s ไม่เป็นไรเลยที่มันกลับมา การกลับมาไม่ได้แปลว่าคุณถอยหลัง",
+		entry.Title, original.Title, original.CreatedAt.In(appLocation()).Format("02/01/2006"))
+	if original.AIResponse != "" {
+		body += "\n\n🌱 สิ่งที่คุณเคยได้เรียนรู้จากครั้งนั้น:\n" + original.AIResponse
+	}
+
+	note := Notification{
+		Username:  entry.Username,
+		Kind:      "relapse",
+		Title:     "💛 เรื่องนี้เคยผ่านมาแล้ว",
+		Body:      body,
+		Link:      fmt.Sprintf("/entries/%d/relapse", entry.ID),
+		CreatedAt: time.Now(),
+	}
+	if DB.Create(&note).Error == nil && entryGone(entry.Username, entry.ID) {
+		DB.Delete(&note)
+	}
+}
+
+// GetRelapse returns the finished entry a new entry was linked to, with its growth summary
+func GetRelapse(c *gin.Context) {
+	username := c.GetString("username")
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", c.Param("id"), username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+	if entry.RelapseOfID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry is not linked to a past topic"})
+		return
+	}
+
+	var original DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", *entry.RelapseOfID, username).First(&original).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Original entry not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entryId": entry.ID,
+		"original": gin.H{
+			"id":            original.ID,
+			"title":         original.Title,
+			"createdAt":     original.CreatedAt,
+			"tags":          original.Tags,
+			"growthSummary": original.AIResponse,
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func relapseRouter(username string) *gin.Engine {
+	r := testRouter(username)
+	r.POST("/entries", CreateEntry)
+	r.GET("/entries/:id/relapse", GetRelapse)
+	return r
+}
+
+// writeEntry creates an entry through the API and waits for the background checks
+func writeEntry(t *testing.T, r *gin.Engine, body gin.H) DiaryEntry {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, "/entries", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d %s", w.Code, w.Body)
+	}
+	var entry DiaryEntry
+	decodeBody(t, w, &entry)
+	background.Wait()
+	DB.First(&entry, entry.ID)
+	return entry
+}
+
+func relapseNotes(username string) int64 {
+	var count int64
+	DB.Model(&Notification{}).Where("username = ? AND kind = ?", username, "relapse").Count(&count)
+	return count
+}
+
+func TestRelapseLinksByTag(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+	r := relapseRouter("alice")
+
+	original := DiaryEntry{Username: "alice", Title: "สอบ", Content: "กังวลเรื่องสอบ", Tags: []string{"สอบ"},
+		IsFinished: true, Status: "over_it", AIResponse: "คุณผ่านมาได้ด้วยการวางแผน", CreatedAt: time.Now().AddDate(0, -2, 0)}
+	DB.Create(&original)
+
+	entry := writeEntry(t, r, gin.H{"title": "สอบอีกแล้ว", "content": "เครียดมาก ไม่อยากไปโรงเรียน", "tags": []string{"สอบ"}})
+	if entry.RelapseOfID == nil || *entry.RelapseOfID != original.ID {
+		t.Fatalf("relapse of = %v, want %d", entry.RelapseOfID, original.ID)
+	}
+	var note Notification
+	if err := DB.Where("username = ? AND kind = ?", "alice", "relapse").First(&note).Error; err != nil {
+		t.Fatal("no notification was left")
+	}
+	if note.Link != fmt.Sprintf("/entries/%d/relapse", entry.ID) {
+		t.Errorf("link = %q", note.Link)
+	}
+
+	var resp struct {
+		Original struct {
+			ID            uint   `json:"id"`
+			GrowthSummary string `json:"growthSummary"`
+		} `json:"original"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, fmt.Sprintf("/entries/%d/relapse", entry.ID), nil), &resp)
+	if resp.Original.ID != original.ID || resp.Original.GrowthSummary != original.AIResponse {
+		t.Errorf("relapse = %+v", resp.Original)
+	}
+
+	// Another user can't see the link
+	if w := doJSON(relapseRouter("bob"), http.MethodGet, fmt.Sprintf("/entries/%d/relapse", entry.ID), nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user: status = %d", w.Code)
+	}
+}
+
+func TestRelapseLinksBySimilarityAfterIndexing(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+	r := relapseRouter("alice")
+
+	// Written through the API so it is indexed like any other entry
+	original := writeEntry(t, r, gin.H{"title": "หัวหน้า", "content": "หัวหน้าดุต่อหน้าทุกคน อยากลาออก"})
+	DB.Model(&original).Updates(map[string]interface{}{"is_finished": true, "status": "over_it", "created_at": time.Now().AddDate(0, -1, 0)})
+
+	// No tags: only the embedding of the new entry can find the old one, so it
+	// must be indexed before the check runs
+	entry := writeEntry(t, r, gin.H{"title": "หัวหน้า", "content": "หัวหน้าดุต่อหน้าทุกคนอีกแล้ว อยากลาออก"})
+	if entry.RelapseOfID == nil || *entry.RelapseOfID != original.ID {
+		t.Fatalf("relapse of = %v, want %d", entry.RelapseOfID, original.ID)
+	}
+	if relapseNotes("alice") != 1 {
+		t.Errorf("%d notifications", relapseNotes("alice"))
+	}
+}
+
+func TestRelapseSkipsUnfinishedAndUnrelatedEntries(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+	createAccount(t, "bob")
+	r := relapseRouter("alice")
+
+	past := time.Now().AddDate(0, -1, 0)
+	DB.Create(&DiaryEntry{Username: "alice", Title: "สอบ", Content: "ยังกังวลเรื่องสอบ", Tags: []string{"สอบ"}, Status: "still_dealing", CreatedAt: past})
+	DB.Create(&DiaryEntry{Username: "bob", Title: "สอบ", Content: "กังวลเรื่องสอบ", Tags: []string{"สอบ"}, IsFinished: true, CreatedAt: past})
+	DB.Create(&DiaryEntry{Username: "alice", Title: "ทะเล", Content: "ไปเที่ยวทะเลกับครอบครัว", Tags: []string{"ครอบครัว"}, IsFinished: true, CreatedAt: past})
+
+	entry := writeEntry(t, r, gin.H{"title": "สอบ", "content": "กังวลเรื่องสอบพรุ่งนี้", "tags": []string{"สอบ"}})
+	if entry.RelapseOfID != nil {
+		t.Errorf("linked to %d", *entry.RelapseOfID)
+	}
+	if relapseNotes("alice") != 0 {
+		t.Errorf("%d notifications", relapseNotes("alice"))
+	}
+	if w := doJSON(r, http.MethodGet, fmt.Sprintf("/entries/%d/relapse", entry.ID), nil); w.Code != http.StatusNotFound {
+		t.Errorf("relapse of unlinked entry: status = %d", w.Code)
+	}
+}
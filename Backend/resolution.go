@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxTags      = 10
+	maxTagLength = 30
+)
+
+// normalizeTags trims, lowercases and de-duplicates entry tags
+func normalizeTags(raw []string) ([]string, error) {
+	if len(raw) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, t := range raw {
+		t = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(t), "#")))
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// resolutionGroup summarizes how fast the entries in one group reached over_it
+type resolutionGroup struct {
+	Key           string   `json:"key"`
+	Label         string   `json:"label,omitempty"`
+	Resolved      int      `json:"resolved"`
+	Open          int      `json:"open"` // Reflected on but not over_it yet
+	AverageDays   *float64 `json:"averageDays"`
+	MedianDays    *float64 `json:"medianDays"`
+	AverageRounds *float64 `json:"averageRounds"`
+
+	days   []float64
+	rounds []int
+}
+
+func (g *resolutionGroup) add(e DiaryEntry) {
+	if days, ok := resolutionDays(e); ok {
+		g.Resolved++
+		g.days = append(g.days, days)
+		g.rounds = append(g.rounds, resolutionRounds(e))
+	} else if len(e.Reflections) > 0 {
+		g.Open++
+	}
+}
+
+func (g *resolutionGroup) finish() {
+	if len(g.days) == 0 {
+		return
+	}
+	var daySum float64
+	roundSum := 0
+	for i, d := range g.days {
+		daySum += d
+		roundSum += g.rounds[i]
+	}
+	avg := round1(daySum / float64(len(g.days)))
+	rounds := round1(float64(roundSum) / float64(len(g.rounds)))
+
+	sorted := append([]float64(nil), g.days...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	median = round1(median)
+
+	g.AverageDays, g.MedianDays, g.AverageRounds = &avg, &median, &rounds
+}
+
+// groupList returns the groups with data, most resolved first
+func groupList(groups map[string]*resolutionGroup) []*resolutionGroup {
+	list := []*resolutionGroup{}
+	for _, g := range groups {
+		if g.Resolved+g.Open == 0 {
+			continue
+		}
+		g.finish()
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Resolved != list[j].Resolved {
+			return list[i].Resolved > list[j].Resolved
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+// GetResolutionStats reports how long entries take from creation to over_it and how many
+// reflection rounds they need, overall and by mood, tag and creation period.
+// Accepts the same from/to/tz/bucket parameters as /stats/timeseries (bucket defaults to month).
+func GetResolutionStats(c *gin.Context) {
+	username := c.GetString("username")
+
+	loc, err := userLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("from") == "" {
+		// Recovery trends need more than the usual 30 days
+		start = end.AddDate(-1, 0, 0)
+	}
+
+	bucket := c.DefaultQuery("bucket", "month")
+	if bucket != "day" && bucket != "week" && bucket != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be day, week or month"})
+		return
+	}
+
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).
+		Where("username = ? AND is_draft = ? AND created_at >= ? AND created_at < ?", username, false, start, end).
+		Order("created_at asc").Find(&entries)
+
+	overall := &resolutionGroup{Key: "all"}
+	byMood := make(map[string]*resolutionGroup)
+	byTag := make(map[string]*resolutionGroup)
+	byPeriod := make(map[string]*resolutionGroup)
+	var periods []string
+
+	for _, e := range entries {
+		overall.add(e)
+
+		mood, label := e.Mood, ""
+		if e.MoodDetail.Primary != "" {
+			mood, label = e.MoodDetail.Primary, moodTaxonomy[e.MoodDetail.Primary].Label
+		}
+		if mood != "" {
+			if byMood[mood] == nil {
+				byMood[mood] = &resolutionGroup{Key: mood, Label: label}
+			}
+			byMood[mood].add(e)
+		}
+
+		for _, t := range e.Tags {
+			if byTag[t] == nil {
+				byTag[t] = &resolutionGroup{Key: t}
+			}
+			byTag[t].add(e)
+		}
+
+		period := bucketStart(e.CreatedAt, bucket, loc).Format("2006-01-02")
+		if byPeriod[period] == nil {
+			byPeriod[period] = &resolutionGroup{Key: period}
+			periods = append(periods, period)
+		}
+		byPeriod[period].add(e)
+	}
+	overall.finish()
+
+	// Periods stay in time order so the trend reads left to right
+	periodList := []*resolutionGroup{}
+	for _, p := range periods {
+		byPeriod[p].finish()
+		periodList = append(periodList, byPeriod[p])
+	}
+
+	// Trend compares the first and the latest period that had resolutions (negative = faster)
+	var first, last *resolutionGroup
+	for _, g := range periodList {
+		if g.AverageDays == nil {
+			continue
+		}
+		if first == nil {
+			first = g
+		}
+		last = g
+	}
+	trend := gin.H{"improving": false}
+	if first != nil && first != last {
+		change := round1(*last.AverageDays - *first.AverageDays)
+		trend = gin.H{
+			"from":       first.Key,
+			"to":         last.Key,
+			"changeDays": change,
+			"improving":  change < 0,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timezone": loc.String(),
+		"from":     start.Format("2006-01-02"),
+		"to":       end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"bucket":   bucket,
+		"overall":  overall,
+		"byMood":   groupList(byMood),
+		"byTag":    groupList(byTag),
+		"byPeriod": periodList,
+		"trend":    trend,
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scorer turns a user's entries into a 0-100 wellbeing score (higher = better)
+type Scorer interface {
+	Name() string
+	Score(entries []DiaryEntry, now time.Time) ScoreResult
+}
+
+// ScoreFactor explains how much one aspect moved the score
+type ScoreFactor struct {
+	Key    string  `json:"key"`
+	Label  string  `json:"label"`
+	Impact float64 `json:"impact"` // Points added to (or taken from) the score
+	Detail string  `json:"detail"`
+}
+
+type ScoreResult struct {
+	Score   int           `json:"score"`
+	Factors []ScoreFactor `json:"factors"`
+}
+
+// ScoreSnapshot stores one score per user per day so changes can be charted
+type ScoreSnapshot struct {
+	ID        uint          `json:"id" gorm:"primaryKey"`
+	Username  string        `json:"username" gorm:"uniqueIndex:idx_score_user_date"`
+	Date      string        `json:"date" gorm:"uniqueIndex:idx_score_user_date"` // YYYY-MM-DD in APP_TIMEZONE
+	Score     int           `json:"score"`
+	Scorer    string        `json:"scorer"`
+	Factors   []ScoreFactor `json:"factors" gorm:"serializer:json"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+var scorer Scorer
+
+// initScorer picks the scorer from SCORER (time_weighted or legacy)
+func initScorer() {
+	switch strings.ToLower(os.Getenv("SCORER")) {
+	case "legacy":
+		scorer = &legacyScorer{}
+	default:
+		halfLife, _ := strconv.Atoi(os.Getenv("SCORE_HALF_LIFE_DAYS"))
+		if halfLife <= 0 {
+			halfLife = 14
+		}
+		scorer = &timeWeightedScorer{halfLife: time.Duration(halfLife) * 24 * time.Hour}
+	}
+}
+
+// --- Legacy: the original resolved/need_help ratio ---
+type legacyScorer struct{}
+
+func (l *legacyScorer) Name() string { return "legacy" }
+
+func (l *legacyScorer) Score(entries []DiaryEntry, _ time.Time) ScoreResult {
+	if len(entries) == 0 {
+		return ScoreResult{Score: 50}
+	}
+
+	resolved, needHelp := 0, 0
+	for _, e := range entries {
+		switch e.Status {
+		case "over_it", "still_dealing":
+			resolved++
+		case "need_help":
+			needHelp++
+		}
+	}
+
+	base := float64(resolved*100) / float64(len(entries))
+	penalty := float64(needHelp * 10)
+	return ScoreResult{
+		Score: clampScore(base - penalty),
+		Factors: []ScoreFactor{
+			{Key: "resolved", Label: "เรื่องที่จัดการได้", Impact: base, Detail: fmt.Sprintf("%d จาก %d รายการ", resolved, len(entries))},
+			{Key: "needHelp", Label: "ไม่ไหวช่วยด้วย", Impact: -penalty, Detail: fmt.Sprintf("%d รายการ", needHelp)},
+		},
+	}
+}
+
+// --- Time-weighted: recent entries count more ---
+type timeWeightedScorer struct {
+	halfLife time.Duration
+}
+
+func (t *timeWeightedScorer) Name() string { return "time_weighted" }
+
+// statusValue is how well an entry is going, from 0 (struggling) to 1 (resolved)
+func statusValue(e DiaryEntry) float64 {
+	switch e.Status {
+	case "over_it":
+		return 1
+	case "still_dealing":
+		return 0.6
+	case "need_help":
+		return 0.1
+	default:
+		return 0.5 // Not reflected on yet: neutral
+	}
+}
+
+func (t *timeWeightedScorer) weight(e DiaryEntry, now time.Time) float64 {
+	age := now.Sub(e.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(t.halfLife))
+}
+
+func (t *timeWeightedScorer) Score(entries []DiaryEntry, now time.Time) ScoreResult {
+	if len(entries) == 0 {
+		return ScoreResult{Score: 50, Factors: []ScoreFactor{
+			{Key: "noData", Label: "ยังไม่มีข้อมูล", Impact: 0, Detail: "เริ่มต้นที่ 50 คะแนน"},
+		}}
+	}
+
+	var weightSum, statusSum float64
+	var moodWeight, moodSum float64
+	var resolvedDays []float64
+	maxStreak := 0
+
+	for _, e := range entries {
+		w := t.weight(e, now)
+		weightSum += w
+		statusSum += w * statusValue(e)
+
+		// Strong unpleasant feelings pull the score down, pleasant ones lift it
+		if e.MoodDetail.Intensity > 0 {
+			moodWeight += w
+			moodSum += w * e.MoodDetail.Valence * float64(e.MoodDetail.Intensity) / 10
+		}
+
+		if e.Status == "over_it" {
+			if d, ok := resolutionDays(e); ok {
+				resolvedDays = append(resolvedDays, d)
+			}
+		}
+
+		// Only streaks from the last two weeks still matter
+		if now.Sub(e.CreatedAt) < 14*24*time.Hour && e.NeedHelpCount > maxStreak {
+			maxStreak = e.NeedHelpCount
+		}
+	}
+
+	var factors []ScoreFactor
+
+	base := statusSum / weightSum * 100
+	factors = append(factors, ScoreFactor{
+		Key:    "status",
+		Label:  "สถานะของเรื่องต่างๆ (เน้นช่วงหลัง)",
+		Impact: round1(base),
+		Detail: fmt.Sprintf("ถ่วงน้ำหนักให้บันทึกล่าสุด ครึ่งชีวิต %d วัน", int(t.halfLife.Hours()/24)),
+	})
+	score := base
+
+	if moodWeight > 0 {
+		impact := moodSum / moodWeight * 10
+		score += impact
+		factors = append(factors, ScoreFactor{
+			Key:    "moodIntensity",
+			Label:  "ความรุนแรงของอารมณ์",
+			Impact: round1(impact),
+			Detail: "อารมณ์ด้านลบที่รุนแรงลดคะแนน อารมณ์ด้านบวกเพิ่มคะแนน",
+		})
+	}
+
+	if len(resolvedDays) > 0 {
+		var sum float64
+		for _, d := range resolvedDays {
+			sum += d
+		}
+		avg := sum / float64(len(resolvedDays))
+		// +5 when things resolve within a day, -5 when they take three weeks or more
+		impact := math.Max(-5, math.Min(5, 5-avg*10/21))
+		score += impact
+		factors = append(factors, ScoreFactor{
+			Key:    "resolutionTime",
+			Label:  "เวลาที่ใช้ก้าวผ่านเรื่องต่างๆ",
+			Impact: round1(impact),
+			Detail: fmt.Sprintf("เฉลี่ย %.1f วันจนถึง 'เรื่องจิ๊บจ๊อย' (%d เรื่อง)", avg, len(resolvedDays)),
+		})
+	}
+
+	if maxStreak >= 2 {
+		impact := -math.Min(20, float64(maxStreak-1)*5)
+		score += impact
+		factors = append(factors, ScoreFactor{
+			Key:    "needHelpStreak",
+			Label:  "ขอความช่วยเหลือติดต่อกัน",
+			Impact: impact,
+			Detail: fmt.Sprintf("เลือก 'ไม่ไหว ช่วยด้วย' %d ครั้งติดกันในช่วง 2 สัปดาห์", maxStreak),
+		})
+	}
+
+	return ScoreResult{Score: clampScore(score), Factors: factors}
+}
+
+// orderedReflections preloads reflections oldest first, the order resolutionDays
+// and resolutionRounds count them in
+func orderedReflections(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at asc").Order("id asc")
+}
+
+// resolutionDays is how long an entry took to reach over_it (Reflections must be preloaded in order)
+func resolutionDays(e DiaryEntry) (float64, bool) {
+	for _, h := range e.Reflections {
+		if h.Status == "over_it" {
+			return h.CreatedAt.Sub(e.CreatedAt).Hours() / 24, true
+		}
+	}
+	return 0, false
+}
+
+// resolutionRounds is how many reflections it took to reach over_it (Reflections must be preloaded in order)
+func resolutionRounds(e DiaryEntry) int {
+	for i, h := range e.Reflections {
+		if h.Status == "over_it" {
+			return i + 1
+		}
+	}
+	return len(e.Reflections)
+}
+
+func clampScore(v float64) int {
+	return int(math.Round(math.Max(0, math.Min(100, v))))
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// --- Snapshots ---
+
+// snapshotDate is today's date in the app time zone
+func snapshotDate(now time.Time) string {
+	return now.In(appLocation()).Format("2006-01-02")
+}
+
+// saveScoreSnapshot stores (or replaces) today's score for the user
+func saveScoreSnapshot(username string, result ScoreResult, now time.Time) {
+	snapshot := ScoreSnapshot{
+		Username:  username,
+		Date:      snapshotDate(now),
+		Score:     result.Score,
+		Scorer:    scorer.Name(),
+		Factors:   result.Factors,
+		CreatedAt: now,
+	}
+	err := DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"score", "scorer", "factors", "created_at"}),
+	}).Create(&snapshot).Error
+	if err != nil {
+		log.Printf("Failed to save score snapshot for %s: %v", username, err)
+	}
+}
+
+// scoreUser loads the user's entries and scores them
+func scoreUser(username string, now time.Time) ScoreResult {
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).Where("username = ? AND is_draft = ?", username, false).Find(&entries)
+	return scorer.Score(entries, now)
+}
+
+// runScoreSnapshots stores today's snapshot for every user who writes and has none yet
+func runScoreSnapshots(now time.Time) {
+	today := snapshotDate(now)
+
+	var usernames []string
+	DB.Model(&DiaryEntry{}).Distinct().Pluck("username", &usernames)
+	for _, username := range usernames {
+		var count int64
+		DB.Model(&ScoreSnapshot{}).Where("username = ? AND date = ?", username, today).Count(&count)
+		if count == 0 {
+			saveScoreSnapshot(username, scoreUser(username, now), now)
+		}
+	}
+}
+
+// startScoreSnapshots stores a daily snapshot for every user who writes,
+// even on days they do not open the summary page, until ctx is done
+func startScoreSnapshots(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		runScoreSnapshots(time.Now())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runScoreSnapshots(now)
+			}
+		}
+	}()
+}
+
+// --- Handlers ---
+
+// GetScore returns the current score with the factors behind it
+func GetScore(c *gin.Context) {
+	username := c.GetString("username")
+	now := time.Now()
+
+	result := scoreUser(username, now)
+	saveScoreSnapshot(username, result, now)
+
+	c.JSON(http.StatusOK, gin.H{
+		"score":   result.Score,
+		"factors": result.Factors,
+		"scorer":  scorer.Name(),
+	})
+}
+
+// GetScoreHistory returns daily score snapshots, oldest first
+func GetScoreHistory(c *gin.Context) {
+	username := c.GetString("username")
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "90"))
+	if days <= 0 || days > 730 {
+		days = 90
+	}
+	// Snapshot dates are days in the app time zone
+	since := snapshotDate(time.Now().AddDate(0, 0, -days))
+
+	var snapshots []ScoreSnapshot
+	DB.Where("username = ? AND date >= ?", username, since).Order("date asc").Find(&snapshots)
+
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScoreHistoryUsesAppDates(t *testing.T) {
+	// One zone ahead of UTC and one behind, so at any hour one of them is on
+	// another date than the server
+	for _, zone := range []string{"Pacific/Kiritimati", "Etc/GMT+12"} {
+		t.Run(zone, func(t *testing.T) {
+			setupTestDB(t)
+			t.Setenv("APP_TIMEZONE", zone)
+			now := time.Now()
+			yesterday := snapshotDate(now.AddDate(0, 0, -1))
+			DB.Create(&ScoreSnapshot{Username: "alice", Date: snapshotDate(now.AddDate(0, 0, -2)), Score: 10})
+			DB.Create(&ScoreSnapshot{Username: "alice", Date: yesterday, Score: 20})
+			DB.Create(&ScoreSnapshot{Username: "alice", Date: snapshotDate(now), Score: 30})
+
+			r := testRouter("alice")
+			r.GET("/score/history", GetScoreHistory)
+			var resp struct {
+				Snapshots []ScoreSnapshot `json:"snapshots"`
+			}
+			decodeBody(t, doJSON(r, http.MethodGet, "/score/history?days=1", nil), &resp)
+			if len(resp.Snapshots) != 2 || resp.Snapshots[0].Date != yesterday {
+				t.Errorf("snapshots = %+v", resp.Snapshots)
+			}
+		})
+	}
+}
+
+func TestResolutionRoundsCountReflectionsInOrder(t *testing.T) {
+	setupTestDB(t)
+	start := time.Now().AddDate(0, 0, -10)
+	entry := DiaryEntry{Username: "alice", Status: "over_it", CreatedAt: start}
+	DB.Create(&entry)
+	// Stored out of order: the final over_it comes first in the table
+	DB.Create(&ReflectionHistory{DiaryEntryID: entry.ID, Status: "over_it", CreatedAt: start.AddDate(0, 0, 6)})
+	DB.Create(&ReflectionHistory{DiaryEntryID: entry.ID, Status: "still_dealing", CreatedAt: start.AddDate(0, 0, 2)})
+	DB.Create(&ReflectionHistory{DiaryEntryID: entry.ID, Status: "still_dealing", CreatedAt: start.AddDate(0, 0, 4)})
+
+	var loaded DiaryEntry
+	DB.Preload("Reflections", orderedReflections).First(&loaded, entry.ID)
+	if rounds := resolutionRounds(loaded); rounds != 3 {
+		t.Errorf("rounds = %d, want 3", rounds)
+	}
+	if days, ok := resolutionDays(loaded); !ok || days < 5.9 || days > 6.1 {
+		t.Errorf("days = %f, %v", days, ok)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+	_ "time/tzdata" // The alpine runtime image has no zoneinfo
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxTimeseriesBuckets = 400
+
+// appLocation is the default time zone: APP_TIMEZONE, or Bangkok
+func appLocation() *time.Location {
+	name := os.Getenv("APP_TIMEZONE")
+	if name == "" {
+		name = "Asia/Bangkok"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// userLocation reads ?tz= (IANA name), falling back to appLocation
+func userLocation(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return appLocation(), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone: %s", name)
+	}
+	return loc, nil
+}
+
+// parseDateRange reads ?from= and ?to= (YYYY-MM-DD, inclusive) in the given zone.
+// The returned end is exclusive. Defaults to the last 30 days.
+func parseDateRange(c *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	end := today.AddDate(0, 0, 1)
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+
+	start := end.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		start = t
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return start, end, nil
+}
+
+// bucketStart truncates t to the start of its day, week (Monday) or month in loc
+func bucketStart(t time.Time, bucket string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	switch bucket {
+	case "week":
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return day
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+type timeseriesPoint struct {
+	Start                      string         `json:"start"`
+	Entries                    int            `json:"entries"`
+	Moods                      map[string]int `json:"moods"`
+	Emotions                   map[string]int `json:"emotions"`
+	StatusTransitions          map[string]int `json:"statusTransitions"`
+	AverageIntensity           *float64       `json:"averageIntensity"`
+	AverageReflectionIntensity *float64       `json:"averageReflectionIntensity"`
+
+	intensitySum, intensityN, reflectSum, reflectN int
+}
+
+// GetTimeseries returns entry counts, moods, status transitions and intensity per bucket
+func GetTimeseries(c *gin.Context) {
+	username := c.GetString("username")
+
+	loc, err := userLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bucket := c.DefaultQuery("bucket", "day")
+	if bucket != "day" && bucket != "week" && bucket != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be day, week or month"})
+		return
+	}
+
+	// Build every bucket up front so charts get a continuous axis
+	var points []*timeseriesPoint
+	index := make(map[time.Time]*timeseriesPoint)
+	for t := bucketStart(start, bucket, loc); t.Before(end); t = nextBucket(t, bucket) {
+		if len(points) >= maxTimeseriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Date range is too long for this bucket size"})
+			return
+		}
+		p := &timeseriesPoint{
+			Start:             t.Format("2006-01-02"),
+			Moods:             map[string]int{},
+			Emotions:          map[string]int{},
+			StatusTransitions: map[string]int{},
+		}
+		points = append(points, p)
+		index[t] = p
+	}
+
+	var entries []DiaryEntry
+	DB.Where("username = ? AND is_draft = ? AND created_at >= ? AND created_at < ?", username, false, start, end).Find(&entries)
+	for _, e := range entries {
+		p := index[bucketStart(e.CreatedAt, bucket, loc)]
+		if p == nil {
+			continue
+		}
+		p.Entries++
+		if e.Mood != "" {
+			p.Moods[e.Mood]++
+		}
+		if e.MoodDetail.Primary != "" {
+			p.Emotions[e.MoodDetail.Primary]++
+		}
+		if e.MoodDetail.Intensity > 0 {
+			p.intensitySum += e.MoodDetail.Intensity
+			p.intensityN++
+		}
+	}
+
+	// Reflections before the range are needed to know the status an entry moved from
+	var history []ReflectionHistory
+	DB.Joins("JOIN diary_entries ON diary_entries.id = reflection_histories.diary_entry_id").
+		Where("diary_entries.username = ? AND reflection_histories.created_at < ?", username, end).
+		Order("reflection_histories.created_at asc").
+		Find(&history)
+
+	lastStatus := make(map[uint]string)
+	for _, h := range history {
+		from := lastStatus[h.DiaryEntryID]
+		if from == "" {
+			from = "pending"
+		}
+		lastStatus[h.DiaryEntryID] = h.Status
+
+		if h.CreatedAt.Before(start) {
+			continue
+		}
+		p := index[bucketStart(h.CreatedAt, bucket, loc)]
+		if p == nil {
+			continue
+		}
+		p.StatusTransitions[from+"→"+h.Status]++
+		if h.MoodDetail.Intensity > 0 {
+			p.reflectSum += h.MoodDetail.Intensity
+			p.reflectN++
+		}
+	}
+
+	for _, p := range points {
+		if p.intensityN > 0 {
+			avg := float64(p.intensitySum) / float64(p.intensityN)
+			p.AverageIntensity = &avg
+		}
+		if p.reflectN > 0 {
+			avg := float64(p.reflectSum) / float64(p.reflectN)
+			p.AverageReflectionIntensity = &avg
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bucket":   bucket,
+		"timezone": loc.String(),
+		"from":     start.Format("2006-01-02"),
+		"to":       end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"points":   points,
+	})
+}
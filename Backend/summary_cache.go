@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummaryCache keeps the last AI summary of each user together with the
+// digest of the data it was built from
+type SummaryCache interface {
+	Get(username string) (digest string, summary gin.H, ok bool)
+	Set(username, digest string, summary gin.H)
+	Invalidate(username string)
+}
+
+var summaryCache SummaryCache
+
+// initSummaryCache picks the backend from SUMMARY_CACHE (memory or redis)
+func initSummaryCache() {
+	switch strings.ToLower(os.Getenv("SUMMARY_CACHE")) {
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		summaryCache = newRedisSummaryCache(addr, os.Getenv("REDIS_PASSWORD"), 24*time.Hour)
+		log.Printf("Summary cache: redis at %s", addr)
+	default:
+		size, _ := strconv.Atoi(os.Getenv("SUMMARY_CACHE_SIZE"))
+		if size <= 0 {
+			size = 1000
+		}
+		summaryCache = newMemorySummaryCache(size)
+	}
+}
+
+// summaryDigest hashes everything the summary depends on: the entries and
+// their reflections as the prompt and the scorer read them. The score decays
+// and its streak window moves with time, so the day is part of it too.
+func summaryDigest(entries []DiaryEntry, day string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", day)
+	for _, e := range entries {
+		fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s\x00%s\x00%s\x00%s\x00%d\x00%t\x00%s\x00",
+			e.ID, e.CreatedAt.UnixNano(), e.Title, e.Content, e.Reflection, e.Status, e.AIResponse,
+			e.NeedHelpCount, e.IsFinished, e.Mood)
+		writeMoodDigest(h, e.MoodDetail)
+		fmt.Fprintf(h, "%d\x00", len(e.Reflections))
+		for _, r := range e.Reflections {
+			fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s\x00%s\x00", r.ID, r.CreatedAt.UnixNano(), r.Content, r.Status, r.AIResponse)
+			writeMoodDigest(h, r.MoodDetail)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeMoodDigest(w io.Writer, m MoodState) {
+	fmt.Fprintf(w, "%s\x00%s\x00%g\x00%g\x00%d\x00", m.Primary, strings.Join(m.Secondary, ","), m.Valence, m.Arousal, m.Intensity)
+}
+
+// --- In-memory LRU ---
+type memorySummaryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front = most recently used
+	items    map[string]*list.Element
+}
+
+type summaryCacheItem struct {
+	username string
+	digest   string
+	summary  gin.H
+}
+
+func newMemorySummaryCache(capacity int) *memorySummaryCache {
+	return &memorySummaryCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *memorySummaryCache) Get(username string) (string, gin.H, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[username]
+	if !ok {
+		return "", nil, false
+	}
+	m.order.MoveToFront(el)
+	item := el.Value.(*summaryCacheItem)
+	return item.digest, item.summary, true
+}
+
+func (m *memorySummaryCache) Set(username, digest string, summary gin.H) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[username]; ok {
+		el.Value = &summaryCacheItem{username, digest, summary}
+		m.order.MoveToFront(el)
+		return
+	}
+
+	m.items[username] = m.order.PushFront(&summaryCacheItem{username, digest, summary})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*summaryCacheItem).username)
+	}
+}
+
+func (m *memorySummaryCache) Invalidate(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[username]; ok {
+		m.order.Remove(el)
+		delete(m.items, username)
+	}
+}
+
+// --- Redis-compatible store (RESP over TCP) for multi-instance deploys ---
+type redisSummaryCache struct {
+	addr     string
+	password string
+	ttl      time.Duration
+	idle     chan *redisConn // Open connections waiting for the next command
+}
+
+// redisConn is one authenticated connection of the pool
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisPoolSize is how many idle connections are kept open
+const redisPoolSize = 4
+
+func newRedisSummaryCache(addr, password string, ttl time.Duration) *redisSummaryCache {
+	return &redisSummaryCache{addr: addr, password: password, ttl: ttl, idle: make(chan *redisConn, redisPoolSize)}
+}
+
+type redisSummaryValue struct {
+	Digest  string `json:"digest"`
+	Summary gin.H  `json:"summary"`
+}
+
+func (r *redisSummaryCache) key(username string) string {
+	return "yesterdays-me:summary:" + username
+}
+
+func (r *redisSummaryCache) Get(username string) (string, gin.H, bool) {
+	reply, err := r.do("GET", r.key(username))
+	if err != nil {
+		log.Printf("Summary cache GET failed: %v", err)
+		return "", nil, false
+	}
+	raw, ok := reply.(string)
+	if !ok {
+		return "", nil, false
+	}
+
+	var value redisSummaryValue
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return "", nil, false
+	}
+	return value.Digest, value.Summary, true
+}
+
+func (r *redisSummaryCache) Set(username, digest string, summary gin.H) {
+	raw, err := json.Marshal(redisSummaryValue{Digest: digest, Summary: summary})
+	if err != nil {
+		return
+	}
+	if _, err := r.do("SET", r.key(username), string(raw), "EX", strconv.Itoa(int(r.ttl.Seconds()))); err != nil {
+		log.Printf("Summary cache SET failed: %v", err)
+	}
+}
+
+func (r *redisSummaryCache) Invalidate(username string) {
+	if _, err := r.do("DEL", r.key(username)); err != nil {
+		log.Printf("Summary cache DEL failed: %v", err)
+	}
+}
+
+// do sends one command on a pooled connection and reads the reply
+func (r *redisSummaryCache) do(args ...string) (interface{}, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reply, err := c.roundTrip(args...)
+	if err != nil {
+		// The connection may be half way through a reply; don't reuse it.
+		// Error replies from the server leave it in a clean state.
+		if _, serverErr := err.(redisError); !serverErr {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+	r.put(c)
+	return reply, err
+}
+
+// get takes an idle connection or dials and authenticates a new one
+func (r *redisSummaryCache) get() (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", r.addr, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if r.password != "" {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.roundTrip("AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (r *redisSummaryCache) put(c *redisConn) {
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *redisConn) roundTrip(args ...string) (interface{}, error) {
+	if err := writeRESP(c.conn, args...); err != nil {
+		return nil, err
+	}
+	return readRESP(c.reader)
+}
+
+// redisError is an error reply sent by the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func writeRESP(w io.Writer, args ...string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// readRESP parses simple strings, errors, integers and bulk strings (nil for a missing key)
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	default:
+		return nil, fmt.Errorf("unsupported redis reply: %q", line)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemorySummaryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newMemorySummaryCache(2)
+	cache.Set("alice", "a1", gin.H{"n": 1})
+	cache.Set("bob", "b1", gin.H{"n": 2})
+
+	// Reading alice makes bob the oldest
+	if digest, _, ok := cache.Get("alice"); !ok || digest != "a1" {
+		t.Fatalf("alice = %q, %v", digest, ok)
+	}
+	cache.Set("carol", "c1", gin.H{"n": 3})
+	if _, _, ok := cache.Get("bob"); ok {
+		t.Error("bob was not evicted")
+	}
+
+	// Replacing a value also counts as a use
+	cache.Set("alice", "a2", gin.H{"n": 4})
+	cache.Set("dave", "d1", gin.H{"n": 5})
+	if _, _, ok := cache.Get("carol"); ok {
+		t.Error("carol was not evicted")
+	}
+	if digest, summary, ok := cache.Get("alice"); !ok || digest != "a2" || summary["n"] != 4 {
+		t.Errorf("alice = %q %v, %v", digest, summary, ok)
+	}
+
+	cache.Invalidate("alice")
+	if _, _, ok := cache.Get("alice"); ok || cache.order.Len() != 1 {
+		t.Errorf("after invalidate: %d items", cache.order.Len())
+	}
+}
+
+func TestReadRESP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+		err  string
+	}{
+		{"+OK\r\n", "OK", ""},
+		{":42\r\n", int64(42), ""},
+		{"$5\r\nhello\r\n", "hello", ""},
+		{"$7\r\nline\r\n2\r\n", "line\r\n2", ""},
+		{"$0\r\n\r\n", "", ""},
+		{"$-1\r\n", nil, ""},
+		{"-WRONGPASS invalid password\r\n", nil, "redis: WRONGPASS invalid password"},
+		{"*1\r\n$1\r\na\r\n", nil, "unsupported redis reply"},
+		{"$5\r\nhi\r\n", nil, "EOF"},
+		{"\r\n", nil, "empty redis reply"},
+	}
+	for _, tt := range tests {
+		got, err := readRESP(bufio.NewReader(strings.NewReader(tt.in)))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeRESP(&buf, "SET", "k", "ค่า")
+	if want := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$9\r\nค่า\r\n"; buf.String() != want {
+		t.Errorf("writeRESP = %q, want %q", buf.String(), want)
+	}
+}
+
+// fakeRedis serves GET, SET, DEL and AUTH from a map and counts connections
+type fakeRedis struct {
+	mu      sync.Mutex
+	data    map[string]string
+	dials   atomic.Int32
+	addr    string
+	authed  atomic.Int32
+	wantPwd string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{data: make(map[string]string), addr: ln.Addr().String(), wantPwd: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.dials.Add(1)
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+
+		f.mu.Lock()
+		switch args[0] {
+		case "AUTH":
+			if args[1] == f.wantPwd {
+				f.authed.Add(1)
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "GET":
+			if v, ok := f.data[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case "SET":
+			f.data[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case "DEL":
+			delete(f.data, args[1])
+			fmt.Fprint(conn, ":1\r\n")
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestRedisSummaryCacheReusesConnections(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	cache := newRedisSummaryCache(server.addr, "secret", time.Hour)
+
+	cache.Set("alice", "d1", gin.H{"summary": "ดีขึ้น"})
+	digest, summary, ok := cache.Get("alice")
+	if !ok || digest != "d1" || summary["summary"] != "ดีขึ้น" {
+		t.Fatalf("get = %q %v, %v", digest, summary, ok)
+	}
+	cache.Invalidate("alice")
+	if _, _, ok := cache.Get("alice"); ok {
+		t.Error("value survived invalidate")
+	}
+
+	if server.dials.Load() != 1 || server.authed.Load() != 1 {
+		t.Errorf("%d connections, %d logins for four commands", server.dials.Load(), server.authed.Load())
+	}
+
+	if _, _, ok := newRedisSummaryCache(server.addr, "wrong", time.Hour).Get("alice"); ok {
+		t.Error("wrong password was accepted")
+	}
+}
+
+func TestSummaryDigestCoversReflectionsAndMood(t *testing.T) {
+	base := func() []DiaryEntry {
+		at := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+		return []DiaryEntry{{
+			ID: 1, Title: "งาน", Content: "เหนื่อย", Status: "still_dealing", CreatedAt: at,
+			MoodDetail: MoodState{Primary: "sad", Valence: -0.7, Arousal: 0.3, Intensity: 6},
+			Reflections: []ReflectionHistory{
+				{ID: 1, Content: "ยังเหนื่อย", Status: "still_dealing", CreatedAt: at.Add(time.Hour)},
+			},
+		}}
+	}
+	want := summaryDigest(base(), "2026-05-02")
+	if summaryDigest(base(), "2026-05-02") != want {
+		t.Fatal("digest is not stable")
+	}
+
+	changes := map[string]func(e *DiaryEntry){
+		"primary emotion": func(e *DiaryEntry) { e.MoodDetail.Primary = "angry" },
+		"valence":         func(e *DiaryEntry) { e.MoodDetail.Valence = 0.5 },
+		"new reflection": func(e *DiaryEntry) {
+			e.Reflections = append(e.Reflections, ReflectionHistory{ID: 2, Status: "over_it"})
+		},
+		"reflection status":     func(e *DiaryEntry) { e.Reflections[0].Status = "over_it" },
+		"reflection time":       func(e *DiaryEntry) { e.Reflections[0].CreatedAt = e.Reflections[0].CreatedAt.Add(time.Hour) },
+		"reflection mood":       func(e *DiaryEntry) { e.Reflections[0].MoodDetail.Intensity = 3 },
+		"entry age":             func(e *DiaryEntry) { e.CreatedAt = e.CreatedAt.AddDate(0, 0, -7) },
+		"secondary emotions":    func(e *DiaryEntry) { e.MoodDetail.Secondary = []string{"anxious"} },
+		"reflection ai message": func(e *DiaryEntry) { e.Reflections[0].AIResponse = "สู้ๆ" },
+	}
+	for name, change := range changes {
+		entries := base()
+		change(&entries[0])
+		if summaryDigest(entries, "2026-05-02") == want {
+			t.Errorf("%s does not change the digest", name)
+		}
+	}
+	if summaryDigest(base(), "2026-05-03") == want {
+		t.Error("the day does not change the digest")
+	}
+}
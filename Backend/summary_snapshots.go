@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SummarySnapshot is a dated copy of one GetSummary result, AI text included
+type SummarySnapshot struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Username    string         `json:"username" gorm:"index"`
+	Digest      string         `json:"-"` // summaryDigest of the data it was built from
+	MentalScore int            `json:"mentalScore"`
+	MentalState string         `json:"mentalState"`
+	MentalEmoji string         `json:"mentalEmoji"`
+	Stats       map[string]int `json:"stats" gorm:"serializer:json"`
+	Factors     []ScoreFactor  `json:"scoreFactors" gorm:"serializer:json"`
+	AISummary   string         `json:"aiSummary"`
+	CreatedAt   time.Time      `json:"createdAt"`
+}
+
+// response renders the snapshot in the same shape GetSummary has always returned
+func (s SummarySnapshot) response() gin.H {
+	stats := gin.H{}
+	for k, v := range s.Stats {
+		stats[k] = v
+	}
+	return gin.H{
+		"stats":        stats,
+		"mentalScore":  s.MentalScore,
+		"scoreFactors": s.Factors,
+		"mentalState":  s.MentalState,
+		"mentalEmoji":  s.MentalEmoji,
+		"aiSummary":    s.AISummary,
+		"snapshotId":   s.ID,
+		"generatedAt":  s.CreatedAt,
+	}
+}
+
+// latestSummarySnapshot returns the newest snapshot of the user, if any
+func latestSummarySnapshot(username string) (SummarySnapshot, bool) {
+	var snapshot SummarySnapshot
+	err := DB.Where("username = ?", username).Order("created_at desc").Order("id desc").First(&snapshot).Error
+	return snapshot, err == nil
+}
+
+// GetSummarySnapshots lists past summaries, newest first
+func GetSummarySnapshots(c *gin.Context) {
+	limit, cursor, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	username := c.GetString("username")
+	var snapshots []SummarySnapshot
+	result := paginate(DB.Where("username = ?", username), cursor, limit, true).Find(&snapshots)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	n, next := nextPage(c, len(snapshots), limit, func(i int) (time.Time, uint) {
+		return snapshots[i].CreatedAt, snapshots[i].ID
+	})
+	snapshots = snapshots[:n]
+
+	c.JSON(http.StatusOK, pageBody(c, snapshots, next, limit))
+}
+
+// GetSummarySnapshot returns one past summary
+func GetSummarySnapshot(c *gin.Context) {
+	username := c.GetString("username")
+
+	var snapshot SummarySnapshot
+	if err := DB.Where("id = ? AND username = ?", c.Param("id"), username).First(&snapshot).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// CompareSummarySnapshots puts two summaries side by side with the changes between them.
+// ?from= and ?to= are snapshot IDs; "to" defaults to the latest snapshot.
+func CompareSummarySnapshots(c *gin.Context) {
+	username := c.GetString("username")
+
+	var from, to SummarySnapshot
+	if err := DB.Where("id = ? AND username = ?", c.Query("from"), username).First(&from).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot 'from' not found"})
+		return
+	}
+	if id := c.Query("to"); id != "" {
+		if err := DB.Where("id = ? AND username = ?", id, username).First(&to).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot 'to' not found"})
+			return
+		}
+	} else {
+		var ok bool
+		if to, ok = latestSummarySnapshot(username); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot 'to' not found"})
+			return
+		}
+	}
+
+	// Keep the older one on the left
+	if to.CreatedAt.Before(from.CreatedAt) {
+		from, to = to, from
+	}
+
+	statsChange := make(map[string]int)
+	for k, v := range to.Stats {
+		statsChange[k] = v - from.Stats[k]
+	}
+	for k, v := range from.Stats {
+		if _, ok := to.Stats[k]; !ok {
+			statsChange[k] = -v
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from": from,
+		"to":   to,
+		"changes": gin.H{
+			"days":         int(to.CreatedAt.Sub(from.CreatedAt).Hours() / 24),
+			"mentalScore":  to.MentalScore - from.MentalScore,
+			"stateChanged": to.MentalState != from.MentalState,
+			"stats":        statsChange,
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetSummaryReusesOnlyTodaysSnapshot(t *testing.T) {
+	setupTestDB(t)
+	r := testRouter("alice")
+	r.GET("/summary", GetSummary)
+
+	DB.Create(&DiaryEntry{Username: "alice", Title: "งาน", Content: "เหนื่อย", Status: "still_dealing", CreatedAt: time.Now().AddDate(0, 0, -3)})
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).Where("username = ?", "alice").Order("created_at desc").Find(&entries)
+
+	now := time.Now()
+	today := SummarySnapshot{Username: "alice", Digest: summaryDigest(entries, snapshotDate(now)), MentalScore: 77, AISummary: "วันนี้", CreatedAt: now}
+	DB.Create(&today)
+
+	var got struct {
+		SnapshotID  uint `json:"snapshotId"`
+		MentalScore int  `json:"mentalScore"`
+	}
+	decodeBody(t, doJSON(r, http.MethodGet, "/summary", nil), &got)
+	if got.SnapshotID != today.ID || got.MentalScore != 77 {
+		t.Errorf("same day: got snapshot %d with score %d, want %d", got.SnapshotID, got.MentalScore, today.ID)
+	}
+
+	// A snapshot with the same digest from an earlier day has a stale score
+	summaryCache.Invalidate("alice")
+	DB.Model(&today).Update("created_at", now.AddDate(0, 0, -1))
+	decodeBody(t, doJSON(r, http.MethodGet, "/summary", nil), &got)
+	if got.SnapshotID == today.ID || got.MentalScore == 77 {
+		t.Errorf("next day: reused snapshot %d with score %d", got.SnapshotID, got.MentalScore)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genai"
+)
+
+// Transcriber converts recorded speech into text
+type Transcriber interface {
+	Name() string
+	Transcribe(ctx context.Context, audio []byte, mimeType, language string) (string, error)
+}
+
+var transcriber Transcriber
+
+// initTranscriber picks the speech-to-text backend from TRANSCRIBER (gemini, whisper or fake)
+func initTranscriber() {
+	switch strings.ToLower(os.Getenv("TRANSCRIBER")) {
+	case "whisper":
+		url := os.Getenv("WHISPER_URL")
+		if url == "" {
+			url = "http://localhost:9000/v1/audio/transcriptions"
+		}
+		transcriber = &whisperTranscriber{url: url, model: os.Getenv("WHISPER_MODEL")}
+	case "fake":
+		transcriber = &fakeTranscriber{text: os.Getenv("FAKE_TRANSCRIPT")}
+	default:
+		transcriber = &geminiTranscriber{}
+	}
+	log.Printf("Transcriber: %s", transcriber.Name())
+}
+
+// --- Gemini audio ---
+type geminiTranscriber struct{}
+
+func (g *geminiTranscriber) Name() string { return "gemini" }
+
+func (g *geminiTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType, language string) (string, error) {
+	prompt := fmt.Sprintf(`Transcribe this voice diary recording word for word.
+Language: %s (Thai speakers often mix in English words; keep them as spoken).
+Return only the transcript text, without quotes, timestamps or commentary.`, language)
+
+	contents := []*genai.Content{
+		genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromText(prompt),
+			genai.NewPartFromBytes(audio, mimeType),
+		}, genai.RoleUser),
+	}
+	text, err := generateFromContents(ctx, contents)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// --- Whisper via a local OpenAI-compatible server (whisper.cpp, faster-whisper) ---
+type whisperTranscriber struct {
+	url   string
+	model string
+}
+
+func (w *whisperTranscriber) Name() string { return "whisper" }
+
+func (w *whisperTranscriber) Transcribe(ctx context.Context, audio []byte, mimeType, language string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", "entry"+audioExtension(mimeType))
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	model := w.model
+	if model == "" {
+		model = "whisper-1"
+	}
+	form.WriteField("model", model)
+	form.WriteField("language", language)
+	form.WriteField("response_format", "json")
+	form.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := (&http.Client{Timeout: 2 * time.Minute}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("whisper server returned %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+func audioExtension(mimeType string) string {
+	switch {
+	case strings.Contains(mimeType, "webm"):
+		return ".webm"
+	case strings.Contains(mimeType, "ogg"):
+		return ".ogg"
+	case strings.Contains(mimeType, "wav"):
+		return ".wav"
+	case strings.Contains(mimeType, "mp4"), strings.Contains(mimeType, "m4a"):
+		return ".m4a"
+	default:
+		return ".mp3"
+	}
+}
+
+// --- Fake for local development and tests ---
+type fakeTranscriber struct {
+	text string
+}
+
+func (f *fakeTranscriber) Name() string { return "fake" }
+
+func (f *fakeTranscriber) Transcribe(_ context.Context, audio []byte, _, _ string) (string, error) {
+	if f.text != "" {
+		return f.text, nil
+	}
+	return fmt.Sprintf("(ถอดเสียงทดสอบ %d ไบต์)", len(audio)), nil
+}
+
+// --- Handlers ---
+
+// CreateVoiceEntry stores a voice memo and creates a private draft entry from its
+// transcript. The lock only starts, and the entry can only be made public, once the
+// user has reviewed the text with ConfirmEntry.
+func CreateVoiceEntry(c *gin.Context) {
+	username := c.GetString("username")
+
+	data, declaredType, err := readUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		title = "บันทึกเสียง " + time.Now().Format("02/01/2006 15:04")
+	}
+	language := c.DefaultPostForm("language", "th")
+
+	// Same mood and tag rules as CreateEntry; moodDetail is a JSON form field
+	// and tags are repeated "tags" fields
+	tags, err := normalizeTags(c.PostFormArray("tags"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	moodEmoji := c.PostForm("mood")
+	var mood MoodState
+	if v := c.PostForm("moodDetail"); v != "" {
+		var input moodInput
+		if err := json.Unmarshal([]byte(v), &input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid moodDetail"})
+			return
+		}
+		if mood, err = input.toState(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		moodEmoji = mood.Emoji()
+	}
+
+	entry := DiaryEntry{
+		Username:   username,
+		Title:      title,
+		Mood:       moodEmoji,
+		MoodDetail: mood,
+		Tags:       tags,
+		IsDraft:    true,
+		UnlockAt:   time.Now(), // Readable while the transcript is being reviewed
+	}
+	if err := DB.Create(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, status, err := saveAttachment(entry, 0, data, declaredType)
+	if err == nil && attachment.Kind != "audio" {
+		DB.Delete(attachment)
+		deleteAttachmentFiles([]Attachment{*attachment})
+		status, err = http.StatusUnsupportedMediaType, fmt.Errorf("voice entries need an audio file")
+	}
+	if err != nil {
+		DB.Delete(&entry)
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A failed transcription still leaves a draft the user can type into
+	var warning string
+	transcript, err := transcriber.Transcribe(c.Request.Context(), data, attachment.ContentType, language)
+	if err != nil {
+		log.Printf("Transcription error: %v", err)
+		warning = "ถอดเสียงไม่สำเร็จ กรุณาพิมพ์ข้อความเอง"
+	}
+
+	entry.Transcript = transcript
+	entry.Content = transcript
+	DB.Save(&entry)
+	summaryCache.Invalidate(username)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"entry":      entry,
+		"attachment": attachment,
+		"warning":    warning,
+	})
+}
+
+// UpdateTranscript lets the user correct a draft voice entry before it is locked
+func UpdateTranscript(c *gin.Context) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var input struct {
+		Content string `json:"content" binding:"required"`
+		Title   string `json:"title"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+	if !entry.IsDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Entry is already confirmed"})
+		return
+	}
+
+	entry.Content = input.Content
+	if input.Title != "" {
+		entry.Title = input.Title
+	}
+	DB.Save(&entry)
+	summaryCache.Invalidate(username)
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// ConfirmEntry finishes a draft voice entry and starts the usual lock. The optional
+// body {"isPublic", "isAnonymous"} publishes it like CreateEntry does.
+func ConfirmEntry(c *gin.Context) {
+	id := c.Param("id")
+	username := c.GetString("username")
+
+	var input struct {
+		IsPublic    bool `json:"isPublic"`
+		IsAnonymous bool `json:"isAnonymous"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entry DiaryEntry
+	if err := DB.Where("id = ? AND username = ?", id, username).First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return
+	}
+	if !entry.IsDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Entry is already confirmed"})
+		return
+	}
+	if strings.TrimSpace(entry.Content) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is empty"})
+		return
+	}
+
+	entry.IsDraft = false
+	entry.IsPublic = input.IsPublic
+	entry.IsAnonymous = input.IsAnonymous
+	entry.UnlockAt = time.Now().Add(24 * time.Hour)
+	if entry.IsPublic {
+		entry.UnlockAt = time.Now()
+	}
+	DB.Save(&entry)
+	summaryCache.Invalidate(username)
+
+	goBackground(func() {
+		indexEntry(entry)
+		checkRelapse(entry)
+	})
+
+	c.JSON(http.StatusOK, entry)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubTranscriber returns a fixed transcript and remembers what it was given
+type stubTranscriber struct {
+	text     string
+	err      error
+	calls    int
+	mimeType string
+	language string
+}
+
+func (s *stubTranscriber) Name() string { return "stub" }
+
+func (s *stubTranscriber) Transcribe(_ context.Context, _ []byte, mimeType, language string) (string, error) {
+	s.calls++
+	s.mimeType, s.language = mimeType, language
+	return s.text, s.err
+}
+
+// "ID3" makes the upload sniff as audio/mpeg
+var testAudio = append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), make([]byte, 512)...)
+
+func voiceRouter(username string) *gin.Engine {
+	r := testRouter(username)
+	r.POST("/entries/voice", CreateVoiceEntry)
+	r.PUT("/entries/:id/transcript", UpdateTranscript)
+	r.POST("/entries/:id/confirm", ConfirmEntry)
+	r.GET("/entries/:id", GetEntry)
+	r.GET("/entries", GetEntries)
+	r.GET("/public/entries", GetPublicEntries)
+	return r
+}
+
+func uploadVoice(t *testing.T, r *gin.Engine, data []byte, fields map[string]string) (int, DiaryEntry, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="file"; filename="memo.mp3"`)
+	h.Set("Content-Type", "audio/mpeg")
+	part, _ := form.CreatePart(h)
+	part.Write(data)
+	form.Close()
+
+	w := doRequest(r, http.MethodPost, "/entries/voice", form.FormDataContentType(), &body)
+	var resp struct {
+		Entry   DiaryEntry `json:"entry"`
+		Warning string     `json:"warning"`
+	}
+	if w.Code == http.StatusCreated {
+		decodeBody(t, w, &resp)
+	}
+	return w.Code, resp.Entry, resp.Warning
+}
+
+func TestCreateVoiceEntryStoresPrivateDraft(t *testing.T) {
+	setupTestDB(t)
+	stub := &stubTranscriber{text: "วันนี้เหนื่อยมาก"}
+	transcriber = stub
+	r := voiceRouter("alice")
+
+	code, entry, warning := uploadVoice(t, r, testAudio, map[string]string{"isPublic": "true", "title": "เสียง"})
+	if code != http.StatusCreated {
+		t.Fatalf("status = %d", code)
+	}
+	if warning != "" {
+		t.Errorf("unexpected warning %q", warning)
+	}
+	if !entry.IsDraft || entry.IsPublic {
+		t.Errorf("draft = %v, public = %v; want a private draft", entry.IsDraft, entry.IsPublic)
+	}
+	if entry.Content != stub.text || entry.Transcript != stub.text {
+		t.Errorf("content = %q, transcript = %q", entry.Content, entry.Transcript)
+	}
+	if stub.calls != 1 || stub.mimeType != "audio/mpeg" || stub.language != "th" {
+		t.Errorf("transcriber got %d calls, %q, %q", stub.calls, stub.mimeType, stub.language)
+	}
+
+	var attachments []Attachment
+	DB.Where("diary_entry_id = ?", entry.ID).Find(&attachments)
+	if len(attachments) != 1 || attachments[0].Kind != "audio" {
+		t.Errorf("attachments = %+v", attachments)
+	}
+
+	// Unreviewed transcripts never reach the public feed
+	var feed struct{ Items []DiaryEntry }
+	decodeBody(t, doJSON(r, http.MethodGet, "/public/entries", nil), &feed)
+	if len(feed.Items) != 0 {
+		t.Errorf("public feed has %d entries", len(feed.Items))
+	}
+}
+
+func TestCreateVoiceEntryReadsMoodAndTags(t *testing.T) {
+	setupTestDB(t)
+	transcriber = &stubTranscriber{text: "เศร้าเรื่องงาน"}
+	r := voiceRouter("alice")
+
+	code, entry, _ := uploadVoice(t, r, testAudio, map[string]string{"moodDetail": `{"primary":"sad","intensity":4}`, "tags": " งาน "})
+	if code != http.StatusCreated {
+		t.Fatalf("status = %d", code)
+	}
+	if entry.MoodDetail.Primary != "sad" || entry.MoodDetail.Intensity != 4 || entry.Mood == "" || len(entry.Tags) != 1 || entry.Tags[0] != "งาน" {
+		t.Errorf("mood = %q %+v, tags = %q", entry.Mood, entry.MoodDetail, entry.Tags)
+	}
+
+	for _, fields := range []map[string]string{
+		{"moodDetail": `{"primary":"bored-ish"}`},
+		{"moodDetail": "sad"},
+	} {
+		if code, _, _ := uploadVoice(t, r, testAudio, fields); code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d", fields, code)
+		}
+	}
+	var count int64
+	DB.Model(&DiaryEntry{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d entries stored", count)
+	}
+}
+
+func TestBackfillSkipsDrafts(t *testing.T) {
+	setupTestDB(t)
+	createAccount(t, "alice")
+	draft := DiaryEntry{Username: "alice", Content: "ยังไม่ได้ตรวจ", IsDraft: true}
+	done := DiaryEntry{Username: "alice", Content: "ตรวจแล้ว"}
+	DB.Create(&draft)
+	DB.Create(&done)
+
+	backfillEmbeddings("alice")
+
+	var indexed []EntryEmbedding
+	DB.Find(&indexed)
+	if len(indexed) != 1 || indexed[0].DiaryEntryID != done.ID {
+		t.Errorf("indexed = %+v", indexed)
+	}
+}
+
+func TestCreateVoiceEntryKeepsDraftWhenTranscriptionFails(t *testing.T) {
+	setupTestDB(t)
+	transcriber = &stubTranscriber{err: errors.New("offline")}
+	r := voiceRouter("alice")
+
+	code, entry, warning := uploadVoice(t, r, testAudio, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("status = %d", code)
+	}
+	if warning == "" || entry.Content != "" || !entry.IsDraft {
+		t.Errorf("warning = %q, content = %q, draft = %v", warning, entry.Content, entry.IsDraft)
+	}
+}
+
+func TestCreateVoiceEntryRejectsNonAudio(t *testing.T) {
+	setupTestDB(t)
+	stub := &stubTranscriber{text: "x"}
+	transcriber = stub
+	r := voiceRouter("alice")
+
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	if code, _, _ := uploadVoice(t, r, gif, nil); code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d", code)
+	}
+	var count int64
+	DB.Model(&DiaryEntry{}).Count(&count)
+	if count != 0 || stub.calls != 0 {
+		t.Errorf("entries = %d, transcriber calls = %d", count, stub.calls)
+	}
+}
+
+func TestReviewAndConfirmVoiceEntry(t *testing.T) {
+	setupTestDB(t)
+	transcriber = &stubTranscriber{text: "วันนี้เหนือยมาก"}
+	r := voiceRouter("alice")
+
+	_, entry, _ := uploadVoice(t, r, testAudio, nil)
+	path := fmt.Sprintf("/entries/%d", entry.ID)
+
+	// Someone else's draft is not found
+	if w := doJSON(voiceRouter("bob"), http.MethodPut, path+"/transcript", gin.H{"content": "x"}); w.Code != http.StatusNotFound {
+		t.Errorf("other user's update: status = %d", w.Code)
+	}
+
+	w := doJSON(r, http.MethodPut, path+"/transcript", gin.H{"content": "วันนี้เหนื่อยมาก", "title": "งาน"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d %s", w.Code, w.Body)
+	}
+	var updated DiaryEntry
+	decodeBody(t, w, &updated)
+	if updated.Content != "วันนี้เหนื่อยมาก" || updated.Title != "งาน" || updated.Transcript != "วันนี้เหนือยมาก" {
+		t.Errorf("after update: %+v", updated)
+	}
+
+	w = doJSON(r, http.MethodPost, path+"/confirm", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d %s", w.Code, w.Body)
+	}
+	var confirmed DiaryEntry
+	decodeBody(t, w, &confirmed)
+	if confirmed.IsDraft || confirmed.IsPublic || time.Until(confirmed.UnlockAt) < 23*time.Hour {
+		t.Errorf("after confirm: draft = %v, public = %v, unlock in %v", confirmed.IsDraft, confirmed.IsPublic, time.Until(confirmed.UnlockAt))
+	}
+
+	// The lock hides the transcript as well as the content
+	var got DiaryEntry
+	decodeBody(t, doJSON(r, http.MethodGet, path, nil), &got)
+	if !got.IsLocked || got.Content != "" || got.Transcript != "" {
+		t.Errorf("detail of locked entry: locked = %v, content = %q, transcript = %q", got.IsLocked, got.Content, got.Transcript)
+	}
+	var list struct{ Items []DiaryEntry }
+	decodeBody(t, doJSON(r, http.MethodGet, "/entries", nil), &list)
+	if len(list.Items) != 1 || list.Items[0].Content != "" || list.Items[0].Transcript != "" {
+		t.Errorf("list of locked entries: %+v", list.Items)
+	}
+
+	if w := doJSON(r, http.MethodPut, path+"/transcript", gin.H{"content": "later"}); w.Code != http.StatusConflict {
+		t.Errorf("update after confirm: status = %d", w.Code)
+	}
+	if w := doJSON(r, http.MethodPost, path+"/confirm", nil); w.Code != http.StatusConflict {
+		t.Errorf("second confirm: status = %d", w.Code)
+	}
+}
+
+func TestConfirmVoiceEntryPublishes(t *testing.T) {
+	setupTestDB(t)
+	transcriber = &stubTranscriber{}
+	r := voiceRouter("alice")
+
+	_, entry, _ := uploadVoice(t, r, testAudio, nil)
+	path := fmt.Sprintf("/entries/%d", entry.ID)
+
+	if w := doJSON(r, http.MethodPost, path+"/confirm", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("confirm with empty content: status = %d", w.Code)
+	}
+
+	doJSON(r, http.MethodPut, path+"/transcript", gin.H{"content": "ขอบคุณทุกคน"})
+	if w := doJSON(r, http.MethodPost, path+"/confirm", gin.H{"isPublic": true, "isAnonymous": true}); w.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d %s", w.Code, w.Body)
+	}
+
+	var feed struct{ Items []DiaryEntry }
+	decodeBody(t, doJSON(r, http.MethodGet, "/public/entries", nil), &feed)
+	if len(feed.Items) != 1 || feed.Items[0].Content != "ขอบคุณทุกคน" || feed.Items[0].Username != "Anonymous" {
+		t.Errorf("public feed = %+v", feed.Items)
+	}
+}
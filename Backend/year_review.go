@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// YearReview is the "year in review" retrospective of one user
+type YearReview struct {
+	Year               int               `json:"year"`
+	TotalEntries       int               `json:"totalEntries"`
+	TotalReflections   int               `json:"totalReflections"`
+	OverItCount        int               `json:"overItCount"`
+	TopThemes          []ThemeCount      `json:"topThemes"` // Tags and recurring words, by number of entries
+	TopMoods           []ThemeCount      `json:"topMoods"`
+	HardestMonths      []MonthStat       `json:"hardestMonths"`
+	FastestResolutions []ResolutionStat  `json:"fastestResolutions"`
+	SlowestResolutions []ResolutionStat  `json:"slowestResolutions"`
+	GrowthHighlights   []GrowthHighlight `json:"growthHighlights"`
+	Narrative          string            `json:"narrative"`
+	PDFAvailable       bool              `json:"pdfAvailable"` // Whether ?format=pdf works on this server
+	GeneratedAt        time.Time         `json:"generatedAt"`
+}
+
+type ThemeCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type MonthStat struct {
+	Month            string  `json:"month"` // YYYY-MM
+	Entries          int     `json:"entries"`
+	NeedHelp         int     `json:"needHelp"`
+	AverageIntensity float64 `json:"averageIntensity"`
+	Difficulty       float64 `json:"difficulty"`
+}
+
+type ResolutionStat struct {
+	EntryID uint    `json:"entryId"`
+	Title   string  `json:"title"`
+	Days    float64 `json:"days"`
+	Rounds  int     `json:"rounds"` // Reflections it took to reach over_it
+}
+
+type GrowthHighlight struct {
+	EntryID uint      `json:"entryId"`
+	Title   string    `json:"title"`
+	Date    time.Time `json:"date"`
+	Summary string    `json:"summary"`
+}
+
+// buildYearReview gathers the statistics of a calendar year (in loc) and asks the AI for a narrative
+func buildYearReview(username string, year int, loc *time.Location) YearReview {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(1, 0, 0)
+
+	var entries []DiaryEntry
+	DB.Preload("Reflections", orderedReflections).Where("username = ? AND is_draft = ? AND created_at >= ? AND created_at < ?", username, false, start, end).
+		Order("created_at asc").Find(&entries)
+
+	var prefs []UserPreference
+	DB.Where("username = ? AND created_at < ?", username, end).Find(&prefs)
+
+	review := YearReview{
+		Year:               year,
+		TotalEntries:       len(entries),
+		TopThemes:          []ThemeCount{},
+		TopMoods:           []ThemeCount{},
+		HardestMonths:      []MonthStat{},
+		SlowestResolutions: []ResolutionStat{},
+		GrowthHighlights:   []GrowthHighlight{},
+		PDFAvailable:       pdfExportEnabled(),
+		GeneratedAt:        time.Now(),
+	}
+
+	themes := make(map[string]int)
+	moods := make(map[string]int)
+	months := make(map[string]*MonthStat)
+	monthIntensity := make(map[string][2]int) // sum, count
+	resolutions := []ResolutionStat{}
+
+	for _, e := range entries {
+		review.TotalReflections += len(e.Reflections)
+
+		for term := range entryTerms(e) {
+			themes[term]++
+		}
+		if e.MoodDetail.Primary != "" {
+			moods[moodTaxonomy[e.MoodDetail.Primary].Label]++
+			for _, s := range e.MoodDetail.Secondary {
+				moods[moodTaxonomy[s].Label]++
+			}
+		} else if e.Mood != "" {
+			moods[e.Mood]++
+		}
+
+		key := e.CreatedAt.In(loc).Format("2006-01")
+		m := months[key]
+		if m == nil {
+			m = &MonthStat{Month: key}
+			months[key] = m
+		}
+		m.Entries++
+		for _, h := range e.Reflections {
+			if h.Status == "need_help" {
+				m.NeedHelp++
+			}
+		}
+		if e.MoodDetail.Intensity > 0 && e.MoodDetail.Valence < 0 {
+			acc := monthIntensity[key]
+			monthIntensity[key] = [2]int{acc[0] + e.MoodDetail.Intensity, acc[1] + 1}
+		}
+
+		if e.Status == "over_it" {
+			review.OverItCount++
+			if days, ok := resolutionDays(e); ok {
+				resolutions = append(resolutions, ResolutionStat{EntryID: e.ID, Title: e.Title, Days: round1(days), Rounds: resolutionRounds(e)})
+			}
+			if e.AIResponse != "" {
+				review.GrowthHighlights = append(review.GrowthHighlights, GrowthHighlight{
+					EntryID: e.ID, Title: e.Title, Date: e.CreatedAt, Summary: e.AIResponse,
+				})
+			}
+		}
+	}
+
+	review.TopThemes = topThemes(themes)
+	for name, count := range moods {
+		review.TopMoods = append(review.TopMoods, ThemeCount{name, count})
+	}
+	sort.Slice(review.TopMoods, func(i, j int) bool { return review.TopMoods[i].Count > review.TopMoods[j].Count })
+	review.TopMoods = review.TopMoods[:min(5, len(review.TopMoods))]
+
+	// Difficulty: need_help reflections weigh most, then intense unpleasant moods
+	for key, m := range months {
+		if acc := monthIntensity[key]; acc[1] > 0 {
+			m.AverageIntensity = round1(float64(acc[0]) / float64(acc[1]))
+		}
+		m.Difficulty = round1(float64(m.NeedHelp)*3 + m.AverageIntensity)
+		if m.Difficulty > 0 {
+			review.HardestMonths = append(review.HardestMonths, *m)
+		}
+	}
+	sort.Slice(review.HardestMonths, func(i, j int) bool { return review.HardestMonths[i].Difficulty > review.HardestMonths[j].Difficulty })
+	review.HardestMonths = review.HardestMonths[:min(3, len(review.HardestMonths))]
+
+	sort.Slice(resolutions, func(i, j int) bool { return resolutions[i].Days < resolutions[j].Days })
+	// Up to three each; with fewer than six the halves are split so no entry is in both
+	fast := min(3, (len(resolutions)+1)/2)
+	review.FastestResolutions = resolutions[:fast]
+	for i := len(resolutions) - 1; i >= fast && len(review.SlowestResolutions) < 3; i-- {
+		review.SlowestResolutions = append(review.SlowestResolutions, resolutions[i])
+	}
+
+	// Keep the latest highlights; they read best as "where you are now"
+	if n := len(review.GrowthHighlights); n > 5 {
+		review.GrowthHighlights = review.GrowthHighlights[n-5:]
+	}
+
+	review.Narrative = yearNarrative(review, prefs)
+	return review
+}
+
+// topThemes picks the tags and words that came up in the most entries. A theme
+// needs at least two entries; tags win ties since the user chose them.
+func topThemes(counts map[string]int) []ThemeCount {
+	themes := []ThemeCount{}
+	for term, count := range counts {
+		if count >= 2 {
+			themes = append(themes, ThemeCount{term, count})
+		}
+	}
+	sort.Slice(themes, func(i, j int) bool {
+		if themes[i].Count != themes[j].Count {
+			return themes[i].Count > themes[j].Count
+		}
+		if ti, tj := strings.HasPrefix(themes[i].Name, "#"), strings.HasPrefix(themes[j].Name, "#"); ti != tj {
+			return ti
+		}
+		return themes[i].Name < themes[j].Name
+	})
+	return themes[:min(5, len(themes))]
+}
+
+func yearNarrative(review YearReview, prefs []UserPreference) string {
+	if review.TotalEntries == 0 {
+		return fmt.Sprintf("ปี %d ยังไม่มีบันทึกเลย ปีหน้ามาเริ่มเขียนด้วยกันนะ 🌱", review.Year)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "บันทึกทั้งหมด %d รายการ, ไตร่ตรอง %d ครั้ง, ก้าวผ่านได้ %d เรื่อง\n", review.TotalEntries, review.TotalReflections, review.OverItCount)
+	sb.WriteString("เรื่องที่เขียนถึงบ่อย: ")
+	for _, t := range review.TopThemes {
+		fmt.Fprintf(&sb, "%s (%d) ", t.Name, t.Count)
+	}
+	sb.WriteString("\nอารมณ์ที่พบบ่อย: ")
+	for _, t := range review.TopMoods {
+		fmt.Fprintf(&sb, "%s (%d) ", t.Name, t.Count)
+	}
+	sb.WriteString("\nเดือนที่ยากที่สุด: ")
+	for _, m := range review.HardestMonths {
+		fmt.Fprintf(&sb, "%s ", m.Month)
+	}
+	sb.WriteString("\nสรุปการเติบโต:\n")
+	for _, g := range review.GrowthHighlights {
+		fmt.Fprintf(&sb, "- %s: %s\n", g.Title, g.Summary)
+	}
+	sb.WriteString("สิ่งที่ผู้ใช้เคยเล่าเกี่ยวกับตัวเอง:\n")
+	for _, p := range prefs {
+		fmt.Fprintf(&sb, "- %s: %s\n", p.Question, p.Answer)
+	}
+
+	prompt := fmt.Sprintf(`คุณคือนักจิตวิทยาที่อบอุ่น กำลังเขียน "สรุปหนึ่งปีที่ผ่านมา" ให้ผู้ใช้ได้อ่านย้อนหลังปี %d
+
+%s
+เขียนเป็นเรื่องเล่า 2 ย่อหน้า เป็นภาษาไทย ชื่นชมการเติบโต พูดถึงช่วงที่ยากอย่างอ่อนโยน และจบด้วยกำลังใจสำหรับปีถัดไป`, review.Year, sb.String())
+
+	text, err := generateContent(context.Background(), prompt)
+	if err != nil {
+		log.Printf("Failed to generate year review narrative: %v", err)
+		return fmt.Sprintf("ปี %d คุณเขียนบันทึกไป %d ครั้ง และก้าวผ่านมาได้ %d เรื่อง ทุกหน้าคือหลักฐานว่าคุณดูแลใจตัวเองมาตลอด 💛",
+			review.Year, review.TotalEntries, review.OverItCount)
+	}
+	return text
+}
+
+var yearReviewTemplate = template.Must(template.New("year").Parse(`<!DOCTYPE html>
+<html lang="th">
+<head>
+<meta charset="utf-8">
+<title>Year in Review {{.Year}}</title>
+<style>
+body { font-family: "Sarabun", "Noto Sans Thai", sans-serif; max-width: 720px; margin: 40px auto; color: #333; line-height: 1.6; }
+h1 { color: #6b5b95; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: 4px; }
+.stat { display: inline-block; margin-right: 24px; font-size: 1.2em; }
+.highlight { background: #f7f4fb; border-radius: 8px; padding: 12px; margin-bottom: 12px; }
+</style>
+</head>
+<body>
+<h1>🌟 สรุปปี {{.Year}} ของฉัน</h1>
+<p>
+<span class="stat">📝 {{.TotalEntries}} บันทึก</span>
+<span class="stat">💭 {{.TotalReflections}} การไตร่ตรอง</span>
+<span class="stat">🌈 {{.OverItCount}} เรื่องที่ก้าวผ่าน</span>
+</p>
+
+<h2>เรื่องเล่าของปีนี้</h2>
+<p>{{.Narrative}}</p>
+
+{{if .TopThemes}}<h2>เรื่องที่เขียนถึงบ่อย</h2>
+<ul>{{range .TopThemes}}<li>{{.Name}} — {{.Count}} บันทึก</li>{{end}}</ul>{{end}}
+
+{{if .TopMoods}}<h2>อารมณ์ที่พบบ่อย</h2>
+<ul>{{range .TopMoods}}<li>{{.Name}} — {{.Count}} ครั้ง</li>{{end}}</ul>{{end}}
+
+{{if .HardestMonths}}<h2>เดือนที่ยากที่สุด</h2>
+<ul>{{range .HardestMonths}}<li>{{.Month}} — {{.Entries}} บันทึก, ขอความช่วยเหลือ {{.NeedHelp}} ครั้ง</li>{{end}}</ul>{{end}}
+
+{{if .FastestResolutions}}<h2>ก้าวผ่านได้เร็วที่สุด</h2>
+<ul>{{range .FastestResolutions}}<li>{{.Title}} — {{.Days}} วัน ({{.Rounds}} รอบ)</li>{{end}}</ul>{{end}}
+
+{{if .SlowestResolutions}}<h2>ใช้เวลานานที่สุด</h2>
+<ul>{{range .SlowestResolutions}}<li>{{.Title}} — {{.Days}} วัน ({{.Rounds}} รอบ)</li>{{end}}</ul>{{end}}
+
+{{if .GrowthHighlights}}<h2>ช่วงเวลาแห่งการเติบโต</h2>
+{{range .GrowthHighlights}}<div class="highlight"><strong>{{.Title}}</strong> ({{.Date.Format "02/01/2006"}})<br>{{.Summary}}</div>{{end}}{{end}}
+</body>
+</html>
+`))
+
+// PDF export is off unless PDF_CONVERTER names a command that reads HTML on stdin and
+// writes PDF to stdout. The Docker images install WeasyPrint with Thai fonts and set
+// it to "weasyprint --encoding utf-8 - -". Thai needs a real text shaping engine,
+// which no pure Go PDF writer has, so the conversion is left to an external tool.
+// Without it ?format=pdf answers 501 and reports have "pdfAvailable": false.
+func pdfExportEnabled() bool {
+	return strings.TrimSpace(os.Getenv("PDF_CONVERTER")) != ""
+}
+
+// renderPDF converts HTML to PDF with the PDF_CONVERTER command
+func renderPDF(ctx context.Context, html []byte) ([]byte, error) {
+	args := strings.Fields(os.Getenv("PDF_CONVERTER"))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("PDF export is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(html)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return out.Bytes(), nil
+}
+
+// GetYearReview returns the year in review as JSON, HTML (?format=html) or PDF (?format=pdf)
+func GetYearReview(c *gin.Context) {
+	username := c.GetString("username")
+
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil || year < 2000 || year > time.Now().Year() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+		return
+	}
+	loc, err := userLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "html" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, html or pdf"})
+		return
+	}
+	if format == "pdf" && !pdfExportEnabled() {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "PDF export is not enabled on this server, use format=html and print it instead"})
+		return
+	}
+
+	review := buildYearReview(username, year, loc)
+	if format == "json" {
+		c.JSON(http.StatusOK, review)
+		return
+	}
+
+	var html bytes.Buffer
+	if err := yearReviewTemplate.Execute(&html, review); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render report"})
+		return
+	}
+
+	filename := fmt.Sprintf("year-in-review-%d", year)
+	if format == "html" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.html"`, filename))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", html.Bytes())
+		return
+	}
+
+	pdf, err := renderPDF(c.Request.Context(), html.Bytes())
+	if err != nil {
+		log.Printf("PDF export failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export PDF"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestYearReviewSplitsResolutionsWithoutOverlap(t *testing.T) {
+	tests := []struct {
+		resolved         int
+		fastest, slowest int
+	}{
+		{0, 0, 0},
+		{1, 1, 0},
+		{2, 1, 1},
+		{4, 2, 2},
+		{5, 3, 2},
+		{8, 3, 3},
+	}
+	year := 2025
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.resolved), func(t *testing.T) {
+			setupTestDB(t)
+			for i := 0; i < tt.resolved; i++ {
+				start := time.Date(year, time.March, 1+i, 9, 0, 0, 0, time.UTC)
+				e := DiaryEntry{Username: "alice", Title: "เรื่อง", Status: "over_it", CreatedAt: start}
+				DB.Create(&e)
+				DB.Create(&ReflectionHistory{DiaryEntryID: e.ID, Status: "over_it", CreatedAt: start.AddDate(0, 0, i+1)})
+			}
+
+			review := buildYearReview("alice", year, time.UTC)
+			if len(review.FastestResolutions) != tt.fastest || len(review.SlowestResolutions) != tt.slowest {
+				t.Errorf("%d resolved: %d fastest, %d slowest; want %d, %d",
+					tt.resolved, len(review.FastestResolutions), len(review.SlowestResolutions), tt.fastest, tt.slowest)
+			}
+			seen := make(map[uint]bool)
+			for _, r := range review.FastestResolutions {
+				seen[r.EntryID] = true
+			}
+			for _, r := range review.SlowestResolutions {
+				if seen[r.EntryID] {
+					t.Errorf("%d resolved: entry %d is both fastest and slowest", tt.resolved, r.EntryID)
+				}
+			}
+		})
+	}
+}
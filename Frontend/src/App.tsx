@@ -2,7 +2,13 @@ import { useEffect, useMemo, useRef, useState } from 'react'
 import './App.css'
 import Login from './pages/Auth/Login';
 import Register from './pages/Auth/Register';
+import ForgotPassword from './pages/Auth/ForgotPassword';
+import ResetPassword from './pages/Auth/ResetPassword';
+import VerifyEmail from './pages/Auth/VerifyEmail';
 import ProfileSettings from './components/ProfileSettings';
+import { registerPasskey } from './passkeys';
+import { completeOIDC, isOIDCCallback, startOIDCLink } from './oidc';
+import { takeEmailLink } from './emailLinks';
 import LogoutModal from './components/LogoutModal';
 import { FiCalendar } from "react-icons/fi";
 
@@ -13,7 +19,7 @@ import { FiCalendar } from "react-icons/fi";
 // Types
 // =====================
 type ViewState = 'dashboard' | 'write' | 'read' | 'summary' | 'calendar' | 'public';
-type AuthModalState = 'none' | 'login' | 'register';
+type AuthModalState = 'none' | 'login' | 'register' | 'forgot-password' | 'reset-password' | 'verify-email';
 
 type SummaryData = {
   stats: {
@@ -71,6 +77,8 @@ type UserProfile = {
   username: string;
   displayName: string;
   avatar: string;
+  email?: string;
+  emailVerified?: boolean;
 };
 
 type Theme = {
@@ -204,7 +212,47 @@ const Countdown = ({ target }: { target: string }) => {
 
 
 // Auth Helper
-const authFetch = async (url: string, options: RequestInit = {}) => {
+// Access tokens are short-lived; trade the refresh token for a new pair (one refresh at a time)
+let refreshing: Promise<boolean> | null = null;
+const refreshSession = () => {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refreshToken');
+      if (!refreshToken) return false;
+      const response = await fetch(`${API_URL}/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refreshToken }),
+      });
+      if (!response.ok) return false;
+      const data = await response.json();
+      localStorage.setItem('token', data.token);
+      localStorage.setItem('refreshToken', data.refreshToken);
+      return true;
+    })().finally(() => { refreshing = null; });
+  }
+  return refreshing;
+};
+
+// downloadJSON saves data as a file in the browser
+const downloadJSON = (data: unknown, filename: string) => {
+  const url = URL.createObjectURL(new Blob([JSON.stringify(data, null, 2)], { type: 'application/json' }));
+  const link = document.createElement('a');
+  link.href = url;
+  link.download = filename;
+  link.click();
+  URL.revokeObjectURL(url);
+};
+
+// List endpoints return one page at a time: { items, next_cursor }
+const fetchPage = async <T,>(url: string, cursor: string | null = null): Promise<{ items: T[], next: string | null } | null> => {
+  const res = await authFetch(cursor ? `${url}${url.includes('?') ? '&' : '?'}cursor=${encodeURIComponent(cursor)}` : url)
+  if (!res.ok) return null
+  const data = await res.json()
+  return { items: Array.isArray(data?.items) ? data.items : [], next: data?.next_cursor ?? null }
+}
+
+const authFetch = async (url: string, options: RequestInit = {}, retry = true): Promise<Response> => {
   const token = localStorage.getItem('token');
   const headers = {
     'Authorization': `Bearer ${token}`,
@@ -214,8 +262,12 @@ const authFetch = async (url: string, options: RequestInit = {}) => {
 
   const response = await fetch(url, { ...options, headers });
   if (response.status === 401) {
-    // Token expired or invalid
+    if (retry && await refreshSession()) {
+      return authFetch(url, options, false);
+    }
+    // Session expired or revoked
     localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
     window.location.reload(); // Simple way to reset state to login
   }
   return response;
@@ -224,7 +276,9 @@ const authFetch = async (url: string, options: RequestInit = {}) => {
 function App() {
   const [view, setView] = useState<ViewState>('dashboard');
   const [authModal, setAuthModal] = useState<AuthModalState>('none');
+  const [emailLinkToken, setEmailLinkToken] = useState(''); // From a /verify-email or /reset-password link
   const [isAuthenticated, setIsAuthenticated] = useState(false);
+  const [pendingChallenge, setPendingChallenge] = useState('');
 
   // Writer state
   const [writeTitle, setWriteTitle] = useState("");
@@ -239,6 +293,7 @@ function App() {
   const [lockedModalOpen, setLockedModalOpen] = useState(false);
   const [selectedEntry, setSelectedEntry] = useState<DiaryEntry | null>(null);
   const [entries, setEntries] = useState<DiaryEntry[]>([]);
+  const [entriesCursor, setEntriesCursor] = useState<string | null>(null);
 
   // Read view state
   const [readEntry, setReadEntry] = useState<DiaryEntry | null>(null)
@@ -250,7 +305,9 @@ function App() {
 
   // Public Feed state
   const [publicEntries, setPublicEntries] = useState<DiaryEntry[]>([])
+  const [publicCursor, setPublicCursor] = useState<string | null>(null)
   const [comments, setComments] = useState<Record<number, Comment[]>>({})
+  const [commentCursors, setCommentCursors] = useState<Record<number, string | null>>({})
   const [newComment, setNewComment] = useState("");
   const [commentIsAnonymous, setCommentIsAnonymous] = useState(false);
   const [loadingComments, setLoadingComments] = useState<Record<number, boolean>>({});
@@ -319,6 +376,31 @@ function App() {
 
   // Check Auth on Mount
   useEffect(() => {
+    if (isOIDCCallback()) {
+      completeOIDC()
+        .then((data) => {
+          if (data.twoFactorRequired) {
+            setPendingChallenge(data.challengeToken);
+            setAuthModal('login');
+          } else if (data.token) {
+            localStorage.setItem('token', data.token);
+            localStorage.setItem('refreshToken', data.refreshToken);
+            setIsAuthenticated(true);
+            fetchEntries();
+            fetchUserProfile();
+          } else if (data.identity) {
+            alert('เชื่อมบัญชีเรียบร้อยแล้ว');
+          }
+        })
+        .catch((err) => alert(err.message));
+    }
+
+    const link = takeEmailLink();
+    if (link) {
+      setEmailLinkToken(link.token);
+      setAuthModal(link.kind);
+    }
+
     const token = localStorage.getItem('token');
     if (token) {
       setIsAuthenticated(true);
@@ -399,12 +481,13 @@ function App() {
     }
   }
 
-  const fetchEntries = async () => {
+  // Without a cursor the list starts over from the newest page
+  const fetchEntries = async (cursor: string | null = null) => {
     try {
-      const res = await authFetch(`${API_URL}/entries`)
-      if (res.ok) {
-        const data = await res.json()
-        setEntries(Array.isArray(data) ? data : [])
+      const page = await fetchPage<DiaryEntry>(`${API_URL}/entries`, cursor)
+      if (page) {
+        setEntries(prev => cursor ? [...prev, ...page.items] : page.items)
+        setEntriesCursor(page.next)
       }
     } catch (err) {
       console.error('Failed to fetch entries', err)
@@ -423,25 +506,25 @@ function App() {
     }
   };
 
-  const fetchPublicEntries = async () => {
+  const fetchPublicEntries = async (cursor: string | null = null) => {
     try {
-      const res = await authFetch(`${API_URL}/public/entries`)
-      if (res.ok) {
-        const data = await res.json()
-        setPublicEntries(Array.isArray(data) ? data : [])
+      const page = await fetchPage<DiaryEntry>(`${API_URL}/public/entries`, cursor)
+      if (page) {
+        setPublicEntries(prev => cursor ? [...prev, ...page.items] : page.items)
+        setPublicCursor(page.next)
       }
     } catch (err) {
       console.error('Failed to fetch public entries', err)
     }
   }
 
-  const fetchComments = async (diaryId: number) => {
+  const fetchComments = async (diaryId: number, cursor: string | null = null) => {
     setLoadingComments(prev => ({ ...prev, [diaryId]: true }));
     try {
-      const res = await authFetch(`${API_URL}/entries/${diaryId}/comments`);
-      if (res.ok) {
-        const data = await res.json();
-        setComments(prev => ({ ...prev, [diaryId]: data }));
+      const page = await fetchPage<Comment>(`${API_URL}/entries/${diaryId}/comments`, cursor);
+      if (page) {
+        setComments(prev => ({ ...prev, [diaryId]: cursor ? [...(prev[diaryId] || []), ...page.items] : page.items }));
+        setCommentCursors(prev => ({ ...prev, [diaryId]: page.next }));
       }
     } catch (err) {
       console.error('Failed to fetch comments', err);
@@ -597,6 +680,47 @@ function App() {
     } catch (err) { console.error("Failed to update profile", err); }
   };
 
+  // handleUpdateEmail saves the address and (re)sends its verification link
+  const handleUpdateEmail = async (email: string) => {
+    const res = await authFetch(`${API_URL}/profile/email`, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email }),
+    });
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || 'บันทึกอีเมลไม่สำเร็จ');
+    setUserProfile((prev) => prev && { ...prev, email: data.email, emailVerified: data.emailVerified });
+  };
+
+  const handleExportAccount = async () => {
+    const res = await authFetch(`${API_URL}/account/export`);
+    if (!res.ok) throw new Error('ดาวน์โหลดข้อมูลไม่สำเร็จ');
+    downloadJSON(await res.json(), `yesterdays-me-${userProfile?.username}.json`);
+  };
+
+  const handleAddPasskey = (password: string, code: string) =>
+    // Plain fetch for the same reason as handleDeleteAccount: a wrong password answers 401
+    registerPasskey((url, options = {}) => fetch(url, {
+      ...options,
+      headers: { 'Authorization': `Bearer ${localStorage.getItem('token')}`, 'Content-Type': 'application/json' },
+    }), { password, code });
+
+  const handleDeleteAccount = async (password: string, confirm: string, exportFirst: boolean) => {
+    // Plain fetch: a wrong password answers 401, which must not end the session like authFetch would
+    const res = await fetch(`${API_URL}/account`, {
+      method: 'DELETE',
+      headers: { 'Authorization': `Bearer ${localStorage.getItem('token')}`, 'Content-Type': 'application/json' },
+      body: JSON.stringify({ password, confirm, export: exportFirst }),
+    });
+    const data = await res.json();
+    if (!res.ok) throw new Error(data.error || 'ลบบัญชีไม่สำเร็จ');
+
+    if (data.export) downloadJSON(data.export, `yesterdays-me-${confirm}.json`);
+    localStorage.removeItem('token');
+    localStorage.removeItem('refreshToken');
+    window.location.reload();
+  };
+
   const saveAnswer = async (question: AIQuestion, answer: string) => {
     try {
       await authFetch(`${API_URL}/preferences`, {
@@ -823,6 +947,11 @@ function App() {
           onClose={() => setShowProfileModal(false)}
           currentUser={userProfile}
           onUpdate={handleUpdateProfile}
+          onUpdateEmail={handleUpdateEmail}
+          onAddPasskey={handleAddPasskey}
+          onLinkIdentity={(provider) => startOIDCLink(authFetch, provider)}
+          onExportAccount={handleExportAccount}
+          onDeleteAccount={handleDeleteAccount}
         />
       )}
 
@@ -930,6 +1059,11 @@ function App() {
                 </div>
               ))}
             </div>
+            {entriesCursor && (
+              <button className="btn-secondary load-more-btn" onClick={() => fetchEntries(entriesCursor)}>
+                โหลดบันทึกเก่าเพิ่ม
+              </button>
+            )}
 
           </div>
         ) : view === 'summary' ? (
@@ -1358,12 +1492,12 @@ function App() {
                           if (!comments[entry.id]) fetchComments(entry.id);
                         }}
                       >
-                        💭 ความคิดเห็น ({comments[entry.id]?.length || 0})
+                        💭 ความคิดเห็น ({comments[entry.id]?.length || 0}{commentCursors[entry.id] ? '+' : ''})
                       </button>
 
                       {(comments[entry.id] || loadingComments[entry.id]) && (
                         <div className="comments-list">
-                          {loadingComments[entry.id] ? (
+                          {loadingComments[entry.id] && !comments[entry.id] ? (
                             <div className="loading-comments">กำลังโหลดความคิดเห็น...</div>
                           ) : (
                             comments[entry.id]?.map((comment) => (
@@ -1376,6 +1510,15 @@ function App() {
                               </div>
                             ))
                           )}
+                          {commentCursors[entry.id] && (
+                            <button
+                              className="btn-text"
+                              onClick={() => fetchComments(entry.id, commentCursors[entry.id])}
+                              disabled={loadingComments[entry.id]}
+                            >
+                              ดูความคิดเห็นเพิ่มเติม
+                            </button>
+                          )}
 
                           <div className="comment-input-area">
                             <textarea
@@ -1407,6 +1550,11 @@ function App() {
                   </div>
                 ))
               )}
+              {publicCursor && (
+                <button className="btn-secondary load-more-btn" onClick={() => fetchPublicEntries(publicCursor)}>
+                  โหลดเพิ่ม
+                </button>
+              )}
             </div>
             <button className="fab-button" onClick={() => handleAuthAction(() => {
               setWriteMode('public');
@@ -1558,7 +1706,9 @@ function App() {
         isOpen={showLogoutModal}
         onClose={() => setShowLogoutModal(false)}
         onConfirm={() => {
+          authFetch(`${API_URL}/logout`, { method: 'POST' }, false).catch(() => { });
           localStorage.removeItem('token');
+          localStorage.removeItem('refreshToken');
           setIsAuthenticated(false);
           setEntries([]);
           setSummaryData(null);
@@ -1575,16 +1725,19 @@ function App() {
             {/* Prevent click inside modal from closing it */}
             <div className="modal-content glass-panel" onClick={(e) => e.stopPropagation()}>
               <Login
+                initialChallenge={pendingChallenge}
                 onLoginSuccess={(username) => {
                   console.log("Logged in as", username);
                   setIsAuthenticated(true);
                   setAuthModal('none');
+                  setPendingChallenge('');
                   fetchEntries();
                   fetchUserProfile();
                   // We're already on dashboard or the protected route logic will handle next steps if needed
                   // But generally staying on dashboard is fine or we could pass a redirect callback later
                 }}
                 onNavigateToRegister={() => setAuthModal('register')}
+                onNavigateToForgotPassword={() => setAuthModal('forgot-password')}
               />
             </div>
           </div>
@@ -1604,6 +1757,50 @@ function App() {
         )
       }
 
+      {
+        authModal === 'forgot-password' && (
+          <div className="modal-overlay" onClick={() => setAuthModal('none')}>
+            <div className="modal-content glass-panel" onClick={(e) => e.stopPropagation()}>
+              <ForgotPassword onNavigateToLogin={() => setAuthModal('login')} />
+            </div>
+          </div>
+        )
+      }
+
+      {
+        authModal === 'reset-password' && (
+          <div className="modal-overlay" onClick={() => setAuthModal('none')}>
+            <div className="modal-content glass-panel" onClick={(e) => e.stopPropagation()}>
+              <ResetPassword
+                token={emailLinkToken}
+                onResetSuccess={() => {
+                  setIsAuthenticated(false);
+                  setEntries([]);
+                  setUserProfile(null);
+                  setAuthModal('login');
+                }}
+              />
+            </div>
+          </div>
+        )
+      }
+
+      {
+        authModal === 'verify-email' && (
+          <div className="modal-overlay" onClick={() => setAuthModal('none')}>
+            <div className="modal-content glass-panel" onClick={(e) => e.stopPropagation()}>
+              <VerifyEmail
+                token={emailLinkToken}
+                onVerified={() => {
+                  if (localStorage.getItem('token')) fetchUserProfile();
+                }}
+                onClose={() => setAuthModal('none')}
+              />
+            </div>
+          </div>
+        )
+      }
+
       {/* ===== Global FAB (Conditional) ===== */}
       {
         view !== 'write' && view !== 'public' && (
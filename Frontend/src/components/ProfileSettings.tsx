@@ -1,21 +1,44 @@
 import React, { useState, useEffect } from 'react';
 import '../App.css';
+import { passkeysSupported } from '../passkeys';
+import { fetchOIDCProviders, type OIDCProvider } from '../oidc';
 
 interface ProfileSettingsProps {
     isOpen: boolean;
     onClose: () => void;
-    currentUser: { displayName: string; avatar: string; username: string };
+    currentUser: { displayName: string; avatar: string; username: string; email?: string; emailVerified?: boolean };
     onUpdate: (data: { displayName: string; avatar: string }) => Promise<void>;
+    onUpdateEmail?: (email: string) => Promise<void>;
+    onAddPasskey?: (password: string, code: string) => Promise<void>;
+    onLinkIdentity?: (provider: string) => Promise<void>;
+    onExportAccount?: () => Promise<void>;
+    onDeleteAccount?: (password: string, confirm: string, exportFirst: boolean) => Promise<void>;
 }
 
-const ProfileSettings: React.FC<ProfileSettingsProps> = ({ isOpen, onClose, currentUser, onUpdate }) => {
+const ProfileSettings: React.FC<ProfileSettingsProps> = ({ isOpen, onClose, currentUser, onUpdate, onUpdateEmail, onAddPasskey, onLinkIdentity, onExportAccount, onDeleteAccount }) => {
     const [displayName, setDisplayName] = useState(currentUser.displayName || '');
     const [avatar, setAvatar] = useState(currentUser.avatar || '');
     const [loading, setLoading] = useState(false);
+    const [passkeyMessage, setPasskeyMessage] = useState('');
+    const [passkeyPassword, setPasskeyPassword] = useState('');
+    const [passkeyCode, setPasskeyCode] = useState('');
+    const [providers, setProviders] = useState<OIDCProvider[]>([]);
+    const [showDelete, setShowDelete] = useState(false);
+    const [deletePassword, setDeletePassword] = useState('');
+    const [deleteConfirm, setDeleteConfirm] = useState('');
+    const [exportFirst, setExportFirst] = useState(true);
+    const [accountMessage, setAccountMessage] = useState('');
+    const [email, setEmail] = useState(currentUser.email || '');
+    const [emailMessage, setEmailMessage] = useState('');
+
+    useEffect(() => {
+        if (isOpen && onLinkIdentity) fetchOIDCProviders().then(setProviders);
+    }, [isOpen]);
 
     useEffect(() => {
         setDisplayName(currentUser.displayName || '');
         setAvatar(currentUser.avatar || '');
+        setEmail(currentUser.email || '');
     }, [currentUser]);
 
     if (!isOpen) return null;
@@ -28,6 +51,40 @@ const ProfileSettings: React.FC<ProfileSettingsProps> = ({ isOpen, onClose, curr
         onClose();
     };
 
+    const handleAddPasskey = async () => {
+        if (!onAddPasskey) return;
+        setPasskeyMessage('');
+        try {
+            await onAddPasskey(passkeyPassword, passkeyCode);
+            setPasskeyPassword('');
+            setPasskeyCode('');
+            setPasskeyMessage('เพิ่มพาสคีย์แล้ว ครั้งหน้าเข้าสู่ระบบด้วยลายนิ้วมือหรือใบหน้าได้เลย');
+        } catch (err: any) {
+            if (err?.name !== 'NotAllowedError') setPasskeyMessage(err?.message || 'เพิ่มพาสคีย์ไม่สำเร็จ');
+        }
+    };
+
+    const handleUpdateEmail = async () => {
+        if (!onUpdateEmail) return;
+        setEmailMessage('');
+        try {
+            await onUpdateEmail(email);
+            setEmailMessage(email.trim() ? 'ส่งลิงก์ยืนยันไปที่อีเมลแล้ว กรุณาเปิดลิงก์ภายใน 48 ชั่วโมง' : 'ลบอีเมลแล้ว');
+        } catch (err: any) {
+            setEmailMessage(err?.message || 'บันทึกอีเมลไม่สำเร็จ');
+        }
+    };
+
+    const handleDeleteAccount = async () => {
+        if (!onDeleteAccount) return;
+        setAccountMessage('');
+        try {
+            await onDeleteAccount(deletePassword, deleteConfirm, exportFirst);
+        } catch (err: any) {
+            setAccountMessage(err?.message || 'ลบบัญชีไม่สำเร็จ');
+        }
+    };
+
     const emojis = ['🙂', '😎', '🥳', '🤯', '🦁', '🐱', '🦊', '🚀', '🌟', '🌙', '🎵', '🎨', '📚', '☕', '💡', '🔥'];
 
     return (
@@ -85,6 +142,119 @@ const ProfileSettings: React.FC<ProfileSettingsProps> = ({ isOpen, onClose, curr
                         </div>
                     </div>
 
+                    {onUpdateEmail && (
+                        <div className="form-section">
+                            <label className="section-label">
+                                อีเมล {currentUser.email && (currentUser.emailVerified ? '✅ ยืนยันแล้ว' : '⏳ ยังไม่ยืนยัน')}
+                            </label>
+                            <div className="input-wrapper">
+                                <input
+                                    type="email"
+                                    value={email}
+                                    onChange={(e) => setEmail(e.target.value)}
+                                    placeholder="ใช้สำหรับกู้คืนรหัสผ่านและรับสรุปทางอีเมล"
+                                    className="modern-input"
+                                />
+                            </div>
+                            {(email.trim() !== (currentUser.email || '') || (currentUser.email && !currentUser.emailVerified)) && (
+                                <button type="button" onClick={handleUpdateEmail} className="btn-ghost">
+                                    {email.trim() === (currentUser.email || '') ? 'ส่งลิงก์ยืนยันอีกครั้ง' : 'บันทึกอีเมล'}
+                                </button>
+                            )}
+                            {emailMessage && <p className="preview-label">{emailMessage}</p>}
+                        </div>
+                    )}
+
+                    {onAddPasskey && passkeysSupported() && (
+                        <div className="form-section">
+                            <label className="section-label">พาสคีย์</label>
+                            <div className="input-wrapper" style={{ display: 'flex', flexDirection: 'column', gap: '8px' }}>
+                                <input
+                                    type="password"
+                                    value={passkeyPassword}
+                                    onChange={(e) => setPasskeyPassword(e.target.value)}
+                                    placeholder="รหัสผ่าน (ถ้ามี)"
+                                    className="modern-input-small"
+                                />
+                                <input
+                                    type="text"
+                                    inputMode="numeric"
+                                    autoComplete="one-time-code"
+                                    value={passkeyCode}
+                                    onChange={(e) => setPasskeyCode(e.target.value)}
+                                    placeholder="รหัสยืนยันตัวตน 2 ขั้นตอน (ถ้าเปิดใช้)"
+                                    className="modern-input-small"
+                                />
+                            </div>
+                            <button type="button" onClick={handleAddPasskey} className="btn-ghost">🔑 เพิ่มพาสคีย์สำหรับอุปกรณ์นี้</button>
+                            {passkeyMessage && <p className="preview-label">{passkeyMessage}</p>}
+                        </div>
+                    )}
+
+                    {onLinkIdentity && providers.length > 0 && (
+                        <div className="form-section">
+                            <label className="section-label">เชื่อมบัญชีเพื่อเข้าสู่ระบบ</label>
+                            {providers.map(p => (
+                                <button
+                                    type="button"
+                                    key={p.id}
+                                    onClick={() => onLinkIdentity(p.id).catch(err => setPasskeyMessage(err.message))}
+                                    className="btn-ghost"
+                                >
+                                    เชื่อมกับ {p.name}
+                                </button>
+                            ))}
+                        </div>
+                    )}
+
+                    {(onExportAccount || onDeleteAccount) && (
+                        <div className="form-section">
+                            <label className="section-label">ข้อมูลและบัญชี</label>
+                            {onExportAccount && (
+                                <button type="button" onClick={() => onExportAccount().catch(err => setAccountMessage(err.message))} className="btn-ghost">
+                                    ⬇️ ดาวน์โหลดข้อมูลทั้งหมดของฉัน
+                                </button>
+                            )}
+                            {onDeleteAccount && !showDelete && (
+                                <button type="button" onClick={() => setShowDelete(true)} className="btn-ghost" style={{ color: 'hsl(0, 70%, 45%)' }}>
+                                    ลบบัญชีถาวร
+                                </button>
+                            )}
+                            {onDeleteAccount && showDelete && (
+                                <div className="input-wrapper" style={{ display: 'flex', flexDirection: 'column', gap: '8px' }}>
+                                    <p className="preview-label">บันทึก การสะท้อนคิด และไฟล์แนบทั้งหมดจะถูกลบและกู้คืนไม่ได้ ความคิดเห็นที่คุณเขียนในบันทึกของผู้อื่นจะกลายเป็นนิรนาม</p>
+                                    <input
+                                        type="password"
+                                        value={deletePassword}
+                                        onChange={(e) => setDeletePassword(e.target.value)}
+                                        placeholder="รหัสผ่าน"
+                                        className="modern-input-small"
+                                    />
+                                    <input
+                                        type="text"
+                                        value={deleteConfirm}
+                                        onChange={(e) => setDeleteConfirm(e.target.value)}
+                                        placeholder={`พิมพ์ ${currentUser.username} เพื่อยืนยัน`}
+                                        className="modern-input-small"
+                                    />
+                                    <label className="preview-label">
+                                        <input type="checkbox" checked={exportFirst} onChange={(e) => setExportFirst(e.target.checked)} /> ดาวน์โหลดข้อมูลก่อนลบ
+                                    </label>
+                                    <button
+                                        type="button"
+                                        onClick={handleDeleteAccount}
+                                        disabled={deleteConfirm !== currentUser.username}
+                                        className="btn-ghost"
+                                        style={{ color: 'hsl(0, 70%, 45%)' }}
+                                    >
+                                        ลบบัญชีของฉัน
+                                    </button>
+                                </div>
+                            )}
+                            {accountMessage && <p className="preview-label">{accountMessage}</p>}
+                        </div>
+                    )}
+
                     <div className="modal-footer">
                         <button type="button" onClick={onClose} className="btn-ghost">ยกเลิก</button>
                         <button type="submit" disabled={loading} className="btn-gradient">
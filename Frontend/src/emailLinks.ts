@@ -0,0 +1,38 @@
+// Links sent by email open /verify-email?token=... or /reset-password?token=...
+// in the app, which posts the token back to the backend.
+
+export type EmailLink = { kind: 'verify-email' | 'reset-password'; token: string };
+
+// takeEmailLink reads the token from an email link and clears it from the address bar
+export const takeEmailLink = (): EmailLink | null => {
+  const path = window.location.pathname;
+  if (path !== '/verify-email' && path !== '/reset-password') return null;
+
+  const token = new URLSearchParams(window.location.search).get('token') || '';
+  window.history.replaceState({}, '', '/');
+  return { kind: path === '/verify-email' ? 'verify-email' : 'reset-password', token };
+};
+
+const postToken = async (url: string, body: object): Promise<any> => {
+  const res = await fetch(url, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(data.error === 'Invalid or expired link'
+      ? 'ลิงก์ไม่ถูกต้องหรือหมดอายุแล้ว'
+      : data.error || 'เกิดข้อผิดพลาด กรุณาลองอีกครั้ง');
+  }
+  return data;
+};
+
+export const verifyEmail = (token: string) => postToken('/api/verify-email', { token });
+
+export const resetPassword = (token: string, password: string) =>
+  postToken('/api/password/reset', { token, password });
+
+// requestPasswordReset always succeeds for a well-formed request, so it does not
+// tell anyone which usernames or emails have an account
+export const requestPasswordReset = (login: string) => postToken('/api/password/forgot', { login });
@@ -0,0 +1,56 @@
+// Single sign-on with OpenID Connect providers. The provider sends the browser
+// back to /oidc/callback, which hands the code to the backend. Starting a flow sets
+// an HttpOnly cookie that the callback must send back, so the requests carry cookies.
+
+export interface OIDCProvider {
+  id: string;
+  name: string;
+}
+
+export const fetchOIDCProviders = async (): Promise<OIDCProvider[]> => {
+  try {
+    const res = await fetch('/api/oidc/providers');
+    return res.ok ? await res.json() : [];
+  } catch {
+    return [];
+  }
+};
+
+// startOIDCLogin leaves the app for the provider's login page
+export const startOIDCLogin = async (provider: string): Promise<void> => {
+  const res = await fetch(`/api/oidc/${provider}/start`, { credentials: 'same-origin' });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || 'ไม่สามารถเชื่อมต่อผู้ให้บริการได้');
+  window.location.href = data.url;
+};
+
+// startOIDCLink does the same for a logged in user linking another identity
+export const startOIDCLink = async (
+  fetcher: (url: string, options?: RequestInit) => Promise<Response>,
+  provider: string,
+): Promise<void> => {
+  const res = await fetcher(`/api/oidc/${provider}/link`, { method: 'POST', credentials: 'same-origin' });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || 'ไม่สามารถเชื่อมต่อผู้ให้บริการได้');
+  window.location.href = data.url;
+};
+
+export const isOIDCCallback = () => window.location.pathname === '/oidc/callback';
+
+// completeOIDC posts the code from the callback URL and returns the backend's answer:
+// tokens, a 2FA challenge, or the identity that was linked
+export const completeOIDC = async (): Promise<any> => {
+  const params = new URLSearchParams(window.location.search);
+  window.history.replaceState({}, '', '/');
+
+  if (params.get('error')) throw new Error(params.get('error_description') || 'เข้าสู่ระบบไม่สำเร็จ');
+  const res = await fetch('/api/oidc/callback', {
+    method: 'POST',
+    credentials: 'same-origin',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ state: params.get('state'), code: params.get('code') }),
+  });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error || 'เข้าสู่ระบบไม่สำเร็จ');
+  return data;
+};
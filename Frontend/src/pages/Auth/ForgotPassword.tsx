@@ -0,0 +1,91 @@
+import React, { useState } from 'react';
+import { requestPasswordReset } from '../../emailLinks';
+
+interface ForgotPasswordProps {
+  onNavigateToLogin: () => void;
+}
+
+const ForgotPassword: React.FC<ForgotPasswordProps> = ({ onNavigateToLogin }) => {
+  const [login, setLogin] = useState('');
+  const [error, setError] = useState('');
+  const [isLoading, setIsLoading] = useState(false);
+  const [sent, setSent] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setIsLoading(true);
+    try {
+      await requestPasswordReset(login);
+      setSent(true);
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div style={{ width: '100%', padding: '24px' }}>
+      <h2 style={{ color: 'hsl(220, 25%, 18%)', textAlign: 'center', marginBottom: '8px' }}>ลืมรหัสผ่าน</h2>
+
+      {sent ? (
+        <p style={{ color: 'hsl(220, 15%, 45%)', textAlign: 'center', margin: '16px 0 24px' }}>
+          หากบัญชีนี้มีอีเมลที่ยืนยันแล้ว เราได้ส่งลิงก์สำหรับตั้งรหัสผ่านใหม่ไปให้ ลิงก์ใช้ได้ภายใน 1 ชั่วโมง
+        </p>
+      ) : (
+        <>
+          <p style={{ color: 'hsl(220, 15%, 45%)', textAlign: 'center', marginBottom: '24px', fontSize: '0.9rem' }}>
+            กรอกชื่อผู้ใช้หรืออีเมล เราจะส่งลิงก์ตั้งรหัสผ่านใหม่ไปยังอีเมลที่ยืนยันแล้วของบัญชี
+          </p>
+
+          {error && (
+            <div style={{ background: 'hsla(0, 80%, 95%, 1)', border: '1px solid hsla(0, 70%, 80%, 0.5)', color: 'hsl(0, 70%, 45%)', padding: '10px', borderRadius: '8px', marginBottom: '16px', fontSize: '0.9rem' }}>
+              {error}
+            </div>
+          )}
+
+          <form onSubmit={handleSubmit} style={{ display: 'flex', flexDirection: 'column', gap: '16px' }}>
+            <input
+              type="text"
+              value={login}
+              onChange={(e) => setLogin(e.target.value)}
+              className="diary-input"
+              autoComplete="username"
+              autoFocus
+              style={{
+                width: '100%',
+                minHeight: '48px',
+                background: 'rgba(255, 255, 255, 0.9)',
+                border: '1px solid hsl(220, 20%, 85%)',
+                padding: '12px',
+                borderRadius: '12px',
+                color: 'hsl(220, 25%, 18%)',
+                fontSize: '1rem',
+                lineHeight: 'normal'
+              }}
+              placeholder="ชื่อผู้ใช้หรืออีเมล"
+              required
+            />
+
+            <button type="submit" className="btn-primary" style={{ width: '100%' }} disabled={isLoading}>
+              {isLoading ? 'กำลังส่ง...' : 'ส่งลิงก์'}
+            </button>
+          </form>
+        </>
+      )}
+
+      <div style={{ marginTop: '24px', textAlign: 'center' }}>
+        <button
+          onClick={onNavigateToLogin}
+          className="btn-text"
+          style={{ color: 'var(--accent)', padding: '0' }}
+        >
+          กลับไปหน้าเข้าสู่ระบบ
+        </button>
+      </div>
+    </div>
+  );
+};
+
+export default ForgotPassword;
@@ -1,15 +1,33 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
+import { loginWithPasskey, passkeysSupported } from '../../passkeys';
+import { fetchOIDCProviders, startOIDCLogin, type OIDCProvider } from '../../oidc';
 
 interface LoginProps {
+  initialChallenge?: string; // Set when a single sign-on login still needs the 2FA code
   onLoginSuccess: (username: string) => void;
   onNavigateToRegister: () => void;
+  onNavigateToForgotPassword: () => void;
 }
 
-const Login: React.FC<LoginProps> = ({ onLoginSuccess, onNavigateToRegister }) => {
+const Login: React.FC<LoginProps> = ({ initialChallenge, onLoginSuccess, onNavigateToRegister, onNavigateToForgotPassword }) => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [isLoading, setIsLoading] = useState(false);
+  const [challengeToken, setChallengeToken] = useState(initialChallenge || '');
+  const [code, setCode] = useState('');
+  const [useRecovery, setUseRecovery] = useState(false);
+  const [providers, setProviders] = useState<OIDCProvider[]>([]);
+
+  useEffect(() => {
+    fetchOIDCProviders().then(setProviders);
+  }, []);
+
+  const finishLogin = (data: any) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refreshToken', data.refreshToken);
+    onLoginSuccess(data.username || username);
+  };
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -25,9 +43,10 @@ const Login: React.FC<LoginProps> = ({ onLoginSuccess, onNavigateToRegister }) =
 
       const data = await response.json();
 
-      if (response.ok) {
-        localStorage.setItem('token', data.token);
-        onLoginSuccess(data.username || username);
+      if (response.ok && data.twoFactorRequired) {
+        setChallengeToken(data.challengeToken);
+      } else if (response.ok) {
+        finishLogin(data);
       } else {
         setError(data.error || 'Login failed');
       }
@@ -38,6 +57,108 @@ const Login: React.FC<LoginProps> = ({ onLoginSuccess, onNavigateToRegister }) =
     }
   };
 
+  const handleSecondFactor = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setIsLoading(true);
+
+    try {
+      const response = await fetch('/api/login/2fa', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(useRecovery ? { challengeToken, recoveryCode: code } : { challengeToken, code }),
+      });
+
+      const data = await response.json();
+
+      if (response.ok) {
+        finishLogin(data);
+      } else if (response.status === 401 && data.error !== 'Invalid code') {
+        // The challenge expired or ran out of attempts; start over from the password
+        setChallengeToken('');
+        setCode('');
+        setError('หมดเวลายืนยันตัวตน กรุณาเข้าสู่ระบบอีกครั้ง');
+      } else {
+        setError('รหัสไม่ถูกต้อง');
+      }
+    } catch (err) {
+      setError('An error occurred. Please try again.');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  const handlePasskey = async () => {
+    setError('');
+    setIsLoading(true);
+    try {
+      finishLogin(await loginWithPasskey(username));
+    } catch (err: any) {
+      // NotAllowedError means the user closed the passkey prompt
+      if (err?.name !== 'NotAllowedError') setError(err?.message || 'เข้าสู่ระบบด้วยพาสคีย์ไม่สำเร็จ');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  if (challengeToken) {
+    return (
+      <div style={{ width: '100%', padding: '24px' }}>
+        <h2 style={{ color: 'hsl(220, 25%, 18%)', textAlign: 'center', marginBottom: '8px' }}>ยืนยันตัวตนสองขั้นตอน</h2>
+        <p style={{ color: 'hsl(220, 15%, 45%)', textAlign: 'center', marginBottom: '24px', fontSize: '0.9rem' }}>
+          {useRecovery ? 'กรอกรหัสกู้คืนที่คุณบันทึกไว้' : 'กรอกรหัส 6 หลักจากแอปยืนยันตัวตนของคุณ'}
+        </p>
+
+        {error && (
+          <div style={{ background: 'hsla(0, 80%, 95%, 1)', border: '1px solid hsla(0, 70%, 80%, 0.5)', color: 'hsl(0, 70%, 45%)', padding: '10px', borderRadius: '8px', marginBottom: '16px', fontSize: '0.9rem' }}>
+            {error}
+          </div>
+        )}
+
+        <form onSubmit={handleSecondFactor} style={{ display: 'flex', flexDirection: 'column', gap: '16px' }}>
+          <input
+            type="text"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            className="diary-input"
+            inputMode={useRecovery ? 'text' : 'numeric'}
+            autoComplete="one-time-code"
+            autoFocus
+            style={{
+              width: '100%',
+              minHeight: '48px',
+              background: 'rgba(255, 255, 255, 0.9)',
+              border: '1px solid hsl(220, 20%, 85%)',
+              padding: '12px',
+              borderRadius: '12px',
+              color: 'hsl(220, 25%, 18%)',
+              fontSize: '1.2rem',
+              letterSpacing: '0.2em',
+              textAlign: 'center',
+              lineHeight: 'normal'
+            }}
+            placeholder={useRecovery ? 'xxxxx-xxxxx' : '123456'}
+            required
+          />
+
+          <button type="submit" className="btn-primary" style={{ width: '100%' }} disabled={isLoading}>
+            {isLoading ? 'กำลังตรวจสอบ...' : 'ยืนยัน'}
+          </button>
+        </form>
+
+        <div style={{ marginTop: '24px', textAlign: 'center' }}>
+          <button
+            onClick={() => { setUseRecovery(!useRecovery); setCode(''); setError(''); }}
+            className="btn-text"
+            style={{ color: 'var(--accent)', padding: '0' }}
+          >
+            {useRecovery ? 'ใช้รหัสจากแอปแทน' : 'ใช้รหัสกู้คืนแทน'}
+          </button>
+        </div>
+      </div>
+    );
+  }
+
   return (
     <div style={{ width: '100%', padding: '24px' }}>
       <h2 style={{ color: 'hsl(220, 25%, 18%)', textAlign: 'center', marginBottom: '24px' }}>ยินดีต้อนรับกลับมา</h2>
@@ -93,6 +214,14 @@ const Login: React.FC<LoginProps> = ({ onLoginSuccess, onNavigateToRegister }) =
             placeholder="กรอกรหัสผ่าน"
             required
           />
+          <button
+            type="button"
+            onClick={onNavigateToForgotPassword}
+            className="btn-text"
+            style={{ color: 'var(--accent)', padding: '0', marginTop: '6px', fontSize: '0.85rem', float: 'right' }}
+          >
+            ลืมรหัสผ่าน?
+          </button>
         </div>
 
         <button
@@ -103,6 +232,31 @@ const Login: React.FC<LoginProps> = ({ onLoginSuccess, onNavigateToRegister }) =
         >
           {isLoading ? 'กำลังเข้าสู่ระบบ...' : 'เข้าสู่ระบบ'}
         </button>
+
+        {passkeysSupported() && (
+          <button
+            type="button"
+            className="btn-text"
+            onClick={handlePasskey}
+            disabled={isLoading}
+            style={{ width: '100%', color: 'var(--accent)' }}
+          >
+            🔑 เข้าสู่ระบบด้วยพาสคีย์
+          </button>
+        )}
+
+        {providers.map((p) => (
+          <button
+            key={p.id}
+            type="button"
+            className="btn-ghost"
+            onClick={() => startOIDCLogin(p.id).catch((err) => setError(err.message))}
+            disabled={isLoading}
+            style={{ width: '100%' }}
+          >
+            เข้าสู่ระบบด้วย {p.name}
+          </button>
+        ))}
       </form>
 
       <div style={{ marginTop: '24px', textAlign: 'center' }}>
@@ -0,0 +1,112 @@
+import React, { useState } from 'react';
+import { resetPassword } from '../../emailLinks';
+
+interface ResetPasswordProps {
+  token: string;
+  onResetSuccess: () => void;
+}
+
+const inputStyle: React.CSSProperties = {
+  width: '100%',
+  minHeight: '48px',
+  background: 'rgba(255, 255, 255, 0.9)',
+  border: '1px solid hsl(220, 20%, 85%)',
+  padding: '12px',
+  borderRadius: '12px',
+  color: 'hsl(220, 25%, 18%)',
+  fontSize: '1rem',
+  lineHeight: 'normal'
+};
+
+const ResetPassword: React.FC<ResetPasswordProps> = ({ token, onResetSuccess }) => {
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [error, setError] = useState('');
+  const [isLoading, setIsLoading] = useState(false);
+  const [done, setDone] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    if (password !== confirmPassword) {
+      setError('รหัสผ่านทั้งสองช่องไม่ตรงกัน');
+      return;
+    }
+
+    setIsLoading(true);
+    try {
+      await resetPassword(token, password);
+      // Every session was ended, including this browser's
+      localStorage.removeItem('token');
+      localStorage.removeItem('refreshToken');
+      setDone(true);
+    } catch (err: any) {
+      setError(err.message);
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  if (done) {
+    return (
+      <div style={{ width: '100%', padding: '24px', textAlign: 'center' }}>
+        <h2 style={{ color: 'hsl(220, 25%, 18%)', marginBottom: '16px' }}>ตั้งรหัสผ่านใหม่แล้ว</h2>
+        <p style={{ color: 'hsl(220, 15%, 45%)', marginBottom: '24px' }}>
+          ทุกอุปกรณ์ถูกออกจากระบบแล้ว กรุณาเข้าสู่ระบบด้วยรหัสผ่านใหม่
+        </p>
+        <button className="btn-primary" style={{ width: '100%' }} onClick={onResetSuccess}>
+          ไปหน้าเข้าสู่ระบบ
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <div style={{ width: '100%', padding: '24px' }}>
+      <h2 style={{ color: 'hsl(220, 25%, 18%)', textAlign: 'center', marginBottom: '24px' }}>ตั้งรหัสผ่านใหม่</h2>
+
+      {error && (
+        <div style={{ background: 'hsla(0, 80%, 95%, 1)', border: '1px solid hsla(0, 70%, 80%, 0.5)', color: 'hsl(0, 70%, 45%)', padding: '10px', borderRadius: '8px', marginBottom: '16px', fontSize: '0.9rem' }}>
+          {error}
+        </div>
+      )}
+
+      <form onSubmit={handleSubmit} style={{ display: 'flex', flexDirection: 'column', gap: '16px' }}>
+        <div>
+          <label style={{ color: 'hsl(220, 15%, 45%)', fontSize: '0.9rem', marginBottom: '4px', display: 'block' }}>รหัสผ่านใหม่</label>
+          <input
+            type="password"
+            value={password}
+            onChange={(e) => setPassword(e.target.value)}
+            className="diary-input"
+            autoComplete="new-password"
+            autoFocus
+            style={inputStyle}
+            placeholder="กรอกรหัสผ่านใหม่"
+            required
+          />
+        </div>
+
+        <div>
+          <label style={{ color: 'hsl(220, 15%, 45%)', fontSize: '0.9rem', marginBottom: '4px', display: 'block' }}>ยืนยันรหัสผ่านใหม่</label>
+          <input
+            type="password"
+            value={confirmPassword}
+            onChange={(e) => setConfirmPassword(e.target.value)}
+            className="diary-input"
+            autoComplete="new-password"
+            style={inputStyle}
+            placeholder="กรอกรหัสผ่านใหม่อีกครั้ง"
+            required
+          />
+        </div>
+
+        <button type="submit" className="btn-primary" style={{ marginTop: '16px', width: '100%' }} disabled={isLoading}>
+          {isLoading ? 'กำลังบันทึก...' : 'ตั้งรหัสผ่านใหม่'}
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default ResetPassword;
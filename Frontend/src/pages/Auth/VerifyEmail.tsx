@@ -0,0 +1,57 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { verifyEmail } from '../../emailLinks';
+
+interface VerifyEmailProps {
+  token: string;
+  onVerified: () => void; // Lets the app refresh the profile's verified badge
+  onClose: () => void;
+}
+
+const VerifyEmail: React.FC<VerifyEmailProps> = ({ token, onVerified, onClose }) => {
+  const [status, setStatus] = useState<'pending' | 'done' | 'failed'>('pending');
+  const [message, setMessage] = useState('');
+  const sent = useRef(false);
+
+  useEffect(() => {
+    // The token works once, so don't post it again when the effect re-runs
+    if (sent.current) return;
+    sent.current = true;
+
+    verifyEmail(token)
+      .then((data) => {
+        setStatus('done');
+        setMessage(`ยืนยันอีเมล ${data.email} เรียบร้อยแล้ว`);
+        onVerified();
+      })
+      .catch((err) => {
+        setStatus('failed');
+        setMessage(err.message);
+      });
+  }, [token]);
+
+  return (
+    <div style={{ width: '100%', padding: '24px', textAlign: 'center' }}>
+      <h2 style={{ color: 'hsl(220, 25%, 18%)', marginBottom: '16px' }}>ยืนยันอีเมล</h2>
+
+      {status === 'pending' && <p style={{ color: 'hsl(220, 15%, 45%)' }}>กำลังยืนยัน...</p>}
+
+      {status === 'done' && (
+        <p style={{ color: 'hsl(150, 50%, 35%)', marginBottom: '24px' }}>✅ {message}</p>
+      )}
+
+      {status === 'failed' && (
+        <div style={{ background: 'hsla(0, 80%, 95%, 1)', border: '1px solid hsla(0, 70%, 80%, 0.5)', color: 'hsl(0, 70%, 45%)', padding: '10px', borderRadius: '8px', marginBottom: '24px', fontSize: '0.9rem' }}>
+          {message} ขอลิงก์ใหม่ได้จากหน้าตั้งค่าโปรไฟล์
+        </div>
+      )}
+
+      {status !== 'pending' && (
+        <button className="btn-primary" style={{ width: '100%' }} onClick={onClose}>
+          ตกลง
+        </button>
+      )}
+    </div>
+  );
+};
+
+export default VerifyEmail;
@@ -0,0 +1,96 @@
+// WebAuthn helpers. The server sends binary fields as base64url strings,
+// the browser API wants ArrayBuffers, so both directions are converted here.
+
+const fromB64url = (value: string): ArrayBuffer => {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  const binary = atob(base64 + '='.repeat((4 - (base64.length % 4)) % 4));
+  return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+};
+
+const toB64url = (buffer: ArrayBuffer | null): string => {
+  if (!buffer) return '';
+  let binary = '';
+  new Uint8Array(buffer).forEach(b => { binary += String.fromCharCode(b); });
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+};
+
+export const passkeysSupported = () => typeof window !== 'undefined' && !!window.PublicKeyCredential;
+
+// registerPasskey runs the create ceremony; fetcher must send the user's access token.
+// The server asks for the password (and a 2FA code when enabled) before it starts.
+export const registerPasskey = async (
+  fetcher: (url: string, options?: RequestInit) => Promise<Response>,
+  confirm: { password?: string; code?: string } = {},
+  name = '',
+): Promise<void> => {
+  const begin = await fetcher('/api/passkeys/register/begin', { method: 'POST', body: JSON.stringify(confirm) });
+  if (!begin.ok) throw new Error((await begin.json()).error || 'ไม่สามารถเริ่มเพิ่มพาสคีย์ได้');
+  const { publicKey } = await begin.json();
+
+  const credential = await navigator.credentials.create({
+    publicKey: {
+      ...publicKey,
+      challenge: fromB64url(publicKey.challenge),
+      user: { ...publicKey.user, id: fromB64url(publicKey.user.id) },
+      excludeCredentials: publicKey.excludeCredentials.map((c: any) => ({ ...c, id: fromB64url(c.id) })),
+    },
+  }) as PublicKeyCredential | null;
+  if (!credential) throw new Error('ยกเลิกการเพิ่มพาสคีย์');
+
+  const response = credential.response as AuthenticatorAttestationResponse;
+  const finish = await fetcher('/api/passkeys/register/finish', {
+    method: 'POST',
+    body: JSON.stringify({
+      name,
+      credential: {
+        id: credential.id,
+        type: credential.type,
+        response: {
+          clientDataJSON: toB64url(response.clientDataJSON),
+          attestationObject: toB64url(response.attestationObject),
+          transports: response.getTransports ? response.getTransports() : [],
+        },
+      },
+    }),
+  });
+  if (!finish.ok) throw new Error((await finish.json()).error || 'เพิ่มพาสคีย์ไม่สำเร็จ');
+};
+
+// loginWithPasskey runs the get ceremony and returns the login response (tokens + profile)
+export const loginWithPasskey = async (username = ''): Promise<any> => {
+  const begin = await fetch('/api/login/passkey/begin', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username }),
+  });
+  if (!begin.ok) throw new Error((await begin.json()).error || 'ไม่สามารถเข้าสู่ระบบด้วยพาสคีย์ได้');
+  const { publicKey } = await begin.json();
+
+  const credential = await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: fromB64url(publicKey.challenge),
+      allowCredentials: publicKey.allowCredentials.map((c: any) => ({ ...c, id: fromB64url(c.id) })),
+    },
+  }) as PublicKeyCredential | null;
+  if (!credential) throw new Error('ยกเลิกการเข้าสู่ระบบด้วยพาสคีย์');
+
+  const response = credential.response as AuthenticatorAssertionResponse;
+  const finish = await fetch('/api/login/passkey/finish', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({
+      id: credential.id,
+      type: credential.type,
+      response: {
+        clientDataJSON: toB64url(response.clientDataJSON),
+        authenticatorData: toB64url(response.authenticatorData),
+        signature: toB64url(response.signature),
+        userHandle: toB64url(response.userHandle),
+      },
+    }),
+  });
+  const data = await finish.json();
+  if (!finish.ok) throw new Error(data.error || 'เข้าสู่ระบบด้วยพาสคีย์ไม่สำเร็จ');
+  return data;
+};